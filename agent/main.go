@@ -2,52 +2,220 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/nox/noxflow/agent/utils"
 	"github.com/nox/noxflow/agent/worker/docker"
+	"github.com/nox/noxflow/agent/worker/heartbeat"
 )
 
 func main() {
 
+	// Load the agent configuration
+	cfg := utils.LoadConfig()
+
+	// Parse the container selectors
+	filter, err := utils.NewContainerFilter(cfg.Include, cfg.Exclude)
+	if err != nil {
+		log.Fatalf("Invalid container filter: %v", err)
+	}
+
+	// Set up the redaction of sensitive data in the logs
+	var customDetectors []*utils.Detector
+	for _, expr := range cfg.RedactPatterns {
+		detector, err := utils.ParseDetector(expr)
+		if err != nil {
+			log.Fatalf("Invalid redaction pattern: %v", err)
+		}
+		customDetectors = append(customDetectors, detector)
+	}
+	redactor, err := utils.NewRedactor(cfg.RedactDetectors, customDetectors, utils.RedactAction(cfg.RedactAction), cfg.RedactHashKey)
+	if err != nil {
+		log.Fatalf("Invalid redaction settings: %v", err)
+	}
+
+	// Set up the per-container log rate limiting and sampling
+	var samplePatterns []*regexp.Regexp
+	for _, expr := range cfg.SamplePatterns {
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			log.Fatalf("Invalid sample pattern %q: %v", expr, err)
+		}
+		samplePatterns = append(samplePatterns, pattern)
+	}
+	var keepPattern *regexp.Regexp
+	if cfg.KeepPattern != "" {
+		keepPattern, err = regexp.Compile(cfg.KeepPattern)
+		if err != nil {
+			log.Fatalf("Invalid keep pattern %q: %v", cfg.KeepPattern, err)
+		}
+	}
+	limiter := utils.NewLogLimiter(cfg.LogLimits, samplePatterns, keepPattern)
+
 	// Initialize Docker client
-	err := utils.InitDockerClient()
+	utils.CgroupRoot = cfg.CgroupRoot
+	err = utils.InitDockerClient()
 	if err != nil {
 		log.Fatalf("Failed to initialize Docker client: %v", err)
 	}
 
-	// Create a context
-	ctx := context.Background()
+	// The collectors stop on SIGINT or SIGTERM, then the outputs are drained
+	signalCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// List Docker containers
-	dockerContainers, err := utils.DockerListContainers(ctx)
+	// The agent also stops, to be restarted, when it loses the Docker daemon
+	ctx, restart := context.WithCancelCause(signalCtx)
+	defer restart(nil)
+	watchdog := utils.NewDockerWatchdog(cfg.DockerLostTimeout)
+	go watchdog.Run(ctx, dockerPingInterval, func(err error) {
+		log.Printf("Lost the Docker daemon: %v", err)
+		restart(fmt.Errorf("%w: %v", errDockerLost, err))
+	})
+
+	// Load the log positions saved by the previous run
+	checkpoints, err := utils.LoadCheckpoints(cfg.CheckpointFile, cfg.CheckpointMaxAge)
 	if err != nil {
-		log.Fatalf("Error listing containers: %v", err)
+		log.Printf("Starting without checkpoints: %v", err)
+		checkpoints, _ = utils.LoadCheckpoints("", 0)
 	}
+	go checkpoints.Run(ctx, cfg.CheckpointInterval)
 
-	// Initialize monitorClient
-	monitorClient, err := utils.NewMonitorClient("localhost:8888", 5)
+	// Initialize the outputs, monitorClient is only set with the grpc output
+	sink, monitorClient, err := utils.NewSink(cfg)
 	if err != nil {
-		log.Fatalf("Failed to create monitor client: %v", err)
+		log.Fatalf("Failed to create the outputs: %v", err)
 	}
-	defer monitorClient.Close()
+	checkpoints.TrackSink(sink)
 
-	var wg sync.WaitGroup
+	// Start the Prometheus exporter if enabled, it needs usage collection to have data
+	var exporter *utils.MetricsExporter
+	if cfg.MetricsAddr != "" {
+		exporter, err = utils.NewMetricsExporter(cfg.MetricsLabels)
+		if err != nil {
+			log.Fatalf("Invalid -metrics-labels: %v", err)
+		}
+		go func() {
+			if err := exporter.Serve(cfg.MetricsAddr); err != nil {
+				log.Printf("Metrics exporter stopped: %v", err)
+			}
+		}()
+	}
+	collectUsage := cfg.CollectUsage || exporter != nil
 
-	if len(dockerContainers) == 0 {
-		log.Println("No containers found!!!!")
-	} else {
-		for _, container := range dockerContainers {
-			wg.Add(1)
-			log.Printf("Container ID: %s", container.ID)
-			// Start streaming logs
-			go docker.GetDockerContainerLogs(container.ID, monitorClient, &wg)
-			// If you also want to start streaming usage, uncomment the next line
-			// go docker.GetDockerContainerUsage(container.ID, &wg, false)
+	// The agent is live while it can reach the Docker daemon, and ready while its
+	// outputs can ship the data too
+	health := utils.NewHealth()
+	health.AddLiveness("docker", watchdog.Alive)
+	health.AddReadiness("docker", watchdog.Ready)
+	if checker, ok := sink.(utils.HealthChecker); ok {
+		health.AddReadiness("outputs", checker.CheckHealth)
+	}
+	health.AddReadiness("shutdown", func(context.Context) error {
+		if ctx.Err() != nil {
+			return fmt.Errorf("shutting down")
 		}
+		return nil
+	})
+
+	// Track the collectors and expose their status and health locally
+	registry := utils.NewStatusRegistry()
+	if cfg.StatusAddr != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/status", registry)
+			mux.Handle("/healthz", health.LivenessHandler())
+			mux.Handle("/readyz", health.ReadinessHandler())
+			log.Printf("Serving agent status on %s/status, health on /healthz and /readyz", cfg.StatusAddr)
+			if err := http.ListenAndServe(cfg.StatusAddr, mux); err != nil {
+				log.Printf("Status server stopped: %v", err)
+			}
+		}()
 	}
 
-	wg.Wait()
+	// Report the collector statuses to the server
+	if monitorClient != nil {
+		go heartbeat.SendHeartbeats(ctx, monitorClient, registry, cfg.HeartbeatInterval)
+	}
+
+	var wg sync.WaitGroup
 
+	// Start the collectors of the containers that pass the filter, labels can override
+	// the collection settings per container
+	supervisor := docker.NewSupervisor(ctx, checkpoints, sink, exporter, redactor, limiter, registry, filter, utils.CollectionSettings{
+		Logs:   true,
+		Usage:  collectUsage,
+		Events: cfg.CollectEvents,
+	}, &wg)
+
+	// Watch the container events first so no container started meanwhile is missed
+	wg.Add(1)
+	go docker.GetDockerContainerEvents(ctx, supervisor.HandleEvent, &wg)
+
+	if err := supervisor.WatchRunning(ctx); err != nil {
+		log.Fatalf("Error listing containers: %v", err)
+	}
+
+	<-ctx.Done()
+	// A second signal kills the agent without waiting for the drain
+	stop()
+	shutdown(&wg, sink, checkpoints, cfg.ShutdownTimeout)
+
+	// Exit with an error so the agent is restarted by its supervisor
+	if cause := context.Cause(ctx); errors.Is(cause, errDockerLost) {
+		log.Fatalf("Exiting to be restarted: %v", cause)
+	}
+}
+
+// dockerPingInterval is how often the Docker daemon is pinged by the watchdog
+const dockerPingInterval = 10 * time.Second
+
+// errDockerLost stops the agent when the Docker daemon is unreachable for too long
+var errDockerLost = errors.New("lost the Docker daemon")
+
+// shutdown waits for the collectors to ship the lines they read, flushes the outputs and
+// saves the checkpoints, giving up once timeout is over
+func shutdown(wg *sync.WaitGroup, sink utils.Sink, checkpoints *utils.Checkpoints, timeout time.Duration) {
+	log.Printf("Shutting down, draining for up to %v", timeout)
+	drainCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	collectorsDone := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(collectorsDone)
+	}()
+	select {
+	case <-collectorsDone:
+	case <-drainCtx.Done():
+		log.Printf("Collectors didn't stop in %v, saving the checkpoints of the lines sent so far", timeout)
+	}
+
+	sinkClosed := make(chan error, 1)
+	go func() {
+		sinkClosed <- sink.Close()
+	}()
+	select {
+	case err := <-sinkClosed:
+		if err != nil {
+			log.Printf("Error closing the outputs: %v", err)
+		}
+	case <-drainCtx.Done():
+		log.Printf("Outputs not drained in %v, saving the checkpoints of the lines sent so far", timeout)
+	}
+
+	// The queued lines are sent once the outputs are closed, the checkpoints only cover
+	// the lines sent
+	if err := checkpoints.Save(); err != nil {
+		log.Printf("Error saving checkpoints: %v", err)
+	}
+	log.Printf("Shutdown complete")
 }
@@ -0,0 +1,159 @@
+package compression
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	pb "github.com/nox/noxflow/agent/pkg/proto"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/protobuf/proto"
+)
+
+// sampleLogs is a realistic application log, one log line per line
+const sampleLogs = "../../../Test/app/app_logs.txt"
+
+// frameHeaderSize is the gRPC message prefix: compressed flag and length
+const frameHeaderSize = 5
+
+// readMessages reads the sample logs into serialized LogData messages, the way the agent
+// ships them: one line per message, each compressed on its own
+func readMessages(tb testing.TB) [][]byte {
+	tb.Helper()
+	f, err := os.Open(sampleLogs)
+	if err != nil {
+		tb.Skipf("sample logs not available: %v", err)
+	}
+	defer f.Close()
+
+	metadata := &pb.ContainerLogMetadata{
+		ContainerId:   "4f1c2d3e5a6b7c8d9e0f1a2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c2d",
+		ContainerName: "/app",
+		Image:         "noxflow/test-app:latest",
+		State:         "running",
+		LogPath:       "/var/lib/docker/containers/4f1c2d3e5a6b/4f1c2d3e5a6b-json.log",
+		LogDriver:     "json-file",
+	}
+
+	var messages [][]byte
+	scanner := bufio.NewScanner(f)
+	timestamp := time.Date(2024, 11, 4, 10, 21, 30, 0, time.UTC)
+	for scanner.Scan() {
+		// Lines read from Docker carry a timestamp
+		timestamp = timestamp.Add(5 * time.Second)
+		line := timestamp.Format(time.RFC3339Nano) + " " + scanner.Text()
+
+		message, err := proto.Marshal(&pb.LogData{Metadata: metadata, Log: line})
+		if err != nil {
+			tb.Fatal(err)
+		}
+		messages = append(messages, message)
+	}
+	if err := scanner.Err(); err != nil {
+		tb.Fatal(err)
+	}
+	if len(messages) == 0 {
+		tb.Fatalf("no log lines in %s", sampleLogs)
+	}
+	return messages
+}
+
+func compress(tb testing.TB, compressor encoding.Compressor, message []byte) []byte {
+	var buf bytes.Buffer
+	w, err := compressor.Compress(&buf)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if _, err := w.Write(message); err != nil {
+		tb.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		tb.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decompress(tb testing.TB, compressor encoding.Compressor, data []byte) []byte {
+	r, err := compressor.Decompress(bytes.NewReader(data))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	message, err := io.ReadAll(r)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return message
+}
+
+func TestZstdRoundTrip(t *testing.T) {
+	compressor := encoding.GetCompressor(Zstd)
+	if compressor == nil {
+		t.Fatal("zstd compressor is not registered")
+	}
+
+	// Twice so the pooled encoders and decoders are reused
+	messages := readMessages(t)
+	for range 2 {
+		for _, message := range messages {
+			if got := decompress(t, compressor, compress(t, compressor, message)); !bytes.Equal(got, message) {
+				t.Fatalf("decompressed message doesn't match the original")
+			}
+		}
+	}
+}
+
+// benchmarkCompress compresses every message on its own and reports the bytes on the
+// wire per message and the ratio to the uncompressed messages
+func benchmarkCompress(b *testing.B, codec string) {
+	compressor := encoding.GetCompressor(codec)
+	messages := readMessages(b)
+
+	var raw, wire int
+	for _, message := range messages {
+		raw += len(message) + frameHeaderSize
+		wire += len(compress(b, compressor, message)) + frameHeaderSize
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		compress(b, compressor, messages[i%len(messages)])
+	}
+	b.ReportMetric(float64(wire)/float64(len(messages)), "wire-B/msg")
+	b.ReportMetric(float64(raw)/float64(wire), "ratio")
+}
+
+func benchmarkDecompress(b *testing.B, codec string) {
+	compressor := encoding.GetCompressor(codec)
+	messages := readMessages(b)
+	compressed := make([][]byte, len(messages))
+	for i, message := range messages {
+		compressed[i] = compress(b, compressor, message)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		decompress(b, compressor, compressed[i%len(compressed)])
+	}
+}
+
+func BenchmarkZstdCompress(b *testing.B) {
+	benchmarkCompress(b, Zstd)
+}
+
+func BenchmarkZstdDecompress(b *testing.B) {
+	benchmarkDecompress(b, Zstd)
+}
+
+// BenchmarkGzipCompress is the gzip compressor of gRPC, to compare zstd with
+func BenchmarkGzipCompress(b *testing.B) {
+	benchmarkCompress(b, "gzip")
+}
+
+func BenchmarkGzipDecompress(b *testing.B) {
+	benchmarkDecompress(b, "gzip")
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"context"
+	"math"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
@@ -11,10 +12,27 @@ import (
 var DockerVersion string
 var DockerClient *client.Client
 
+// CgroupRoot is where the host cgroup filesystem is mounted, the agent reads the counters
+// the Docker stats API doesn't report from there
+var CgroupRoot = "/sys/fs/cgroup"
+
+// AgentVersion is the version of the agent, overridden at build time with
+// -ldflags "-X github.com/nox/noxflow/agent/utils.AgentVersion=..."
+var AgentVersion = "dev"
+
 func InitDockerClient() error {
 	var err error
 	DockerClient, err = client.NewClientWithOpts(client.WithVersion("1.47"), client.WithAPIVersionNegotiation())
-	return err
+	if err != nil {
+		return err
+	}
+
+	version, err := DockerClient.ServerVersion(context.Background())
+	if err != nil {
+		return err
+	}
+	DockerVersion = version.Version
+	return nil
 }
 
 func DockerListContainers(ctx context.Context) ([]types.Container, error) {
@@ -31,3 +49,12 @@ func ContainerStats(ctx context.Context, containerID string, stream bool) (conta
 	return DockerClient.ContainerStats(ctx, containerID, stream)
 
 }
+
+// PidsLimit returns the pids limit reported by Docker, 0 when the container has none. On
+// cgroup v2 an unlimited container reports the largest uint64, which doesn't fit a BIGINT.
+func PidsLimit(limit uint64) uint64 {
+	if limit > math.MaxInt64 {
+		return 0
+	}
+	return limit
+}
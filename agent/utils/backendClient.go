@@ -2,16 +2,40 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"sync"
 	"time"
+
+	pb "github.com/nox/noxflow/agent/pkg/proto"
 )
 
+// BackendClient ships logs and usage stats to the backend HTTP service for the live view.
+// They are batched and sent in the background, so the collectors never wait on the network.
 type BackendClient struct {
 	BaseURL    string
 	HTTPClient *http.Client
+
+	batchSize     int
+	flushInterval time.Duration
+	maxQueueSize  int
+
+	mu      sync.Mutex
+	batch   []LogData
+	usage   []UsageData
+	flushCh chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+	// logsAccepted and logsDone count the logs queued by SendLog and the ones sent or
+	// dropped since, see LogProgress
+	logsAccepted uint64
+	logsDone     uint64
+	// closeOnce makes Close safe to call more than once
+	closeOnce sync.Once
 }
 
 type LogData struct {
@@ -19,6 +43,23 @@ type LogData struct {
 	Log      string
 }
 
+// UsageData is a container usage update, Timestamp is in unix milliseconds
+type UsageData struct {
+	ContainerID     string
+	ContainerName   string
+	Image           string
+	Timestamp       int64
+	CPUPercent      float64
+	MemoryUsage     uint64
+	MemoryLimit     uint64
+	MemoryPercent   float64
+	NetworkRxBytes  uint64
+	NetworkTxBytes  uint64
+	BlkioReadBytes  uint64
+	BlkioWriteBytes uint64
+	PidsCurrent     uint64
+}
+
 type ContainerLogMetadata struct {
 	ContainerID   string
 	ContainerName string
@@ -28,61 +69,242 @@ type ContainerLogMetadata struct {
 	LogDriver     string
 }
 
-func NewBackendClient(baseURL string) *BackendClient {
-	return &BackendClient{
+// NewBackendClient creates a client sending batches of up to batchSize logs, at least
+// every flushInterval
+func NewBackendClient(baseURL string, batchSize int, flushInterval time.Duration) *BackendClient {
+	c := &BackendClient{
 		BaseURL: baseURL,
 		HTTPClient: &http.Client{
 			Timeout: time.Second * 10,
+			// Keep the connections to the backend open between batches
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				MaxIdleConns:        10,
+				MaxIdleConnsPerHost: 10,
+				IdleConnTimeout:     90 * time.Second,
+			},
 		},
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		maxQueueSize:  batchSize * 10,
+		flushCh:       make(chan struct{}, 1),
+		done:          make(chan struct{}),
+		stopped:       make(chan struct{}),
 	}
+	go c.run()
+	return c
 }
 
-func (c *BackendClient) SendLog(metadata *ContainerLogMetadata, logLine string) error {
-	maxRetries := 3
-	backoff := time.Second
+// SendLog queues a log for the next batch. When the backend can't keep up the oldest
+// queued logs are dropped.
+func (c *BackendClient) SendLog(metadata *pb.ContainerLogMetadata, logLine string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	logData := LogData{
-		Metadata: *metadata,
-		Log:      logLine,
+	c.batch = append(c.batch, LogData{
+		Metadata: ContainerLogMetadata{
+			ContainerID:   metadata.ContainerId,
+			ContainerName: metadata.ContainerName,
+			Image:         metadata.Image,
+			State:         metadata.State,
+			LogPath:       metadata.LogPath,
+			LogDriver:     metadata.LogDriver,
+		},
+		Log: logLine,
+	})
+	c.logsAccepted++
+	if dropped := len(c.batch) - c.maxQueueSize; dropped > 0 {
+		c.batch = c.batch[dropped:]
+		c.logsDone += uint64(dropped)
+		log.Printf("Backend queue full, dropped %d logs", dropped)
 	}
 
-	jsonData, err := json.Marshal(logData)
-	if err != nil {
-		return fmt.Errorf("error marshaling log data: %v", err)
+	if len(c.batch) >= c.batchSize {
+		select {
+		case c.flushCh <- struct{}{}:
+		default:
+		}
 	}
+	return nil
+}
 
-	for attempt := 1; attempt <= maxRetries; attempt++ {
-		req, err := http.NewRequest("POST", fmt.Sprintf("%s/logs/stream", c.BaseURL), bytes.NewBuffer(jsonData))
-		if err != nil {
-			return fmt.Errorf("error creating request: %v", err)
+// LogProgress returns how many logs SendLog queued and how many of them were sent, or
+// dropped because the backend couldn't keep up
+func (c *BackendClient) LogProgress() (accepted, done uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.logsAccepted, c.logsDone
+}
+
+// SendUsageStats queues the usage stats for the next batch
+func (c *BackendClient) SendUsageStats(metadata *ContainerMetadata, stats *pb.ContainerUsageStats) error {
+	usage := UsageData{
+		ContainerID:     stats.ContainerId,
+		ContainerName:   metadata.ContainerName,
+		Image:           metadata.Image,
+		Timestamp:       stats.Timestamp,
+		CPUPercent:      stats.CpuPercent,
+		MemoryUsage:     stats.MemoryUsage,
+		MemoryLimit:     stats.MemoryLimit,
+		MemoryPercent:   stats.MemoryPercent,
+		BlkioReadBytes:  stats.BlkioReadBytes,
+		BlkioWriteBytes: stats.BlkioWriteBytes,
+		PidsCurrent:     stats.PidsCurrent,
+	}
+	for _, network := range stats.Networks {
+		usage.NetworkRxBytes += network.RxBytes
+		usage.NetworkTxBytes += network.TxBytes
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.usage = append(c.usage, usage)
+	if dropped := len(c.usage) - c.maxQueueSize; dropped > 0 {
+		c.usage = c.usage[dropped:]
+	}
+	return nil
+}
+
+// SendEvent is a no-op, the backend doesn't serve container events
+func (c *BackendClient) SendEvent(event *pb.ContainerEvent) error {
+	return nil
+}
+
+// CheckHealth fails when the queues are nearly full, the backend isn't keeping up and the
+// oldest data is about to be dropped
+func (c *BackendClient) CheckHealth(ctx context.Context) error {
+	c.mu.Lock()
+	logs, usage := len(c.batch), len(c.usage)
+	c.mu.Unlock()
+
+	if saturated := c.maxQueueSize * 9 / 10; logs >= saturated || usage >= saturated {
+		return fmt.Errorf("backend queue saturated: %d logs and %d usage updates queued, %d max", logs, usage, c.maxQueueSize)
+	}
+	return nil
+}
+
+// Close sends the queued logs and stops the client, later calls wait for the first one
+func (c *BackendClient) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	<-c.stopped
+	return nil
+}
+
+// run sends the batches until the client is closed
+func (c *BackendClient) run() {
+	defer close(c.stopped)
+
+	ticker := time.NewTicker(c.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			c.flush()
+			c.flushUsage()
+			return
+		case <-ticker.C:
+			c.flush()
+			c.flushUsage()
+		case <-c.flushCh:
+			c.flush()
 		}
+	}
+}
 
-		req.Header.Set("Content-Type", "application/json")
+// flush sends the queued logs in batches, a batch that can't be sent is put back in
+// front of the queue for the next flush
+func (c *BackendClient) flush() {
+	for {
+		c.mu.Lock()
+		size := min(len(c.batch), c.batchSize)
+		batch := make([]LogData, size)
+		copy(batch, c.batch)
+		c.batch = c.batch[size:]
+		c.mu.Unlock()
+		if size == 0 {
+			return
+		}
 
-		resp, err := c.HTTPClient.Do(req)
-		if err != nil {
-			if attempt == maxRetries {
-				return fmt.Errorf("error sending request after %d attempts: %v", maxRetries, err)
+		if err := c.sendBatch("/logs/batch", batch, len(batch)); err != nil {
+			log.Printf("Error sending logs to the backend: %v", err)
+			c.mu.Lock()
+			c.batch = append(batch, c.batch...)
+			if dropped := len(c.batch) - c.maxQueueSize; dropped > 0 {
+				c.batch = c.batch[dropped:]
+				c.logsDone += uint64(dropped)
+				log.Printf("Backend queue full, dropped %d logs", dropped)
 			}
-			log.Printf("Attempt %d failed, retrying in %v...", attempt, backoff)
-			time.Sleep(backoff)
-			backoff *= 2 // Exponential backoff
-			continue
+			c.mu.Unlock()
+			return
 		}
-		defer resp.Body.Close()
 
-		if resp.StatusCode != http.StatusOK {
-			if attempt == maxRetries {
-				return fmt.Errorf("unexpected status code after %d attempts: %d", maxRetries, resp.StatusCode)
-			}
-			log.Printf("Attempt %d failed with status %d, retrying in %v...", attempt, resp.StatusCode, backoff)
-			time.Sleep(backoff)
-			backoff *= 2
-			continue
+		c.mu.Lock()
+		c.logsDone += uint64(size)
+		c.mu.Unlock()
+	}
+}
+
+// flushUsage sends the queued usage stats, they are dropped when the backend can't be
+// reached since the next update supersedes them
+func (c *BackendClient) flushUsage() {
+	c.mu.Lock()
+	usage := c.usage
+	c.usage = nil
+	c.mu.Unlock()
+	if len(usage) == 0 {
+		return
+	}
+
+	if err := c.sendBatch("/usage/batch", usage, len(usage)); err != nil {
+		log.Printf("Error sending usage stats to the backend: %v", err)
+	}
+}
+
+// sendBatch posts a batch to the backend, retrying with an exponential backoff
+func (c *BackendClient) sendBatch(path string, batch any, size int) error {
+	maxRetries := 3
+	backoff := time.Second
+
+	jsonData, err := json.Marshal(batch)
+	if err != nil {
+		return fmt.Errorf("error marshaling batch: %v", err)
+	}
+
+	for attempt := 1; attempt <= maxRetries; attempt++ {
+		err = c.post(path, jsonData)
+		if err == nil {
+			return nil // Success
+		}
+		if attempt == maxRetries {
+			break
 		}
+		log.Printf("Attempt %d failed, retrying in %v: %v", attempt, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2 // Exponential backoff
+	}
 
-		return nil // Success
+	return fmt.Errorf("failed to send %d items after %d attempts: %v", size, maxRetries, err)
+}
+
+// post sends one request, the response body is always read and closed so the
+// connection can be reused
+func (c *BackendClient) post(path string, jsonData []byte) error {
+	req, err := http.NewRequest("POST", c.BaseURL+path, bytes.NewReader(jsonData))
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
 	}
+	req.Header.Set("Content-Type", "application/json")
 
-	return fmt.Errorf("failed to send log after %d attempts", maxRetries)
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
 }
@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/nox/noxflow/agent/pkg/proto"
+)
+
+func TestBackendClientCloseTwice(t *testing.T) {
+	var mu sync.Mutex
+	received := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/logs/batch" {
+			var batch []LogData
+			json.NewDecoder(r.Body).Decode(&batch)
+			mu.Lock()
+			received += len(batch)
+			mu.Unlock()
+		}
+	}))
+	defer server.Close()
+
+	client := NewBackendClient(server.URL, 100, time.Hour)
+	if err := client.SendLog(&pb.ContainerLogMetadata{ContainerId: "0123456789ab"}, "line"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Concurrent and repeated calls must neither panic nor return before the flush
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client.Close()
+		}()
+	}
+	wg.Wait()
+	if err := client.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if received != 1 {
+		t.Errorf("backend received %d logs, want the queued one flushed on Close", received)
+	}
+}
+
+func TestBackendClientLogProgress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	client := NewBackendClient(server.URL, 100, time.Hour)
+	for range 2 {
+		if err := client.SendLog(&pb.ContainerLogMetadata{ContainerId: "0123456789ab"}, "line"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if accepted, done := client.LogProgress(); accepted != 2 || done != 0 {
+		t.Errorf("LogProgress() = %d, %d before the flush, want 2, 0", accepted, done)
+	}
+
+	client.Close()
+	if accepted, done := client.LogProgress(); accepted != 2 || done != 2 {
+		t.Errorf("LogProgress() = %d, %d after the flush, want 2, 2", accepted, done)
+	}
+}
@@ -0,0 +1,221 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Checkpoints holds the Docker timestamp of the last log line handled per container, so
+// a restarted agent resumes the logs where the previous one stopped instead of skipping
+// the lines written meanwhile. The positions are only saved once the lines before them
+// were sent by the outputs that queue them.
+type Checkpoints struct {
+	// path is the file the checkpoints are saved to, they are only kept in memory when empty
+	path string
+
+	mu        sync.Mutex
+	positions map[string]string
+	// dirty is set when the positions changed since the last snapshot
+	dirty bool
+	// sinks are the outputs sending the logs in the background
+	sinks []QueuedSink
+	// pending are the snapshots of the positions waiting for their lines to be sent,
+	// oldest first
+	pending []pendingCheckpoints
+	// saved are the positions written to the file, unsaved is set when they changed since
+	saved   map[string]string
+	unsaved bool
+}
+
+// pendingCheckpoints are positions whose lines are sent once every sink is done with the
+// number of logs it had accepted when they were taken
+type pendingCheckpoints struct {
+	positions map[string]string
+	accepted  []uint64
+}
+
+// LoadCheckpoints reads the checkpoints saved to path, the checkpoints older than maxAge
+// are dropped so a long outage doesn't replay hours of logs. A missing file is an empty
+// set of checkpoints.
+func LoadCheckpoints(path string, maxAge time.Duration) (*Checkpoints, error) {
+	c := &Checkpoints{path: path, positions: make(map[string]string), saved: make(map[string]string)}
+	if path == "" {
+		return c, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoints: %v", err)
+	}
+	var positions map[string]string
+	if err := json.Unmarshal(data, &positions); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoints %s: %v", path, err)
+	}
+
+	for containerID, timestamp := range positions {
+		parsed, err := time.Parse(time.RFC3339Nano, timestamp)
+		if err != nil || (maxAge > 0 && time.Since(parsed) > maxAge) {
+			c.unsaved = true
+			continue
+		}
+		c.positions[containerID] = timestamp
+		c.saved[containerID] = timestamp
+	}
+	return c, nil
+}
+
+// TrackSink holds back the positions of the lines sink hasn't sent yet, when it or one of
+// its outputs queues the logs
+func (c *Checkpoints) TrackSink(sink Sink) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sinks = queuedSinks(sink)
+}
+
+// Get returns the position of the last line handled for a container, empty when there is none
+func (c *Checkpoints) Get(containerID string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.positions[containerID]
+}
+
+// Set records the timestamp of the last log line handled for a container
+func (c *Checkpoints) Set(containerID, timestamp string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.positions[containerID] != timestamp {
+		c.positions[containerID] = timestamp
+		c.dirty = true
+	}
+}
+
+// Delete forgets the checkpoint of a container that no longer exists
+func (c *Checkpoints) Delete(containerID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.positions, containerID)
+	for _, pending := range c.pending {
+		delete(pending.positions, containerID)
+	}
+	if _, ok := c.saved[containerID]; ok {
+		delete(c.saved, containerID)
+		c.unsaved = true
+	}
+}
+
+// Save writes the checkpoints whose lines were sent to their file if they changed. The
+// file is replaced atomically so a crash never leaves it half written.
+func (c *Checkpoints) Save() error {
+	c.mu.Lock()
+	if c.path == "" {
+		c.mu.Unlock()
+		return nil
+	}
+	c.commit()
+	if !c.unsaved {
+		c.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(c.saved)
+	c.unsaved = false
+	c.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to marshal checkpoints: %v", err)
+	}
+
+	if err := c.write(data); err != nil {
+		c.mu.Lock()
+		c.unsaved = true
+		c.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// commit takes a snapshot of the positions and moves the latest snapshot whose lines
+// were all sent to the saved positions
+func (c *Checkpoints) commit() {
+	if c.dirty {
+		// The lines of the positions were handed to the sinks before the counts are read
+		pending := pendingCheckpoints{positions: maps.Clone(c.positions)}
+		for _, sink := range c.sinks {
+			accepted, _ := sink.LogProgress()
+			pending.accepted = append(pending.accepted, accepted)
+		}
+		c.pending = append(c.pending, pending)
+		c.dirty = false
+	}
+
+	done := make([]uint64, len(c.sinks))
+	for i, sink := range c.sinks {
+		_, done[i] = sink.LogProgress()
+	}
+	for i := len(c.pending) - 1; i >= 0; i-- {
+		sent := true
+		for j, accepted := range c.pending[i].accepted {
+			sent = sent && done[j] >= accepted
+		}
+		if sent {
+			c.saved = c.pending[i].positions
+			c.pending = c.pending[i+1:]
+			c.unsaved = true
+			return
+		}
+	}
+}
+
+// write replaces the checkpoint file with data
+func (c *Checkpoints) write(data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create the checkpoint directory: %v", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create checkpoint file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write checkpoints: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync checkpoints: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close checkpoint file: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		return fmt.Errorf("failed to replace checkpoint file: %v", err)
+	}
+	return nil
+}
+
+// Run saves the checkpoints every interval until ctx is done, the caller saves them a
+// last time once the collectors are stopped and the sink is closed
+func (c *Checkpoints) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.Save(); err != nil {
+				log.Printf("Error saving checkpoints: %v", err)
+			}
+		}
+	}
+}
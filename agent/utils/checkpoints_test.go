@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	pb "github.com/nox/noxflow/agent/pkg/proto"
+)
+
+// queuedSink is a sink that only counts the logs, the test decides how many are sent
+type queuedSink struct {
+	mu       sync.Mutex
+	accepted uint64
+	done     uint64
+}
+
+func (s *queuedSink) SendLog(metadata *pb.ContainerLogMetadata, logLine string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accepted++
+	return nil
+}
+
+func (s *queuedSink) SendUsageStats(metadata *ContainerMetadata, stats *pb.ContainerUsageStats) error {
+	return nil
+}
+
+func (s *queuedSink) SendEvent(event *pb.ContainerEvent) error {
+	return nil
+}
+
+func (s *queuedSink) Close() error {
+	return nil
+}
+
+func (s *queuedSink) LogProgress() (uint64, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accepted, s.done
+}
+
+func (s *queuedSink) sendUpTo(done uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.done = done
+}
+
+// savedCheckpoints reads the checkpoint file, nil when it wasn't written
+func savedCheckpoints(t *testing.T, path string) map[string]string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	var positions map[string]string
+	if err := json.Unmarshal(data, &positions); err != nil {
+		t.Fatal(err)
+	}
+	return positions
+}
+
+func TestCheckpointsWaitForTheQueuedLogs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+	checkpoints, err := LoadCheckpoints(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &queuedSink{}
+	checkpoints.TrackSink(MultiSink{NewJSONSink(&nopWriter{}, nil), sink})
+
+	// ship sends a line and records its position like the log collectors
+	ship := func(timestamp string) {
+		if err := sink.SendLog(&pb.ContainerLogMetadata{ContainerId: "web"}, "line"); err != nil {
+			t.Fatal(err)
+		}
+		checkpoints.Set("web", timestamp)
+	}
+	save := func(want map[string]string) {
+		t.Helper()
+		if err := checkpoints.Save(); err != nil {
+			t.Fatal(err)
+		}
+		if got := savedCheckpoints(t, path); !reflect.DeepEqual(got, want) {
+			t.Errorf("saved %v, want %v", got, want)
+		}
+	}
+
+	ship("2024-05-01T12:00:01Z")
+	save(nil)
+	sink.sendUpTo(1)
+	save(map[string]string{"web": "2024-05-01T12:00:01Z"})
+
+	// Each save waits for the lines shipped before it
+	ship("2024-05-01T12:00:02Z")
+	save(map[string]string{"web": "2024-05-01T12:00:01Z"})
+	ship("2024-05-01T12:00:03Z")
+	save(map[string]string{"web": "2024-05-01T12:00:01Z"})
+	sink.sendUpTo(2)
+	save(map[string]string{"web": "2024-05-01T12:00:02Z"})
+	sink.sendUpTo(3)
+	save(map[string]string{"web": "2024-05-01T12:00:03Z"})
+
+	// The positions handled are resumed from without waiting
+	ship("2024-05-01T12:00:04Z")
+	if got := checkpoints.Get("web"); got != "2024-05-01T12:00:04Z" {
+		t.Errorf("Get() = %q, want the last line handled", got)
+	}
+
+	checkpoints.Delete("web")
+	sink.sendUpTo(4)
+	save(map[string]string{})
+}
+
+func TestCheckpointsWithoutQueuedSinks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+	checkpoints, err := LoadCheckpoints(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpoints.TrackSink(NewJSONSink(&nopWriter{}, nil))
+
+	checkpoints.Set("web", "2024-05-01T12:00:01Z")
+	if err := checkpoints.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := LoadCheckpoints(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reloaded.Get("web"); got != "2024-05-01T12:00:01Z" {
+		t.Errorf("Get() after reloading = %q, want the saved position", got)
+	}
+}
+
+type nopWriter struct{}
+
+func (nopWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
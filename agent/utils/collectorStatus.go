@@ -0,0 +1,196 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CollectorState is the lifecycle state of a log or usage collector
+type CollectorState string
+
+const (
+	CollectorRunning    CollectorState = "running"
+	CollectorBackingOff CollectorState = "backing_off"
+	CollectorFailed     CollectorState = "failed"
+	CollectorStopped    CollectorState = "stopped"
+)
+
+// Collector kinds
+const (
+	CollectorLogs  = "logs"
+	CollectorUsage = "usage"
+)
+
+// CollectorStatus tracks the health of a single collector
+type CollectorStatus struct {
+	ContainerID   string         `json:"container_id"`
+	ContainerName string         `json:"container_name"`
+	Kind          string         `json:"kind"`
+	State         CollectorState `json:"state"`
+	// LinesShipped counts log lines for log collectors and samples for usage collectors
+	LinesShipped uint64        `json:"lines_shipped"`
+	BytesShipped uint64        `json:"bytes_shipped"`
+	SendErrors   uint64        `json:"send_errors"`
+	Restarts     uint32        `json:"restarts"`
+	LastSent     time.Time     `json:"last_sent"`
+	Lag          time.Duration `json:"lag_ns"`
+	LastError    string        `json:"last_error,omitempty"`
+	// Redactions counts the sensitive values redacted, LinesDropped the lines dropped by
+	// the redaction
+	Redactions   uint64 `json:"redactions"`
+	LinesDropped uint64 `json:"lines_dropped"`
+	// LinesSampled and LinesRateLimited count the lines not shipped by the log limiter
+	LinesSampled     uint64 `json:"lines_sampled"`
+	LinesRateLimited uint64 `json:"lines_rate_limited"`
+
+	mu *sync.Mutex
+}
+
+// SetState updates the collector state
+func (s *CollectorStatus) SetState(state CollectorState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.State = state
+}
+
+// SetContainerName sets the container name once it is known
+func (s *CollectorStatus) SetContainerName(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ContainerName = name
+}
+
+// RecordSent records a successfully shipped line or sample. lag is how long ago
+// the data was produced, zero when unknown.
+func (s *CollectorStatus) RecordSent(bytes int, lag time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.LinesShipped++
+	s.BytesShipped += uint64(bytes)
+	s.LastSent = time.Now()
+	if lag > 0 {
+		s.Lag = lag
+	}
+}
+
+// RecordRedactions records sensitive values redacted from a line, and whether the
+// line was dropped
+func (s *CollectorStatus) RecordRedactions(count int, dropped bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Redactions += uint64(count)
+	if dropped {
+		s.LinesDropped++
+	}
+}
+
+// RecordLimited records a line the log limiter didn't ship
+func (s *CollectorStatus) RecordLimited(decision LogDecision) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch decision {
+	case LogSampled:
+		s.LinesSampled++
+	case LogRateLimited:
+		s.LinesRateLimited++
+	}
+}
+
+// RecordSendError records a failure to ship data to the server
+func (s *CollectorStatus) RecordSendError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.SendErrors++
+	s.LastError = err.Error()
+}
+
+// RecordRestart records that the collector failed and is backing off before a retry
+func (s *CollectorStatus) RecordRestart(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Restarts++
+	s.State = CollectorBackingOff
+	s.LastError = err.Error()
+}
+
+// Fail marks the collector as failed for good
+func (s *CollectorStatus) Fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.State = CollectorFailed
+	s.LastError = err.Error()
+}
+
+// StatusRegistry keeps the status of every collector running in the agent
+type StatusRegistry struct {
+	mu         sync.RWMutex
+	collectors map[string]*CollectorStatus
+}
+
+// NewStatusRegistry creates an empty registry
+func NewStatusRegistry() *StatusRegistry {
+	return &StatusRegistry{
+		collectors: make(map[string]*CollectorStatus),
+	}
+}
+
+// Collector returns the status of a collector, registering it on first use
+func (r *StatusRegistry) Collector(containerID, kind string) *CollectorStatus {
+	key := kind + "/" + containerID
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if status, ok := r.collectors[key]; ok {
+		return status
+	}
+	status := &CollectorStatus{
+		ContainerID: containerID,
+		Kind:        kind,
+		State:       CollectorRunning,
+		mu:          &sync.Mutex{},
+	}
+	r.collectors[key] = status
+	return status
+}
+
+// Remove drops the statuses of every collector of a container, once the container is
+// gone or no longer collected
+func (r *StatusRegistry) Remove(containerID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, status := range r.collectors {
+		if status.ContainerID == containerID {
+			delete(r.collectors, key)
+		}
+	}
+}
+
+// Snapshot returns a copy of every collector status, sorted by container and kind
+func (r *StatusRegistry) Snapshot() []CollectorStatus {
+	r.mu.RLock()
+	statuses := make([]CollectorStatus, 0, len(r.collectors))
+	for _, status := range r.collectors {
+		status.mu.Lock()
+		statuses = append(statuses, *status)
+		status.mu.Unlock()
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].ContainerID != statuses[j].ContainerID {
+			return statuses[i].ContainerID < statuses[j].ContainerID
+		}
+		return statuses[i].Kind < statuses[j].Kind
+	})
+	return statuses
+}
+
+// ServeHTTP writes the collector statuses as JSON
+func (r *StatusRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(r.Snapshot())
+}
@@ -0,0 +1,25 @@
+package utils
+
+import "testing"
+
+func TestStatusRegistryRemove(t *testing.T) {
+	registry := NewStatusRegistry()
+	registry.Collector("web", CollectorLogs)
+	registry.Collector("web", CollectorUsage)
+	registry.Collector("db", CollectorLogs).SetState(CollectorStopped)
+
+	registry.Remove("web")
+
+	statuses := registry.Snapshot()
+	if len(statuses) != 1 || statuses[0].ContainerID != "db" {
+		t.Fatalf("Snapshot() = %+v, want only the db collector", statuses)
+	}
+	if statuses[0].State != CollectorStopped {
+		t.Errorf("db collector state = %s, want %s", statuses[0].State, CollectorStopped)
+	}
+
+	// A container collected again gets a new status
+	if status := registry.Collector("web", CollectorLogs); status.Restarts != 0 || status.State != CollectorRunning {
+		t.Errorf("Collector() = %+v, want a new running status", status)
+	}
+}
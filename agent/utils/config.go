@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"flag"
+	"strings"
+	"time"
+)
+
+// Config holds the agent settings, read from the command line
+type Config struct {
+	// Outputs are the sinks the data is shipped to, see NewSink
+	Outputs              []string
+	BackendURL           string
+	BackendBatchSize     int
+	BackendFlushInterval time.Duration
+	OutputFile           string
+	ServerAddr           string
+	NumConnections       int
+	Compression          string
+	CollectUsage         bool
+	CollectEvents        bool
+	MetricsAddr          string
+	MetricsLabels        []string
+	StatusAddr           string
+	// Include and Exclude are the container selectors, see ParseSelector
+	Include []string
+	Exclude []string
+	// Redaction settings, see NewRedactor
+	RedactDetectors []string
+	RedactPatterns  []string
+	RedactAction    string
+	RedactHashKey   string
+	// Log rate limiting and sampling settings, see NewLogLimiter
+	LogLimits      LogLimits
+	SamplePatterns []string
+	KeepPattern    string
+	// HeartbeatInterval is how often the collector statuses are reported to the server
+	HeartbeatInterval time.Duration
+	// CheckpointFile is where the log positions are saved, see LoadCheckpoints
+	CheckpointFile     string
+	CheckpointMaxAge   time.Duration
+	CheckpointInterval time.Duration
+	// ShutdownTimeout bounds how long the collectors and the outputs are drained on shutdown
+	ShutdownTimeout time.Duration
+	// DockerLostTimeout is how long the Docker daemon can be unreachable before the agent
+	// exits to be restarted, see DockerWatchdog
+	DockerLostTimeout time.Duration
+	// CgroupRoot is where the host cgroup filesystem is mounted, see CgroupRoot
+	CgroupRoot string
+}
+
+// LoadConfig parses the command line flags into a Config
+func LoadConfig() *Config {
+	cfg := &Config{}
+
+	var outputs, metricsLabels, redactDetectors string
+	flag.StringVar(&outputs, "output", OutputGRPC, "comma separated outputs: grpc (NoxFlow server), backend (live SSE backend), stdout or file")
+	flag.StringVar(&cfg.BackendURL, "backend-url", "http://localhost:8080", "URL of the backend for the backend output")
+	flag.IntVar(&cfg.BackendBatchSize, "backend-batch-size", 100, "maximum number of logs sent to the backend per request")
+	flag.DurationVar(&cfg.BackendFlushInterval, "backend-flush-interval", time.Second, "how often the logs queued for the backend are sent")
+	flag.StringVar(&cfg.OutputFile, "output-file", "", "file the file output appends JSON lines to")
+	flag.StringVar(&cfg.ServerAddr, "server", "localhost:8888", "address of the NoxFlow gRPC server")
+	flag.IntVar(&cfg.NumConnections, "connections", 5, "number of gRPC connections to the server")
+	flag.StringVar(&cfg.Compression, "compression", "none", "compression of the messages sent to the server: none, gzip or zstd, falls back to gzip then none when the server does not support it")
+	flag.BoolVar(&cfg.CollectUsage, "usage", false, "collect container usage stats")
+	flag.BoolVar(&cfg.CollectEvents, "events", true, "ship container lifecycle events to the server")
+	flag.StringVar(&cfg.MetricsAddr, "metrics-addr", "", "address to expose Prometheus metrics on, e.g. :9100 (disabled when empty)")
+	flag.StringVar(&metricsLabels, "metrics-labels", "", "comma separated container labels to add to the Prometheus metrics")
+	flag.StringVar(&cfg.StatusAddr, "status-addr", "localhost:9101", "address of the local status, /healthz and /readyz endpoints (disabled when empty)")
+	flag.DurationVar(&cfg.HeartbeatInterval, "heartbeat-interval", 30*time.Second, "how often to report the agent status to the server")
+	flag.Var((*listFlag)(&cfg.Include), "include", "only collect from containers matching the selector, e.g. name=web-* or label=team=api (can be repeated)")
+	flag.Var((*listFlag)(&cfg.Exclude), "exclude", "skip containers matching the selector, e.g. image~^postgres or label=sidecar (can be repeated)")
+	flag.StringVar(&redactDetectors, "redact", strings.Join(DefaultDetectors, ","), "comma separated built-in redaction detectors: credit_card, email, jwt, aws_key, bearer_token, ip or none")
+	flag.Var((*listFlag)(&cfg.RedactPatterns), "redact-pattern", "custom redaction pattern as <name>=<regex> (can be repeated)")
+	flag.StringVar(&cfg.RedactAction, "redact-action", string(RedactReplace), "what to do with sensitive data: replace, hash or drop the line")
+	flag.StringVar(&cfg.RedactHashKey, "redact-hash-key", "", "key for the redaction hashes, required with -redact-action=hash")
+	flag.Float64Var(&cfg.LogLimits.Rate, "rate-limit", 0, "maximum log lines per second shipped per container (0 disables rate limiting)")
+	flag.IntVar(&cfg.LogLimits.Burst, "rate-burst", 0, "log lines per container that can be shipped at once above the rate limit (defaults to the rate limit)")
+	flag.IntVar(&cfg.LogLimits.SampleRate, "sample-rate", 0, "keep 1 in N of the log lines matching the sample patterns (0 disables sampling)")
+	flag.Var((*listFlag)(&cfg.SamplePatterns), "sample-pattern", "regex of the log lines to sample, every line when not set (can be repeated)")
+	flag.StringVar(&cfg.KeepPattern, "keep-pattern", DefaultKeepPattern, "regex of the log lines that are never sampled out")
+	flag.StringVar(&cfg.CheckpointFile, "checkpoint-file", "/var/lib/noxflow/checkpoints.json", "file the log positions are saved to so a restarted agent resumes the logs (kept in memory when empty)")
+	flag.DurationVar(&cfg.CheckpointMaxAge, "checkpoint-max-age", time.Hour, "log positions older than this are ignored on startup, the logs resume from now (0 never ignores them)")
+	flag.DurationVar(&cfg.CheckpointInterval, "checkpoint-interval", 5*time.Second, "how often the log positions are saved")
+	flag.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", 10*time.Second, "how long the collectors and the outputs are drained on SIGTERM before the agent exits")
+	flag.DurationVar(&cfg.DockerLostTimeout, "docker-lost-timeout", time.Minute, "how long the Docker daemon can be unreachable before the agent exits to be restarted (0 never exits)")
+	flag.StringVar(&cfg.CgroupRoot, "cgroup-root", "/sys/fs/cgroup", "mount point of the host cgroup filesystem, the OOM kills of the containers are read from there")
+	flag.Parse()
+
+	cfg.Outputs = splitList(outputs)
+	cfg.MetricsLabels = splitList(metricsLabels)
+	cfg.RedactDetectors = splitList(redactDetectors)
+
+	return cfg
+}
+
+// splitList splits a comma separated flag value, ignoring empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// listFlag is a flag that can be repeated, used for values that may contain commas
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
@@ -0,0 +1,209 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// LabelPrefix is the prefix of the container labels that configure the agent
+const LabelPrefix = "noxflow."
+
+// Labels controlling the collection for a single container
+const (
+	// LabelEnable set to false excludes the container entirely
+	LabelEnable = LabelPrefix + "enable"
+	LabelLogs   = LabelPrefix + "logs"
+	LabelUsage  = LabelPrefix + "usage"
+	LabelEvents = LabelPrefix + "events"
+)
+
+// Selector matches containers on their name, image or labels. Selectors are written as
+//
+//	name=<glob>  name~<regex>  image=<glob>  image~<regex>  label=<key>  label=<key>=<value>
+//
+// A label selector without a value matches when the label exists.
+type Selector struct {
+	field   string
+	pattern string
+	value   string
+	exists  bool
+	regex   *regexp.Regexp
+}
+
+// ParseSelector parses a selector expression
+func ParseSelector(expr string) (*Selector, error) {
+	index := strings.IndexAny(expr, "=~")
+	if index <= 0 {
+		return nil, fmt.Errorf("invalid selector %q: expected <field>=<pattern> or <field>~<regex>", expr)
+	}
+	selector := &Selector{field: expr[:index], pattern: expr[index+1:]}
+	operator := expr[index]
+
+	switch selector.field {
+	case "name", "image":
+		if operator == '~' {
+			regex, err := regexp.Compile(selector.pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid selector %q: %v", expr, err)
+			}
+			selector.regex = regex
+		} else if _, err := path.Match(selector.pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid selector %q: %v", expr, err)
+		}
+	case "label":
+		if operator != '=' {
+			return nil, fmt.Errorf("invalid selector %q: labels only support =", expr)
+		}
+		key, value, found := strings.Cut(selector.pattern, "=")
+		selector.pattern = key
+		selector.value = value
+		selector.exists = !found
+	default:
+		return nil, fmt.Errorf("invalid selector %q: unknown field %q", expr, selector.field)
+	}
+
+	if selector.pattern == "" {
+		return nil, fmt.Errorf("invalid selector %q: empty pattern", expr)
+	}
+	return selector, nil
+}
+
+// Matches reports whether the container matches the selector
+func (s *Selector) Matches(container *ContainerMetadata) bool {
+	switch s.field {
+	case "name":
+		return s.match(strings.TrimPrefix(container.ContainerName, "/"))
+	case "image":
+		return s.match(container.Image)
+	case "label":
+		value, ok := container.Labels[s.pattern]
+		return ok && (s.exists || value == s.value)
+	}
+	return false
+}
+
+func (s *Selector) match(value string) bool {
+	if s.regex != nil {
+		return s.regex.MatchString(value)
+	}
+	matched, _ := path.Match(s.pattern, value)
+	return matched
+}
+
+// ContainerFilter decides which containers the agent collects from. A container is
+// collected when it matches one of the include selectors, or there are none, and
+// matches none of the exclude selectors. Containers labelled noxflow.enable=false
+// and the agent's own container are always skipped.
+type ContainerFilter struct {
+	include []*Selector
+	exclude []*Selector
+	selfID  string
+}
+
+// NewContainerFilter parses the include and exclude selectors
+func NewContainerFilter(include, exclude []string) (*ContainerFilter, error) {
+	filter := &ContainerFilter{selfID: selfContainerID()}
+	for _, expr := range include {
+		selector, err := ParseSelector(expr)
+		if err != nil {
+			return nil, err
+		}
+		filter.include = append(filter.include, selector)
+	}
+	for _, expr := range exclude {
+		selector, err := ParseSelector(expr)
+		if err != nil {
+			return nil, err
+		}
+		filter.exclude = append(filter.exclude, selector)
+	}
+	return filter, nil
+}
+
+// Allows reports whether the container should be collected from
+func (f *ContainerFilter) Allows(container *ContainerMetadata) bool {
+	if f.selfID != "" && strings.HasPrefix(container.ContainerID, f.selfID) {
+		return false
+	}
+	if enabled, ok := labelBool(container.Labels, LabelEnable); ok && !enabled {
+		return false
+	}
+
+	for _, selector := range f.exclude {
+		if selector.Matches(container) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, selector := range f.include {
+		if selector.Matches(container) {
+			return true
+		}
+	}
+	return false
+}
+
+// CollectionSettings are the collectors enabled for a container
+type CollectionSettings struct {
+	Logs   bool
+	Usage  bool
+	Events bool
+}
+
+// ResolveSettings applies the container's noxflow.* label overrides to the defaults
+func ResolveSettings(defaults CollectionSettings, labels map[string]string) CollectionSettings {
+	settings := defaults
+	if value, ok := labelBool(labels, LabelLogs); ok {
+		settings.Logs = value
+	}
+	if value, ok := labelBool(labels, LabelUsage); ok {
+		settings.Usage = value
+	}
+	if value, ok := labelBool(labels, LabelEvents); ok {
+		settings.Events = value
+	}
+	return settings
+}
+
+// labelBool parses a boolean label, it reports false when the label is missing or invalid
+func labelBool(labels map[string]string, key string) (bool, bool) {
+	value, ok := labels[key]
+	if !ok {
+		return false, false
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, false
+	}
+	return parsed, true
+}
+
+// selfContainerID returns the short ID of the container the agent runs in, Docker uses
+// it as the default hostname. It is empty when the agent doesn't run in a container.
+func selfContainerID() string {
+	if _, err := os.Stat("/.dockerenv"); err != nil {
+		return ""
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return hostnameContainerID(hostname)
+}
+
+// hostnameContainerID returns the hostname if it is a short container ID
+func hostnameContainerID(hostname string) string {
+	if len(hostname) != 12 {
+		return ""
+	}
+	if _, err := strconv.ParseUint(hostname, 16, 64); err != nil {
+		return ""
+	}
+	return hostname
+}
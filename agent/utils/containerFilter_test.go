@@ -0,0 +1,211 @@
+package utils
+
+import "testing"
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr bool
+	}{
+		{expr: "name=api-*"},
+		{expr: "name~^api-[0-9]+$"},
+		{expr: "image=nginx:*"},
+		{expr: "image~^postgres:1[56]"},
+		{expr: "label=com.example.team"},
+		{expr: "label=com.example.team=shop"},
+		{expr: "label=com.example.team="},
+		{expr: "api", wantErr: true},
+		{expr: "=api", wantErr: true},
+		{expr: "name=", wantErr: true},
+		{expr: "name=[", wantErr: true},
+		{expr: "name~(", wantErr: true},
+		{expr: "label~team", wantErr: true},
+		{expr: "label=", wantErr: true},
+		{expr: "state=running", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := ParseSelector(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseSelector(%q) error = %v, wantErr %v", tt.expr, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSelectorMatches(t *testing.T) {
+	container := &ContainerMetadata{
+		ContainerID:   "0123456789abcdef",
+		ContainerName: "/api-12",
+		Image:         "nginx:1.25",
+		Labels:        map[string]string{"team": "shop", "empty": ""},
+	}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{expr: "name=api-*", want: true},
+		{expr: "name=api", want: false},
+		{expr: "name=/api-12", want: false},
+		{expr: "name~^api-[0-9]+$", want: true},
+		{expr: "name~^api$", want: false},
+		// Globs match the whole value, regexes any part of it
+		{expr: "image=nginx", want: false},
+		{expr: "image~nginx", want: true},
+		{expr: "image=nginx:1.*", want: true},
+		{expr: "label=team", want: true},
+		{expr: "label=team=shop", want: true},
+		{expr: "label=team=billing", want: false},
+		{expr: "label=empty", want: true},
+		{expr: "label=empty=", want: true},
+		{expr: "label=team=", want: false},
+		{expr: "label=owner", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			selector, err := ParseSelector(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := selector.Matches(container); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContainerFilterAllows(t *testing.T) {
+	tests := []struct {
+		name      string
+		include   []string
+		exclude   []string
+		container ContainerMetadata
+		want      bool
+	}{
+		{
+			name:      "no selectors",
+			container: ContainerMetadata{ContainerName: "/api"},
+			want:      true,
+		},
+		{
+			name:      "included",
+			include:   []string{"name=db", "image=nginx:*"},
+			container: ContainerMetadata{ContainerName: "/api", Image: "nginx:1.25"},
+			want:      true,
+		},
+		{
+			name:      "not included",
+			include:   []string{"name=db"},
+			container: ContainerMetadata{ContainerName: "/api"},
+		},
+		{
+			name:      "exclude wins over include",
+			include:   []string{"name=api"},
+			exclude:   []string{"label=team=shop"},
+			container: ContainerMetadata{ContainerName: "/api", Labels: map[string]string{"team": "shop"}},
+		},
+		{
+			name:      "disabled by label",
+			container: ContainerMetadata{ContainerName: "/api", Labels: map[string]string{LabelEnable: "false"}},
+		},
+		{
+			name:      "disabled label overrides include",
+			include:   []string{"name=api"},
+			container: ContainerMetadata{ContainerName: "/api", Labels: map[string]string{LabelEnable: "0"}},
+		},
+		{
+			name:      "enabled by label",
+			container: ContainerMetadata{ContainerName: "/api", Labels: map[string]string{LabelEnable: "true"}},
+			want:      true,
+		},
+		{
+			name:      "invalid enable label is ignored",
+			container: ContainerMetadata{ContainerName: "/api", Labels: map[string]string{LabelEnable: "nope"}},
+			want:      true,
+		},
+		{
+			name:      "agent container",
+			container: ContainerMetadata{ContainerID: "0123456789abcdef", ContainerName: "/noxflow-agent"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := NewContainerFilter(tt.include, tt.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			filter.selfID = "0123456789ab"
+			if got := filter.Allows(&tt.container); got != tt.want {
+				t.Errorf("Allows() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewContainerFilterRejectsInvalidSelectors(t *testing.T) {
+	if _, err := NewContainerFilter([]string{"name=api"}, []string{"label~team"}); err == nil {
+		t.Error("NewContainerFilter() accepted an invalid exclude selector")
+	}
+	if _, err := NewContainerFilter([]string{"state=running"}, nil); err == nil {
+		t.Error("NewContainerFilter() accepted an invalid include selector")
+	}
+}
+
+func TestResolveSettings(t *testing.T) {
+	defaults := CollectionSettings{Logs: true, Usage: true, Events: false}
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   CollectionSettings
+	}{
+		{
+			name: "no labels",
+			want: defaults,
+		},
+		{
+			name:   "overrides",
+			labels: map[string]string{LabelLogs: "false", LabelEvents: "true"},
+			want:   CollectionSettings{Logs: false, Usage: true, Events: true},
+		},
+		{
+			name:   "invalid values keep the defaults",
+			labels: map[string]string{LabelLogs: "off", LabelUsage: ""},
+			want:   defaults,
+		},
+		{
+			name:   "other labels",
+			labels: map[string]string{"logs": "false"},
+			want:   defaults,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ResolveSettings(defaults, tt.labels); got != tt.want {
+				t.Errorf("ResolveSettings() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostnameContainerID(t *testing.T) {
+	tests := []struct {
+		hostname string
+		want     string
+	}{
+		{hostname: "0123456789ab", want: "0123456789ab"},
+		{hostname: "0123456789abcdef"},
+		{hostname: "0123456789"},
+		{hostname: "web-server-01"},
+		{hostname: "0123456789ag"},
+	}
+
+	for _, tt := range tests {
+		if got := hostnameContainerID(tt.hostname); got != tt.want {
+			t.Errorf("hostnameContainerID(%q) = %q, want %q", tt.hostname, got, tt.want)
+		}
+	}
+}
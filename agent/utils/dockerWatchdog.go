@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// DockerWatchdog pings the Docker daemon and reports it lost once it has been
+// unreachable for too long, the collectors can't recover from a daemon that went away
+// so the agent restarts
+type DockerWatchdog struct {
+	// lostAfter is how long the daemon can be unreachable, the daemon is never reported
+	// lost when zero
+	lostAfter time.Duration
+
+	mu      sync.Mutex
+	lastOK  time.Time
+	lastErr error
+	now     func() time.Time
+}
+
+// NewDockerWatchdog creates a watchdog reporting the daemon lost after lostAfter
+func NewDockerWatchdog(lostAfter time.Duration) *DockerWatchdog {
+	return &DockerWatchdog{lostAfter: lostAfter, lastOK: time.Now(), now: time.Now}
+}
+
+// Run pings the daemon every interval until ctx is done. onLost is called once, with
+// the last error, when the daemon has been unreachable for longer than lostAfter.
+func (w *DockerWatchdog) Run(ctx context.Context, interval time.Duration, onLost func(err error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+		_, err := DockerClient.Ping(pingCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+
+		w.record(err)
+		if err := w.Alive(ctx); err != nil {
+			onLost(err)
+			return
+		}
+	}
+}
+
+// record keeps the result of a ping
+func (w *DockerWatchdog) record(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err == nil {
+		if w.lastErr != nil {
+			log.Printf("Docker daemon reachable again")
+		}
+		w.lastOK = w.now()
+	} else if w.lastErr == nil {
+		log.Printf("Docker daemon unreachable: %v", err)
+	}
+	w.lastErr = err
+}
+
+// Ready pings the daemon
+func (w *DockerWatchdog) Ready(ctx context.Context) error {
+	if _, err := DockerClient.Ping(ctx); err != nil {
+		return fmt.Errorf("docker daemon unreachable: %v", err)
+	}
+	return nil
+}
+
+// Alive fails once the daemon has been unreachable for longer than lostAfter
+func (w *DockerWatchdog) Alive(ctx context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.lostAfter <= 0 || w.lastErr == nil {
+		return nil
+	}
+	if down := w.now().Sub(w.lastOK); down > w.lostAfter {
+		return fmt.Errorf("docker daemon unreachable for %v: %v", down.Round(time.Second), w.lastErr)
+	}
+	return nil
+}
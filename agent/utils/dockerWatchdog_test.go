@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDockerWatchdogAlive(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	watchdog := NewDockerWatchdog(time.Minute)
+	watchdog.now = func() time.Time { return now }
+	alive := func(want bool) {
+		t.Helper()
+		if err := watchdog.Alive(context.Background()); (err == nil) != want {
+			t.Errorf("Alive() at %s = %v, want alive %t", now.Format(time.TimeOnly), err, want)
+		}
+	}
+	refused := errors.New("connection refused")
+
+	watchdog.record(nil)
+	alive(true)
+
+	// The daemon is lost once it has been unreachable for longer than lostAfter
+	now = now.Add(30 * time.Second)
+	watchdog.record(refused)
+	alive(true)
+	now = now.Add(30 * time.Second)
+	watchdog.record(refused)
+	alive(true)
+	now = now.Add(time.Second)
+	watchdog.record(refused)
+	alive(false)
+
+	// A successful ping makes it alive again
+	watchdog.record(nil)
+	alive(true)
+}
+
+func TestDockerWatchdogNeverLost(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	watchdog := NewDockerWatchdog(0)
+	watchdog.now = func() time.Time { return now }
+
+	watchdog.record(errors.New("connection refused"))
+	now = now.Add(24 * time.Hour)
+	if err := watchdog.Alive(context.Background()); err != nil {
+		t.Errorf("Alive() = %v, want alive without lostAfter", err)
+	}
+}
@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// healthCheckTimeout bounds the health checks and the Docker pings of the watchdog, so a
+// hung daemon or output fails the probe instead of stalling it
+const healthCheckTimeout = 2 * time.Second
+
+// HealthCheck reports why a dependency is failing, nil when it is healthy
+type HealthCheck func(ctx context.Context) error
+
+// HealthChecker is implemented by the outputs that can tell whether they are able to ship
+// the data, see Health
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+// Health holds the liveness and readiness checks of the agent. /healthz fails when the
+// agent should be restarted, /readyz when a dependency it needs is failing.
+type Health struct {
+	mu        sync.RWMutex
+	liveness  map[string]HealthCheck
+	readiness map[string]HealthCheck
+	// timeout is the time every check is given
+	timeout time.Duration
+}
+
+// healthReport is the body of /healthz and /readyz
+type healthReport struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// NewHealth creates a set of health checks, without checks the agent is live and ready
+func NewHealth() *Health {
+	return &Health{
+		liveness:  make(map[string]HealthCheck),
+		readiness: make(map[string]HealthCheck),
+		timeout:   healthCheckTimeout,
+	}
+}
+
+// AddLiveness adds a check to /healthz
+func (h *Health) AddLiveness(name string, check HealthCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.liveness[name] = check
+}
+
+// AddReadiness adds a check to /readyz
+func (h *Health) AddReadiness(name string, check HealthCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.readiness[name] = check
+}
+
+// LivenessHandler serves /healthz
+func (h *Health) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.serve(w, r, h.liveness)
+	})
+}
+
+// ReadinessHandler serves /readyz
+func (h *Health) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.serve(w, r, h.readiness)
+	})
+}
+
+// serve runs the checks and answers 200 when they all pass, 503 otherwise
+func (h *Health) serve(w http.ResponseWriter, r *http.Request, checks map[string]HealthCheck) {
+	h.mu.RLock()
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+	h.mu.RUnlock()
+	sort.Strings(names)
+
+	report := healthReport{Status: "ok", Checks: make(map[string]string, len(names))}
+	for _, name := range names {
+		h.mu.RLock()
+		check := checks[name]
+		h.mu.RUnlock()
+
+		ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+		err := check(ctx)
+		cancel()
+		if err != nil {
+			report.Status = "failing"
+			report.Checks[name] = err.Error()
+			continue
+		}
+		report.Checks[name] = "ok"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHealthHandlers(t *testing.T) {
+	passing := func(ctx context.Context) error { return nil }
+	failing := func(ctx context.Context) error { return errors.New("sink unreachable") }
+	hanging := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	tests := []struct {
+		name       string
+		checks     map[string]HealthCheck
+		wantCode   int
+		wantReport healthReport
+	}{
+		{
+			name:       "without checks",
+			wantCode:   http.StatusOK,
+			wantReport: healthReport{Status: "ok", Checks: map[string]string{}},
+		},
+		{
+			name:       "passing",
+			checks:     map[string]HealthCheck{"docker": passing, "sink": passing},
+			wantCode:   http.StatusOK,
+			wantReport: healthReport{Status: "ok", Checks: map[string]string{"docker": "ok", "sink": "ok"}},
+		},
+		{
+			name:       "failing",
+			checks:     map[string]HealthCheck{"docker": passing, "sink": failing},
+			wantCode:   http.StatusServiceUnavailable,
+			wantReport: healthReport{Status: "failing", Checks: map[string]string{"docker": "ok", "sink": "sink unreachable"}},
+		},
+		{
+			name:       "timed out",
+			checks:     map[string]HealthCheck{"docker": hanging},
+			wantCode:   http.StatusServiceUnavailable,
+			wantReport: healthReport{Status: "failing", Checks: map[string]string{"docker": context.DeadlineExceeded.Error()}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			health := NewHealth()
+			health.timeout = 10 * time.Millisecond
+			for name, check := range tt.checks {
+				health.AddReadiness(name, check)
+			}
+
+			recorder := httptest.NewRecorder()
+			health.ReadinessHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if recorder.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", recorder.Code, tt.wantCode)
+			}
+			var report healthReport
+			if err := json.NewDecoder(recorder.Body).Decode(&report); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(report, tt.wantReport) {
+				t.Errorf("report = %+v, want %+v", report, tt.wantReport)
+			}
+		})
+	}
+}
+
+func TestHealthLivenessIgnoresReadiness(t *testing.T) {
+	health := NewHealth()
+	health.AddReadiness("sink", func(ctx context.Context) error { return errors.New("sink unreachable") })
+
+	recorder := httptest.NewRecorder()
+	health.LivenessHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("/healthz status = %d, want %d while only a readiness check fails", recorder.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,161 @@
+package utils
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Labels overriding the rate limiting and sampling for a single container
+const (
+	LabelRateLimit  = LabelPrefix + "rate_limit"
+	LabelRateBurst  = LabelPrefix + "rate_burst"
+	LabelSampleRate = LabelPrefix + "sample_rate"
+)
+
+// DefaultKeepPattern matches the lines that are never sampled out
+const DefaultKeepPattern = `(?i)\b(error|err|fatal|panic|exception|critical|crit)\b`
+
+// LogLimits are the rate limiting and sampling settings of a container
+type LogLimits struct {
+	// Rate is the sustained number of lines per second shipped, 0 disables rate limiting
+	Rate float64
+	// Burst is how many lines can be shipped at once above the rate
+	Burst int
+	// SampleRate keeps 1 in SampleRate of the lines matching the sample patterns, 0 or 1
+	// disables sampling
+	SampleRate int
+}
+
+// LogDecision is what the limiter decided for a line
+type LogDecision int
+
+const (
+	LogKeep LogDecision = iota
+	LogSampled
+	LogRateLimited
+)
+
+// LogLimiter rate limits and samples the log lines of a container so a single noisy
+// container can't starve the others. Each collector uses its own limiter, created
+// with ForContainer, so it isn't safe for concurrent use.
+type LogLimiter struct {
+	limits LogLimits
+	sample []*regexp.Regexp
+	keep   *regexp.Regexp
+
+	tokens  float64
+	last    time.Time
+	seen    uint64
+	dropped uint64
+	since   time.Time
+}
+
+// NewLogLimiter creates the limiter template. Sampling applies to the lines matching one of
+// the sample patterns, or every line when there are none, except the lines matching keep.
+func NewLogLimiter(limits LogLimits, sample []*regexp.Regexp, keep *regexp.Regexp) *LogLimiter {
+	return &LogLimiter{limits: limits, sample: sample, keep: keep}
+}
+
+// ForContainer returns a new limiter for a container, applying its noxflow.rate_limit,
+// noxflow.rate_burst and noxflow.sample_rate label overrides. It returns nil when the
+// container is neither rate limited nor sampled.
+func (l *LogLimiter) ForContainer(labels map[string]string) *LogLimiter {
+	if l == nil {
+		return nil
+	}
+
+	limits := l.limits
+	if value, ok := labels[LabelRateLimit]; ok {
+		if rate, err := strconv.ParseFloat(value, 64); err == nil && rate >= 0 {
+			limits.Rate = rate
+		} else {
+			log.Printf("Ignoring invalid %s label %q", LabelRateLimit, value)
+		}
+	}
+	if value, ok := labels[LabelRateBurst]; ok {
+		if burst, err := strconv.Atoi(value); err == nil && burst > 0 {
+			limits.Burst = burst
+		} else {
+			log.Printf("Ignoring invalid %s label %q", LabelRateBurst, value)
+		}
+	}
+	if value, ok := labels[LabelSampleRate]; ok {
+		if sampleRate, err := strconv.Atoi(value); err == nil && sampleRate >= 0 {
+			limits.SampleRate = sampleRate
+		} else {
+			log.Printf("Ignoring invalid %s label %q", LabelSampleRate, value)
+		}
+	}
+
+	if limits.Rate <= 0 && limits.SampleRate <= 1 {
+		return nil
+	}
+	if limits.Burst < 1 {
+		limits.Burst = max(int(limits.Rate), 1)
+	}
+	return &LogLimiter{
+		limits: limits,
+		sample: l.sample,
+		keep:   l.keep,
+		tokens: float64(limits.Burst),
+		last:   time.Now(),
+	}
+}
+
+// Allow decides whether a line is shipped
+func (l *LogLimiter) Allow(line string) LogDecision {
+	if l.limits.SampleRate > 1 && l.sampled(line) {
+		l.seen++
+		if l.seen%uint64(l.limits.SampleRate) != 1 {
+			return LogSampled
+		}
+	}
+
+	if l.limits.Rate > 0 {
+		now := time.Now()
+		l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.limits.Rate, float64(l.limits.Burst))
+		l.last = now
+		if l.tokens < 1 {
+			if l.dropped == 0 {
+				l.since = now
+			}
+			l.dropped++
+			return LogRateLimited
+		}
+		l.tokens--
+	}
+	return LogKeep
+}
+
+// sampled reports whether sampling applies to the line
+func (l *LogLimiter) sampled(line string) bool {
+	if l.keep != nil && l.keep.MatchString(line) {
+		return false
+	}
+	if len(l.sample) == 0 {
+		return true
+	}
+	for _, pattern := range l.sample {
+		if pattern.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// Summary returns a synthetic log line reporting the lines dropped by the rate limit since
+// the last summary, it is empty when none were dropped. The line starts with a timestamp
+// like the lines read from Docker.
+func (l *LogLimiter) Summary() string {
+	if l.dropped == 0 {
+		return ""
+	}
+	now := time.Now()
+	summary := fmt.Sprintf("%s [noxflow] %d lines dropped by the rate limit of %g lines/s in the last %v",
+		now.UTC().Format(time.RFC3339Nano), l.dropped, l.limits.Rate, now.Sub(l.since).Round(time.Second))
+	l.dropped = 0
+	return summary
+}
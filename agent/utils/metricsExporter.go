@@ -0,0 +1,199 @@
+package utils
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	pb "github.com/nox/noxflow/agent/pkg/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// ContainerMetadata describes a container for the metrics labels
+type ContainerMetadata struct {
+	ContainerID   string
+	ContainerName string
+	Image         string
+	Labels        map[string]string
+}
+
+type containerSnapshot struct {
+	metadata *ContainerMetadata
+	stats    *pb.ContainerUsageStats
+}
+
+// MetricsExporter exposes the latest usage stats of every container as Prometheus metrics
+type MetricsExporter struct {
+	mu           sync.RWMutex
+	containers   map[string]*containerSnapshot
+	labelKeys    []string
+	labelNames   []string
+	descriptions map[string]*prometheus.Desc
+}
+
+type metricSpec struct {
+	name      string
+	help      string
+	valueType prometheus.ValueType
+	value     func(stats *pb.ContainerUsageStats) float64
+}
+
+// containerMetrics lists the per-container metrics, network metrics are handled separately
+// since they carry an extra interface label
+var containerMetrics = []metricSpec{
+	{"cpu_percent", "CPU usage in percent of the host", prometheus.GaugeValue,
+		func(s *pb.ContainerUsageStats) float64 { return s.CpuPercent }},
+	{"cpu_usage_seconds_total", "Cumulative CPU time consumed", prometheus.CounterValue,
+		func(s *pb.ContainerUsageStats) float64 { return float64(s.CpuUsage) / 1e9 }},
+	{"cpu_throttling_periods_total", "Number of CPU enforcement periods", prometheus.CounterValue,
+		func(s *pb.ContainerUsageStats) float64 { return float64(s.CpuThrottlingPeriods) }},
+	{"cpu_throttled_periods_total", "Number of CPU periods the container was throttled in", prometheus.CounterValue,
+		func(s *pb.ContainerUsageStats) float64 { return float64(s.CpuThrottledPeriods) }},
+	{"cpu_throttled_seconds_total", "Total time the container was throttled", prometheus.CounterValue,
+		func(s *pb.ContainerUsageStats) float64 { return float64(s.CpuThrottledTime) / 1e9 }},
+	{"memory_usage_bytes", "Memory usage without the inactive page cache", prometheus.GaugeValue,
+		func(s *pb.ContainerUsageStats) float64 { return float64(s.MemoryUsage) }},
+	{"memory_limit_bytes", "Memory limit of the container", prometheus.GaugeValue,
+		func(s *pb.ContainerUsageStats) float64 { return float64(s.MemoryLimit) }},
+	{"memory_cache_bytes", "Page cache used by the container", prometheus.GaugeValue,
+		func(s *pb.ContainerUsageStats) float64 { return float64(s.MemoryCache) }},
+	{"memory_percent", "Memory usage in percent of the limit", prometheus.GaugeValue,
+		func(s *pb.ContainerUsageStats) float64 { return s.MemoryPercent }},
+	{"oom_kills_total", "Number of OOM kills inside the container", prometheus.CounterValue,
+		func(s *pb.ContainerUsageStats) float64 { return float64(s.OomKills) }},
+	{"blkio_read_bytes_total", "Bytes read from block devices", prometheus.CounterValue,
+		func(s *pb.ContainerUsageStats) float64 { return float64(s.BlkioReadBytes) }},
+	{"blkio_write_bytes_total", "Bytes written to block devices", prometheus.CounterValue,
+		func(s *pb.ContainerUsageStats) float64 { return float64(s.BlkioWriteBytes) }},
+	{"blkio_read_ops_total", "Read operations on block devices", prometheus.CounterValue,
+		func(s *pb.ContainerUsageStats) float64 { return float64(s.BlkioReadOps) }},
+	{"blkio_write_ops_total", "Write operations on block devices", prometheus.CounterValue,
+		func(s *pb.ContainerUsageStats) float64 { return float64(s.BlkioWriteOps) }},
+	{"pids_current", "Number of processes in the container", prometheus.GaugeValue,
+		func(s *pb.ContainerUsageStats) float64 { return float64(s.PidsCurrent) }},
+	{"pids_limit", "Maximum number of processes in the container", prometheus.GaugeValue,
+		func(s *pb.ContainerUsageStats) float64 { return float64(PidsLimit(s.PidsLimit)) }},
+}
+
+type networkMetricSpec struct {
+	name  string
+	help  string
+	value func(s *pb.NetworkInterfaceStats) float64
+}
+
+var networkMetrics = []networkMetricSpec{
+	{"network_receive_bytes_total", "Bytes received", func(s *pb.NetworkInterfaceStats) float64 { return float64(s.RxBytes) }},
+	{"network_receive_packets_total", "Packets received", func(s *pb.NetworkInterfaceStats) float64 { return float64(s.RxPackets) }},
+	{"network_receive_errors_total", "Receive errors", func(s *pb.NetworkInterfaceStats) float64 { return float64(s.RxErrors) }},
+	{"network_receive_dropped_total", "Received packets dropped", func(s *pb.NetworkInterfaceStats) float64 { return float64(s.RxDropped) }},
+	{"network_transmit_bytes_total", "Bytes transmitted", func(s *pb.NetworkInterfaceStats) float64 { return float64(s.TxBytes) }},
+	{"network_transmit_packets_total", "Packets transmitted", func(s *pb.NetworkInterfaceStats) float64 { return float64(s.TxPackets) }},
+	{"network_transmit_errors_total", "Transmit errors", func(s *pb.NetworkInterfaceStats) float64 { return float64(s.TxErrors) }},
+	{"network_transmit_dropped_total", "Transmitted packets dropped", func(s *pb.NetworkInterfaceStats) float64 { return float64(s.TxDropped) }},
+}
+
+var invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// NewMetricsExporter creates an exporter adding the given container labels to every metric.
+// Label keys that differ only in characters Prometheus doesn't allow, such as app.name and
+// app-name, would give two labels the same name and are rejected.
+func NewMetricsExporter(labelKeys []string) (*MetricsExporter, error) {
+	labelNames := []string{"container_id", "container_name", "image"}
+	sanitized := make(map[string]string)
+	var keys []string
+	for _, key := range labelKeys {
+		name := "container_label_" + invalidLabelChars.ReplaceAllString(key, "_")
+		if other, ok := sanitized[name]; ok {
+			if other == key {
+				continue
+			}
+			return nil, fmt.Errorf("labels %q and %q are both exported as %s", other, key, name)
+		}
+		sanitized[name] = key
+		keys = append(keys, key)
+		labelNames = append(labelNames, name)
+	}
+
+	descriptions := make(map[string]*prometheus.Desc)
+	for _, metric := range containerMetrics {
+		descriptions[metric.name] = prometheus.NewDesc("noxflow_container_"+metric.name, metric.help, labelNames, nil)
+	}
+	networkLabelNames := append(append([]string{}, labelNames...), "interface")
+	for _, metric := range networkMetrics {
+		descriptions[metric.name] = prometheus.NewDesc("noxflow_container_"+metric.name, metric.help, networkLabelNames, nil)
+	}
+
+	return &MetricsExporter{
+		containers:   make(map[string]*containerSnapshot),
+		labelKeys:    keys,
+		labelNames:   labelNames,
+		descriptions: descriptions,
+	}, nil
+}
+
+// Update stores the latest stats of a container
+func (e *MetricsExporter) Update(metadata *ContainerMetadata, stats *pb.ContainerUsageStats) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.containers[metadata.ContainerID] = &containerSnapshot{metadata: metadata, stats: stats}
+}
+
+// Remove drops a container so its metrics are no longer exposed
+func (e *MetricsExporter) Remove(containerID string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.containers, containerID)
+}
+
+// Describe implements prometheus.Collector
+func (e *MetricsExporter) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range e.descriptions {
+		ch <- desc
+	}
+}
+
+// Collect implements prometheus.Collector
+func (e *MetricsExporter) Collect(ch chan<- prometheus.Metric) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	for _, snapshot := range e.containers {
+		labels := []string{
+			snapshot.metadata.ContainerID,
+			strings.TrimPrefix(snapshot.metadata.ContainerName, "/"),
+			snapshot.metadata.Image,
+		}
+		for _, key := range e.labelKeys {
+			labels = append(labels, snapshot.metadata.Labels[key])
+		}
+
+		for _, metric := range containerMetrics {
+			ch <- prometheus.MustNewConstMetric(e.descriptions[metric.name], metric.valueType, metric.value(snapshot.stats), labels...)
+		}
+
+		for _, network := range snapshot.stats.Networks {
+			networkLabels := append(append([]string{}, labels...), network.Interface)
+			for _, metric := range networkMetrics {
+				ch <- prometheus.MustNewConstMetric(e.descriptions[metric.name], prometheus.CounterValue, metric.value(network), networkLabels...)
+			}
+		}
+	}
+}
+
+// Serve exposes the metrics on /metrics at the given address
+func (e *MetricsExporter) Serve(addr string) error {
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(e); err != nil {
+		return fmt.Errorf("failed to register metrics exporter: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	log.Printf("Serving Prometheus metrics on %s/metrics", addr)
+	return http.ListenAndServe(addr, mux)
+}
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"testing"
+
+	pb "github.com/nox/noxflow/agent/pkg/proto"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestNewMetricsExporterLabels(t *testing.T) {
+	tests := []struct {
+		name      string
+		labelKeys []string
+		wantErr   bool
+	}{
+		{name: "no labels"},
+		{name: "distinct labels", labelKeys: []string{"app.name", "team"}},
+		{name: "same label twice", labelKeys: []string{"team", "team"}},
+		{name: "labels sanitized to the same name", labelKeys: []string{"app.name", "app-name"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter, err := NewMetricsExporter(tt.labelKeys)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("NewMetricsExporter() accepted labels exported under the same name")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewMetricsExporter() error = %v", err)
+			}
+
+			exporter.Update(&ContainerMetadata{
+				ContainerID:   "0123456789ab",
+				ContainerName: "/api",
+				Image:         "nginx:1.25",
+				Labels:        map[string]string{"app.name": "shop", "team": "ops"},
+			}, &pb.ContainerUsageStats{PidsCurrent: 8})
+
+			registry := prometheus.NewRegistry()
+			if err := registry.Register(exporter); err != nil {
+				t.Fatalf("Register() error = %v", err)
+			}
+			if _, err := registry.Gather(); err != nil {
+				t.Fatalf("Gather() error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,308 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// RedactAction is what happens to sensitive data found in a log line
+type RedactAction string
+
+const (
+	// RedactReplace replaces the match with [REDACTED:<detector>]
+	RedactReplace RedactAction = "replace"
+	// RedactHash replaces the match with a hash so equal values can still be correlated
+	RedactHash RedactAction = "hash"
+	// RedactDrop drops the whole line
+	RedactDrop RedactAction = "drop"
+)
+
+// Labels overriding the redaction for a single container
+const (
+	LabelRedact       = LabelPrefix + "redact"
+	LabelRedactAction = LabelPrefix + "redact.action"
+)
+
+// DefaultDetectors are the built-in detectors enabled when none are configured
+var DefaultDetectors = []string{"credit_card", "jwt", "aws_key", "bearer_token"}
+
+// Detector finds one kind of sensitive data. When the regex has a capture group only
+// the group is redacted, so a detector can match on its surroundings.
+type Detector struct {
+	Name     string
+	regex    *regexp.Regexp
+	validate func(match string) bool
+	// locate returns the offsets of the values to redact within a match, for detectors
+	// whose matches can hold the value among other data
+	locate func(match string) [][2]int
+}
+
+var builtinDetectors = map[string]*Detector{
+	"credit_card": {
+		Name: "credit_card",
+		// A run of digit groups, the card numbers are located in it by locateCardNumbers
+		regex:  regexp.MustCompile(`\b\d(?:[ -]?\d){12,}\b`),
+		locate: locateCardNumbers,
+	},
+	"email": {
+		Name:  "email",
+		regex: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+	},
+	"jwt": {
+		Name:  "jwt",
+		regex: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`),
+	},
+	"aws_key": {
+		Name:  "aws_key",
+		regex: regexp.MustCompile(`\b(?:AKIA|ASIA|AGPA|AIDA|AROA)[0-9A-Z]{16}\b|(?i:aws_secret_access_key)\s*[=:]\s*"?([A-Za-z0-9/+=]{40})`),
+	},
+	"bearer_token": {
+		Name:  "bearer_token",
+		regex: regexp.MustCompile(`(?i)\bbearer\s+([A-Za-z0-9._~+/-]+=*)`),
+	},
+	"ip": {
+		Name:  "ip",
+		regex: regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b|\b(?:[0-9A-Fa-f]{0,4}:){2,7}[0-9A-Fa-f]{0,4}\b`),
+		validate: func(match string) bool {
+			_, err := netip.ParseAddr(match)
+			return err == nil
+		},
+	},
+}
+
+// ParseDetector parses a custom detector written as <name>=<regex>
+func ParseDetector(expr string) (*Detector, error) {
+	name, pattern, found := strings.Cut(expr, "=")
+	if !found || name == "" || pattern == "" {
+		return nil, fmt.Errorf("invalid redaction pattern %q: expected <name>=<regex>", expr)
+	}
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redaction pattern %q: %v", expr, err)
+	}
+	return &Detector{Name: name, regex: regex}, nil
+}
+
+// Redactor removes sensitive data from log lines before they leave the host
+type Redactor struct {
+	detectors []*Detector
+	action    RedactAction
+	hashKey   []byte
+}
+
+// NewRedactor creates a redactor with the named built-in detectors and the custom ones.
+// Hashes are keyed with hashKey, the hash action needs one: the redacted values are short
+// enough to be brute forced from an unkeyed hash.
+func NewRedactor(detectorNames []string, custom []*Detector, action RedactAction, hashKey string) (*Redactor, error) {
+	if err := validateAction(action); err != nil {
+		return nil, err
+	}
+	if action == RedactHash && hashKey == "" {
+		return nil, fmt.Errorf("the %s redaction action needs a hash key", RedactHash)
+	}
+
+	detectors, err := lookupDetectors(detectorNames)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Redactor{
+		detectors: append(detectors, custom...),
+		action:    action,
+		hashKey:   []byte(hashKey),
+	}, nil
+}
+
+// ForContainer returns the redactor to use for a container, applying its noxflow.redact
+// and noxflow.redact.action label overrides. The noxflow.redact label can only add
+// detectors to the agent's ones, whoever starts a container must not be able to turn the
+// redaction off. Invalid overrides are ignored so a typo never disables the redaction.
+func (r *Redactor) ForContainer(labels map[string]string) *Redactor {
+	if r == nil {
+		return nil
+	}
+
+	redactor := *r
+	if value, ok := labels[LabelRedactAction]; ok {
+		action := RedactAction(strings.TrimSpace(value))
+		if err := validateAction(action); err != nil {
+			log.Printf("Ignoring %s label: %v", LabelRedactAction, err)
+		} else if action == RedactHash && len(r.hashKey) == 0 {
+			log.Printf("Ignoring %s label: the %s action needs -redact-hash-key on the agent", LabelRedactAction, RedactHash)
+		} else {
+			redactor.action = action
+		}
+	}
+	if value, ok := labels[LabelRedact]; ok {
+		names := splitList(value)
+		if slices.Contains(names, "none") {
+			log.Printf("Ignoring \"none\" in %s label: labels can only add detectors, use -redact=none on the agent", LabelRedact)
+		}
+		detectors, err := lookupDetectors(names)
+		if err != nil {
+			log.Printf("Ignoring %s label: %v", LabelRedact, err)
+		} else {
+			redactor.detectors = slices.Clone(r.detectors)
+			for _, detector := range detectors {
+				if !slices.Contains(redactor.detectors, detector) {
+					redactor.detectors = append(redactor.detectors, detector)
+				}
+			}
+		}
+	}
+	return &redactor
+}
+
+// Redact returns the line with the sensitive data redacted and the number of redactions.
+// It reports false when the line must be dropped.
+func (r *Redactor) Redact(line string) (string, int, bool) {
+	count := 0
+	for _, detector := range r.detectors {
+		matches := detector.regex.FindAllStringSubmatchIndex(line, -1)
+		if len(matches) == 0 {
+			continue
+		}
+
+		var builder strings.Builder
+		last := 0
+		for _, match := range matches {
+			// Redact the first capture group that matched, or the whole match
+			start, end := match[0], match[1]
+			for group := 2; group < len(match); group += 2 {
+				if match[group] >= 0 {
+					start, end = match[group], match[group+1]
+					break
+				}
+			}
+
+			value := line[start:end]
+			spans := [][2]int{{0, len(value)}}
+			if detector.locate != nil {
+				spans = detector.locate(value)
+			} else if detector.validate != nil && !detector.validate(value) {
+				continue
+			}
+			for _, span := range spans {
+				count++
+				builder.WriteString(line[last : start+span[0]])
+				builder.WriteString(r.replacement(detector.Name, value[span[0]:span[1]]))
+				last = start + span[1]
+			}
+		}
+		builder.WriteString(line[last:])
+		line = builder.String()
+
+		if count > 0 && r.action == RedactDrop {
+			return "", count, false
+		}
+	}
+	return line, count, true
+}
+
+// replacement returns what a redacted value is replaced with
+func (r *Redactor) replacement(detector, value string) string {
+	if r.action != RedactHash {
+		return "[REDACTED:" + detector + "]"
+	}
+
+	mac := hmac.New(sha256.New, r.hashKey)
+	mac.Write([]byte(value))
+	sum := mac.Sum(nil)
+	return "[" + detector + ":" + hex.EncodeToString(sum[:6]) + "]"
+}
+
+// lookupDetectors returns the built-in detectors with the given names
+func lookupDetectors(names []string) ([]*Detector, error) {
+	detectors := make([]*Detector, 0, len(names))
+	for _, name := range names {
+		if name == "none" {
+			continue
+		}
+		detector, ok := builtinDetectors[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown redaction detector %q", name)
+		}
+		detectors = append(detectors, detector)
+	}
+	return detectors, nil
+}
+
+func validateAction(action RedactAction) error {
+	switch action {
+	case RedactReplace, RedactHash, RedactDrop:
+		return nil
+	}
+	return fmt.Errorf("invalid redaction action %q: expected replace, hash or drop", action)
+}
+
+// locateCardNumbers finds the card numbers in a run of digit groups. Every span of
+// whole groups holding 13 to 19 digits is checked, the longest valid one starting at a
+// group wins, so a number written next to a card such as "qty 2 4111 1111 1111 1111"
+// doesn't hide it.
+func locateCardNumbers(run string) [][2]int {
+	var groups [][2]int
+	for i := 0; i < len(run); {
+		if run[i] < '0' || run[i] > '9' {
+			i++
+			continue
+		}
+		start := i
+		for i < len(run) && run[i] >= '0' && run[i] <= '9' {
+			i++
+		}
+		groups = append(groups, [2]int{start, i})
+	}
+
+	var spans [][2]int
+	for first := 0; first < len(groups); {
+		last := -1
+		digits := 0
+		for end := first; end < len(groups); end++ {
+			digits += groups[end][1] - groups[end][0]
+			if digits > 19 {
+				break
+			}
+			if digits >= 13 && luhnValid(run[groups[first][0]:groups[end][1]]) {
+				last = end
+			}
+		}
+		if last < 0 {
+			first++
+			continue
+		}
+		spans = append(spans, [2]int{groups[first][0], groups[last][1]})
+		first = last + 1
+	}
+	return spans
+}
+
+// luhnValid reports whether the digits of a candidate card number pass the Luhn check
+func luhnValid(number string) bool {
+	sum := 0
+	digits := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		digit := int(c - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		digits++
+		double = !double
+	}
+	return digits >= 13 && digits <= 19 && sum%10 == 0
+}
@@ -0,0 +1,194 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedactCreditCards(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{
+			name: "quantity before the card",
+			line: "qty 2 4111 1111 1111 1111",
+			want: "qty 2 [REDACTED:credit_card]",
+		},
+		{
+			name: "quantity of several digits before the card",
+			line: "order 2222 4111 1111 1111 1111 paid",
+			want: "order 2222 [REDACTED:credit_card] paid",
+		},
+		{
+			name: "dashes",
+			line: "card=4111-1111-1111-1111",
+			want: "card=[REDACTED:credit_card]",
+		},
+		{
+			name: "unseparated",
+			line: "card 4111111111111111 expires 12/30",
+			want: "card [REDACTED:credit_card] expires 12/30",
+		},
+		{
+			name: "amex grouping",
+			line: "amex 3782 822463 10005",
+			want: "amex [REDACTED:credit_card]",
+		},
+		{
+			name: "two cards in a row",
+			line: "4111 1111 1111 1111 5500 0000 0000 0004",
+			want: "[REDACTED:credit_card] [REDACTED:credit_card]",
+		},
+		{
+			name: "number failing the Luhn check",
+			line: "id 4111 1111 1111 1112",
+			want: "id 4111 1111 1111 1112",
+		},
+		{
+			name: "too few digits",
+			line: "took 1234 5678 ms",
+			want: "took 1234 5678 ms",
+		},
+		{
+			name: "long unseparated id",
+			line: "trace 41111111111111111111111",
+			want: "trace 41111111111111111111111",
+		},
+	}
+
+	redactor, err := NewRedactor([]string{"credit_card"}, nil, RedactReplace, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, ok := redactor.Redact(tt.line)
+			if !ok {
+				t.Fatalf("Redact(%q) dropped the line", tt.line)
+			}
+			if got != tt.want {
+				t.Errorf("Redact(%q) = %q, want %q", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactActions(t *testing.T) {
+	const line = "token: Bearer abc.def-123 for 4111 1111 1111 1111"
+
+	replace, err := NewRedactor([]string{"credit_card", "bearer_token"}, nil, RedactReplace, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, count, ok := replace.Redact(line)
+	if want := "token: Bearer [REDACTED:bearer_token] for [REDACTED:credit_card]"; got != want || count != 2 || !ok {
+		t.Errorf("replace: Redact() = %q, %d, %v, want %q, 2, true", got, count, ok, want)
+	}
+
+	hash, err := NewRedactor([]string{"credit_card"}, nil, RedactHash, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _, _ := hash.Redact("a 4111 1111 1111 1111")
+	second, _, _ := hash.Redact("b 4111 1111 1111 1111")
+	if strings.Contains(first, "4111") || first[2:] != second[2:] {
+		t.Errorf("hash: equal cards must give equal hashes, got %q and %q", first, second)
+	}
+
+	if _, err := NewRedactor([]string{"credit_card"}, nil, RedactHash, ""); err == nil {
+		t.Error("hash: NewRedactor() accepted the hash action without a key")
+	}
+
+	drop, err := NewRedactor([]string{"credit_card"}, nil, RedactDrop, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := drop.Redact(line); ok {
+		t.Error("drop: Redact() kept a line with a card")
+	}
+	if _, _, ok := drop.Redact("nothing to see"); !ok {
+		t.Error("drop: Redact() dropped a clean line")
+	}
+}
+
+func TestRedactorForContainer(t *testing.T) {
+	custom, err := ParseDetector(`order_id=order-\d+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	redactor, err := NewRedactor([]string{"credit_card"}, []*Detector{custom}, RedactReplace, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const line = "order-42 paid by a@example.com with 4111 1111 1111 1111"
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   string
+	}{
+		{
+			name: "no labels",
+			want: "[REDACTED:order_id] paid by a@example.com with [REDACTED:credit_card]",
+		},
+		{
+			name:   "label adds a detector",
+			labels: map[string]string{LabelRedact: "email"},
+			want:   "[REDACTED:order_id] paid by [REDACTED:email] with [REDACTED:credit_card]",
+		},
+		{
+			name:   "label can't disable the redaction",
+			labels: map[string]string{LabelRedact: "none"},
+			want:   "[REDACTED:order_id] paid by a@example.com with [REDACTED:credit_card]",
+		},
+		{
+			name:   "label can't drop the agent detectors",
+			labels: map[string]string{LabelRedact: "none,email"},
+			want:   "[REDACTED:order_id] paid by [REDACTED:email] with [REDACTED:credit_card]",
+		},
+		{
+			name:   "unknown detector is ignored",
+			labels: map[string]string{LabelRedact: "email,typo"},
+			want:   "[REDACTED:order_id] paid by a@example.com with [REDACTED:credit_card]",
+		},
+		{
+			name:   "action override",
+			labels: map[string]string{LabelRedactAction: "drop"},
+			want:   "",
+		},
+		{
+			name:   "invalid action is ignored",
+			labels: map[string]string{LabelRedactAction: "keep"},
+			want:   "[REDACTED:order_id] paid by a@example.com with [REDACTED:credit_card]",
+		},
+		{
+			name:   "hash without a key is ignored",
+			labels: map[string]string{LabelRedactAction: "hash"},
+			want:   "[REDACTED:order_id] paid by a@example.com with [REDACTED:credit_card]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, _ := redactor.ForContainer(tt.labels).Redact(line)
+			if got != tt.want {
+				t.Errorf("Redact() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	keyed, err := NewRedactor([]string{"credit_card"}, nil, RedactReplace, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _ := keyed.ForContainer(map[string]string{LabelRedactAction: "hash"}).Redact(line); strings.Contains(got, "REDACTED") || strings.Contains(got, "4111") {
+		t.Errorf("hash with a key: Redact() = %q, want the card hashed", got)
+	}
+
+	// The overrides of a container must not leak into the agent's redactor
+	if got, _, _ := redactor.Redact(line); strings.Contains(got, "[REDACTED:email]") {
+		t.Errorf("ForContainer() changed the agent's redactor: %q", got)
+	}
+}
@@ -3,12 +3,23 @@ package utils
 import (
 	"context"
 	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	// Register the gzip and zstd compressors
+	_ "github.com/nox/noxflow/agent/pkg/compression"
 	pb "github.com/nox/noxflow/agent/pkg/proto"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 // MonitorClient handles the gRPC connections and streams for monitoring
@@ -16,33 +27,68 @@ type MonitorClient struct {
 	connections       []*grpc.ClientConn
 	logClients        []pb.LogStreamingServiceClient
 	usageClients      []pb.UsageStreamingServiceClient
+	agentClients      []pb.AgentServiceClient
+	eventClients      []pb.EventStreamingServiceClient
 	logStreams        []pb.LogStreamingService_StreamLogsClient
 	usageStreams      []pb.UsageStreamingService_StreamUsageClient
+	eventStreams      []pb.EventStreamingService_StreamEventsClient
 	ctx               context.Context
 	cancel            context.CancelFunc
 	mu                sync.Mutex
 	reconnectInterval time.Duration
 	currentConnIndex  int
+	// compression is the gRPC compressor of the messages, it falls back along
+	// compressionFallbacks when the server rejects it
+	compression string
 }
 
-// NewMonitorClient creates a new instance of MonitorClient with multiple connections
-func NewMonitorClient(serverAddr string, numConnections int) (*MonitorClient, error) {
+// agentIDMetadataKey is the gRPC metadata key the server uses to tell agents apart
+const agentIDMetadataKey = "noxflow-agent-id"
+
+// compressionFallbacks is the compression to use when the server doesn't support one
+var compressionFallbacks = map[string]string{
+	"zstd": "gzip",
+	"gzip": "none",
+}
+
+// NewMonitorClient creates a new instance of MonitorClient with multiple connections.
+// Messages are compressed with the named gRPC compressor, none when empty. A server that
+// doesn't support the compressor rejects the calls, the client then falls back to gzip
+// and to no compression.
+func NewMonitorClient(serverAddr string, numConnections int, compression string) (*MonitorClient, error) {
+	dialOptions := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	if compression == "" {
+		compression = "none"
+	}
+	if compression != "none" && encoding.GetCompressor(compression) == nil {
+		return nil, fmt.Errorf("unknown compression %q", compression)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 
+	// Identify this agent on every stream by its hostname
+	if hostname, err := os.Hostname(); err == nil {
+		ctx = metadata.AppendToOutgoingContext(ctx, agentIDMetadataKey, hostname)
+	}
+
 	client := &MonitorClient{
 		connections:       make([]*grpc.ClientConn, numConnections),
 		logClients:        make([]pb.LogStreamingServiceClient, numConnections),
 		usageClients:      make([]pb.UsageStreamingServiceClient, numConnections),
+		agentClients:      make([]pb.AgentServiceClient, numConnections),
+		eventClients:      make([]pb.EventStreamingServiceClient, numConnections),
 		logStreams:        make([]pb.LogStreamingService_StreamLogsClient, numConnections),
 		usageStreams:      make([]pb.UsageStreamingService_StreamUsageClient, numConnections),
+		eventStreams:      make([]pb.EventStreamingService_StreamEventsClient, numConnections),
 		ctx:               ctx,
 		cancel:            cancel,
 		reconnectInterval: 5 * time.Second,
+		compression:       compression,
 	}
 
 	// Initialize all connections
 	for i := 0; i < numConnections; i++ {
-		conn, err := grpc.DialContext(ctx, serverAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		conn, err := grpc.DialContext(ctx, serverAddr, dialOptions...)
 		if err != nil {
 			client.Close()
 			return nil, fmt.Errorf("failed to connect to server: %v", err)
@@ -50,11 +96,60 @@ func NewMonitorClient(serverAddr string, numConnections int) (*MonitorClient, er
 		client.connections[i] = conn
 		client.logClients[i] = pb.NewLogStreamingServiceClient(conn)
 		client.usageClients[i] = pb.NewUsageStreamingServiceClient(conn)
+		client.agentClients[i] = pb.NewAgentServiceClient(conn)
+		client.eventClients[i] = pb.NewEventStreamingServiceClient(conn)
 	}
 
 	return client, nil
 }
 
+// callOptions returns the options compressing the calls with the current compression.
+// It must be called with the lock held.
+func (c *MonitorClient) callOptions() []grpc.CallOption {
+	if c.compression == "none" {
+		return nil
+	}
+	return []grpc.CallOption{grpc.UseCompressor(c.compression)}
+}
+
+// compressionRejected reports whether the call failed because the server doesn't support
+// the compression, and falls back to the next compression the first time a compression
+// is rejected. The call can be retried when it reports true.
+func (c *MonitorClient) compressionRejected(err error) bool {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.Unimplemented {
+		return false
+	}
+	// gRPC answers "grpc: Decompressor is not installed for grpc-encoding \"zstd\""
+	_, name, found := strings.Cut(st.Message(), "grpc-encoding ")
+	if !found {
+		return false
+	}
+	rejected, err := strconv.Unquote(name)
+	if err != nil {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.compression == rejected {
+		c.compression = compressionFallbacks[rejected]
+		if c.compression == "" {
+			c.compression = "none"
+		}
+		log.Printf("Server does not support %s compression, falling back to %s", rejected, c.compression)
+		return true
+	}
+	// A call made before the fall back can still be rejected, the response of a server
+	// compressing with an encoding the agent lacks can't be fixed by a retry
+	for next := compressionFallbacks[rejected]; next != ""; next = compressionFallbacks[next] {
+		if next == c.compression {
+			return true
+		}
+	}
+	return false
+}
+
 // getNextConnection returns the next connection index in a round-robin fashion
 func (c *MonitorClient) getNextConnection() int {
 	c.mu.Lock()
@@ -72,7 +167,7 @@ func (c *MonitorClient) initLogStream(index int) error {
 		return nil
 	}
 
-	stream, err := c.logClients[index].StreamLogs(c.ctx)
+	stream, err := c.logClients[index].StreamLogs(c.ctx, c.callOptions()...)
 	if err != nil {
 		return fmt.Errorf("failed to initialize log stream: %v", err)
 	}
@@ -90,7 +185,7 @@ func (c *MonitorClient) initUsageStream(index int) error {
 		return nil
 	}
 
-	stream, err := c.usageClients[index].StreamUsage(c.ctx)
+	stream, err := c.usageClients[index].StreamUsage(c.ctx, c.callOptions()...)
 	if err != nil {
 		return fmt.Errorf("failed to initialize usage stream: %v", err)
 	}
@@ -99,6 +194,24 @@ func (c *MonitorClient) initUsageStream(index int) error {
 	return nil
 }
 
+// initEventStream initializes the event streaming connection for a specific index
+func (c *MonitorClient) initEventStream(index int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.eventStreams[index] != nil {
+		return nil
+	}
+
+	stream, err := c.eventClients[index].StreamEvents(c.ctx, c.callOptions()...)
+	if err != nil {
+		return fmt.Errorf("failed to initialize event stream: %v", err)
+	}
+
+	c.eventStreams[index] = stream
+	return nil
+}
+
 // SendLog sends container log data to the server using round-robin connection selection
 func (c *MonitorClient) SendLog(metadata *pb.ContainerLogMetadata, logData string) (*pb.LogResponse, error) {
 	connIndex := c.getNextConnection()
@@ -128,6 +241,9 @@ func (c *MonitorClient) SendLog(metadata *pb.ContainerLogMetadata, logData strin
 		c.mu.Lock()
 		c.logStreams[connIndex] = nil
 		c.mu.Unlock()
+		if c.compressionRejected(err) {
+			return c.SendLog(metadata, logData)
+		}
 		return nil, fmt.Errorf("failed to receive log response: %v", err)
 	}
 
@@ -158,12 +274,111 @@ func (c *MonitorClient) SendUsageStats(stats *pb.ContainerUsageStats) (*pb.Usage
 		c.mu.Lock()
 		c.usageStreams[connIndex] = nil
 		c.mu.Unlock()
+		if c.compressionRejected(err) {
+			return c.SendUsageStats(stats)
+		}
 		return nil, fmt.Errorf("failed to receive usage response: %v", err)
 	}
 
 	return response, nil
 }
 
+// SendEvent sends a container lifecycle event using round-robin connection selection
+func (c *MonitorClient) SendEvent(event *pb.ContainerEvent) (*pb.EventResponse, error) {
+	connIndex := c.getNextConnection()
+	if err := c.initEventStream(connIndex); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	stream := c.eventStreams[connIndex]
+	c.mu.Unlock()
+
+	err := stream.Send(event)
+	if err != nil {
+		c.mu.Lock()
+		c.eventStreams[connIndex] = nil
+		c.mu.Unlock()
+		return nil, fmt.Errorf("failed to send event: %v", err)
+	}
+
+	response, err := stream.Recv()
+	if err != nil {
+		c.mu.Lock()
+		c.eventStreams[connIndex] = nil
+		c.mu.Unlock()
+		if c.compressionRejected(err) {
+			return c.SendEvent(event)
+		}
+		return nil, fmt.Errorf("failed to receive event response: %v", err)
+	}
+
+	return response, nil
+}
+
+// Register announces the agent and its containers to the server
+func (c *MonitorClient) Register(registration *pb.AgentRegistration) (*pb.RegisterResponse, error) {
+	connIndex := c.getNextConnection()
+
+	ctx, cancel := context.WithTimeout(c.ctx, 10*time.Second)
+	defer cancel()
+
+	c.mu.Lock()
+	options := c.callOptions()
+	c.mu.Unlock()
+
+	response, err := c.agentClients[connIndex].Register(ctx, registration, options...)
+	if c.compressionRejected(err) {
+		return c.Register(registration)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to register agent: %v", err)
+	}
+
+	return response, nil
+}
+
+// SendHeartbeat reports the agent health to the server
+func (c *MonitorClient) SendHeartbeat(heartbeat *pb.AgentHeartbeat) (*pb.HeartbeatResponse, error) {
+	connIndex := c.getNextConnection()
+
+	ctx, cancel := context.WithTimeout(c.ctx, 10*time.Second)
+	defer cancel()
+
+	c.mu.Lock()
+	options := c.callOptions()
+	c.mu.Unlock()
+
+	response, err := c.agentClients[connIndex].Heartbeat(ctx, heartbeat, options...)
+	if c.compressionRejected(err) {
+		return c.SendHeartbeat(heartbeat)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to send heartbeat: %v", err)
+	}
+
+	return response, nil
+}
+
+// CheckHealth fails unless one of the connections to the server is up. Idle connections
+// are asked to connect so the next check reflects the server.
+func (c *MonitorClient) CheckHealth(ctx context.Context) error {
+	var state connectivity.State
+	for _, conn := range c.connections {
+		if conn == nil {
+			continue
+		}
+		state = conn.GetState()
+		if state == connectivity.Ready {
+			return nil
+		}
+		if state == connectivity.Idle {
+			conn.Connect()
+		}
+	}
+	return fmt.Errorf("no connection to the server is ready (%s)", state)
+}
+
 // Close closes all connections and streams
 func (c *MonitorClient) Close() error {
 	c.cancel()
@@ -174,6 +389,9 @@ func (c *MonitorClient) Close() error {
 		if c.usageStreams[i] != nil {
 			c.usageStreams[i].CloseSend()
 		}
+		if c.eventStreams[i] != nil {
+			c.eventStreams[i].CloseSend()
+		}
 		if c.connections[i] != nil {
 			c.connections[i].Close()
 		}
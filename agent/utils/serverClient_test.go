@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCompressionRejected(t *testing.T) {
+	rejected := func(encoding string) error {
+		return status.Errorf(codes.Unimplemented, "grpc: Decompressor is not installed for grpc-encoding %q", encoding)
+	}
+
+	tests := []struct {
+		name        string
+		compression string
+		err         error
+		retry       bool
+		want        string
+	}{
+		{name: "zstd falls back to gzip", compression: "zstd", err: rejected("zstd"), retry: true, want: "gzip"},
+		{name: "gzip falls back to none", compression: "gzip", err: rejected("gzip"), retry: true, want: "none"},
+		{name: "already fell back", compression: "gzip", err: rejected("zstd"), retry: true, want: "gzip"},
+		{name: "already fell back twice", compression: "none", err: rejected("zstd"), retry: true, want: "none"},
+		{name: "server compression unknown to the agent", compression: "zstd", err: rejected("snappy"), want: "zstd"},
+		{name: "other unimplemented error", compression: "zstd", err: status.Error(codes.Unimplemented, "unknown service"), want: "zstd"},
+		{name: "other status", compression: "zstd", err: status.Error(codes.Unavailable, "connection refused"), want: "zstd"},
+		{name: "not a status", compression: "zstd", err: errors.New("EOF"), want: "zstd"},
+		{name: "no error", compression: "zstd", want: "zstd"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &MonitorClient{compression: tt.compression}
+			if retry := client.compressionRejected(tt.err); retry != tt.retry {
+				t.Errorf("compressionRejected() = %v, want %v", retry, tt.retry)
+			}
+			if client.compression != tt.want {
+				t.Errorf("compression = %q, want %q", client.compression, tt.want)
+			}
+		})
+	}
+}
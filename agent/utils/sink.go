@@ -0,0 +1,260 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	pb "github.com/nox/noxflow/agent/pkg/proto"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Output names accepted by NewSink
+const (
+	OutputGRPC    = "grpc"
+	OutputBackend = "backend"
+	OutputStdout  = "stdout"
+	OutputFile    = "file"
+)
+
+// Sink is where the agent ships the collected logs, usage stats and container events
+type Sink interface {
+	SendLog(metadata *pb.ContainerLogMetadata, logLine string) error
+	// SendUsageStats sends the usage stats of a container, metadata names the container
+	SendUsageStats(metadata *ContainerMetadata, stats *pb.ContainerUsageStats) error
+	SendEvent(event *pb.ContainerEvent) error
+	Close() error
+}
+
+// QueuedSink is a sink that queues the logs and sends them in the background, SendLog
+// succeeding doesn't mean the log was sent
+type QueuedSink interface {
+	// LogProgress returns how many logs SendLog accepted and how many of them were sent,
+	// or dropped when the queue was full. Both only increase.
+	LogProgress() (accepted, done uint64)
+}
+
+// queuedSinks returns the sinks of sink that send the logs in the background
+func queuedSinks(sink Sink) []QueuedSink {
+	var queued []QueuedSink
+	if multi, ok := sink.(MultiSink); ok {
+		for _, sink := range multi {
+			queued = append(queued, queuedSinks(sink)...)
+		}
+	} else if sink, ok := sink.(QueuedSink); ok {
+		queued = append(queued, sink)
+	}
+	return queued
+}
+
+// GRPCSink ships the data to the NoxFlow gRPC server
+type GRPCSink struct {
+	Client *MonitorClient
+}
+
+func (s *GRPCSink) SendLog(metadata *pb.ContainerLogMetadata, logLine string) error {
+	_, err := s.Client.SendLog(metadata, logLine)
+	return err
+}
+
+func (s *GRPCSink) SendUsageStats(metadata *ContainerMetadata, stats *pb.ContainerUsageStats) error {
+	_, err := s.Client.SendUsageStats(stats)
+	return err
+}
+
+func (s *GRPCSink) SendEvent(event *pb.ContainerEvent) error {
+	_, err := s.Client.SendEvent(event)
+	return err
+}
+
+func (s *GRPCSink) Close() error {
+	return s.Client.Close()
+}
+
+func (s *GRPCSink) CheckHealth(ctx context.Context) error {
+	return s.Client.CheckHealth(ctx)
+}
+
+// sinkRecord is a line written by the JSON sinks
+type sinkRecord struct {
+	Type      string    `json:"type"`
+	Timestamp time.Time `json:"timestamp"`
+	// ContainerName is set on usage records, the stats only carry the container ID
+	ContainerName string `json:"container_name,omitempty"`
+	// Log, Usage and Event are the protobuf messages encoded by protoJSON
+	Log   json.RawMessage `json:"log,omitempty"`
+	Usage json.RawMessage `json:"usage,omitempty"`
+	Event json.RawMessage `json:"event,omitempty"`
+}
+
+// protoJSON encodes the protobuf messages of the records, with the field names of the
+// .proto file
+var protoJSON = protojson.MarshalOptions{UseProtoNames: true}
+
+// JSONSink writes the data as JSON lines, to stdout or a file
+type JSONSink struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+	closer  io.Closer
+}
+
+// NewJSONSink creates a sink writing to w, closer is closed with the sink when set
+func NewJSONSink(w io.Writer, closer io.Closer) *JSONSink {
+	return &JSONSink{encoder: json.NewEncoder(w), closer: closer}
+}
+
+// NewFileSink creates a sink appending to the file
+func NewFileSink(path string) (*JSONSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open output file: %v", err)
+	}
+	return NewJSONSink(f, f), nil
+}
+
+func (s *JSONSink) write(record *sinkRecord) error {
+	record.Timestamp = time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.encoder.Encode(record)
+}
+
+// marshal encodes a protobuf message for a record
+func marshal(message proto.Message) (json.RawMessage, error) {
+	data, err := protoJSON.Marshal(message)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %v", message.ProtoReflect().Descriptor().Name(), err)
+	}
+	return data, nil
+}
+
+func (s *JSONSink) SendLog(metadata *pb.ContainerLogMetadata, logLine string) error {
+	data, err := marshal(&pb.LogData{Metadata: metadata, Log: logLine})
+	if err != nil {
+		return err
+	}
+	return s.write(&sinkRecord{Type: "log", Log: data})
+}
+
+func (s *JSONSink) SendUsageStats(metadata *ContainerMetadata, stats *pb.ContainerUsageStats) error {
+	data, err := marshal(stats)
+	if err != nil {
+		return err
+	}
+	return s.write(&sinkRecord{Type: "usage", ContainerName: metadata.ContainerName, Usage: data})
+}
+
+func (s *JSONSink) SendEvent(event *pb.ContainerEvent) error {
+	data, err := marshal(event)
+	if err != nil {
+		return err
+	}
+	return s.write(&sinkRecord{Type: "event", Event: data})
+}
+
+func (s *JSONSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+// MultiSink fans the data out to several sinks, a failing sink doesn't stop the others
+type MultiSink []Sink
+
+func (m MultiSink) SendLog(metadata *pb.ContainerLogMetadata, logLine string) error {
+	var errs []error
+	for _, sink := range m {
+		errs = append(errs, sink.SendLog(metadata, logLine))
+	}
+	return errors.Join(errs...)
+}
+
+func (m MultiSink) SendUsageStats(metadata *ContainerMetadata, stats *pb.ContainerUsageStats) error {
+	var errs []error
+	for _, sink := range m {
+		errs = append(errs, sink.SendUsageStats(metadata, stats))
+	}
+	return errors.Join(errs...)
+}
+
+func (m MultiSink) SendEvent(event *pb.ContainerEvent) error {
+	var errs []error
+	for _, sink := range m {
+		errs = append(errs, sink.SendEvent(event))
+	}
+	return errors.Join(errs...)
+}
+
+func (m MultiSink) Close() error {
+	var errs []error
+	for _, sink := range m {
+		errs = append(errs, sink.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// CheckHealth fails when one of the sinks that can check their health is failing
+func (m MultiSink) CheckHealth(ctx context.Context) error {
+	var errs []error
+	for _, sink := range m {
+		if checker, ok := sink.(HealthChecker); ok {
+			errs = append(errs, checker.CheckHealth(ctx))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// NewSink creates the sink for the configured outputs. The gRPC client is returned too
+// when the gRPC server is one of the outputs, it is nil otherwise.
+func NewSink(cfg *Config) (Sink, *MonitorClient, error) {
+	var sinks MultiSink
+	var monitorClient *MonitorClient
+
+	for _, output := range cfg.Outputs {
+		var sink Sink
+		switch output {
+		case OutputGRPC:
+			client, err := NewMonitorClient(cfg.ServerAddr, cfg.NumConnections, cfg.Compression)
+			if err != nil {
+				sinks.Close()
+				return nil, nil, err
+			}
+			monitorClient = client
+			sink = &GRPCSink{Client: client}
+		case OutputBackend:
+			sink = NewBackendClient(cfg.BackendURL, cfg.BackendBatchSize, cfg.BackendFlushInterval)
+		case OutputStdout:
+			sink = NewJSONSink(os.Stdout, nil)
+		case OutputFile:
+			if cfg.OutputFile == "" {
+				sinks.Close()
+				return nil, nil, fmt.Errorf("the file output needs -output-file")
+			}
+			fileSink, err := NewFileSink(cfg.OutputFile)
+			if err != nil {
+				sinks.Close()
+				return nil, nil, err
+			}
+			sink = fileSink
+		default:
+			sinks.Close()
+			return nil, nil, fmt.Errorf("unknown output %q: expected grpc, backend, stdout or file", output)
+		}
+		sinks = append(sinks, sink)
+	}
+
+	switch len(sinks) {
+	case 0:
+		return nil, nil, fmt.Errorf("no output configured")
+	case 1:
+		return sinks[0], monitorClient, nil
+	}
+	return sinks, monitorClient, nil
+}
@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	pb "github.com/nox/noxflow/agent/pkg/proto"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestJSONSinkEncodesProtobufMessages(t *testing.T) {
+	var out bytes.Buffer
+	sink := NewJSONSink(&out, nil)
+
+	metadata := &pb.ContainerLogMetadata{ContainerId: "0123456789ab", ContainerName: "/api", Image: "nginx:1.25"}
+	stats := &pb.ContainerUsageStats{ContainerId: "0123456789ab", Timestamp: 1714564800000, CpuPercent: 12.5, MemoryUsage: 1 << 60}
+	event := &pb.ContainerEvent{ContainerId: "0123456789ab", Action: "die", ExitCode: 137, Attributes: map[string]string{"signal": "9"}}
+
+	if err := sink.SendLog(metadata, "GET / 200"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.SendUsageStats(&ContainerMetadata{ContainerName: "/api"}, stats); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.SendEvent(event); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("wrote %d lines, want one per record:\n%s", len(lines), out.String())
+	}
+
+	want := []struct {
+		kind    string
+		field   string
+		message proto.Message
+		decoded proto.Message
+	}{
+		{kind: "log", field: "log", message: &pb.LogData{Metadata: metadata, Log: "GET / 200"}, decoded: &pb.LogData{}},
+		{kind: "usage", field: "usage", message: stats, decoded: &pb.ContainerUsageStats{}},
+		{kind: "event", field: "event", message: event, decoded: &pb.ContainerEvent{}},
+	}
+	for i, line := range lines {
+		var record map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("line %d is not JSON: %v", i, err)
+		}
+		var kind string
+		json.Unmarshal(record["type"], &kind)
+		if kind != want[i].kind {
+			t.Errorf("line %d has type %q, want %q", i, kind, want[i].kind)
+		}
+
+		// The messages read back with protojson, with the field names of the .proto file
+		if err := protojson.Unmarshal(record[want[i].field], want[i].decoded); err != nil {
+			t.Fatalf("line %d: %s is not protobuf JSON: %v", i, want[i].field, err)
+		}
+		if !proto.Equal(want[i].decoded, want[i].message) {
+			t.Errorf("line %d: decoded %v, want %v", i, want[i].decoded, want[i].message)
+		}
+	}
+
+	for _, field := range []string{`"container_id"`, `"memory_usage":"1152921504606846976"`, `"exit_code":137`} {
+		if !strings.Contains(out.String(), field) {
+			t.Errorf("output has no %s:\n%s", field, out.String())
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	pb "github.com/nox/noxflow/agent/pkg/proto"
+	"github.com/nox/noxflow/agent/utils"
+)
+
+// containerActions are the container lifecycle events shipped to the server
+var containerActions = []events.Action{
+	events.ActionCreate,
+	events.ActionStart,
+	events.ActionRestart,
+	events.ActionStop,
+	events.ActionKill,
+	events.ActionDie,
+	events.ActionOOM,
+	events.ActionDestroy,
+	events.ActionHealthStatus,
+	events.ActionPause,
+	events.ActionUnPause,
+}
+
+// eventAttributes are the attributes Docker adds to container events next to the labels
+var eventAttributes = map[string]bool{
+	"name":         true,
+	"image":        true,
+	"exitCode":     true,
+	"execDuration": true,
+	"signal":       true,
+}
+
+// GetDockerContainerEvents streams container lifecycle events from Docker and passes them to
+// the handler until the context is cancelled. The event stream is resumed after the last
+// received event when it fails, so no events are missed or handled twice.
+func GetDockerContainerEvents(ctx context.Context, handle func(event *pb.ContainerEvent), wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	filterArgs := filters.NewArgs(filters.Arg("type", string(events.ContainerEventType)))
+	for _, action := range containerActions {
+		filterArgs.Add("event", string(action))
+	}
+
+	since := ""
+	var lastTimeNano int64
+	backoff := initialBackoff
+	for {
+		messages, errs := utils.DockerClient.Events(ctx, events.ListOptions{
+			Since:   since,
+			Filters: filterArgs,
+		})
+
+	stream:
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case err := <-errs:
+				log.Printf("Error streaming Docker events, retrying in %v: %v", backoff, err)
+				break stream
+			case message := <-messages:
+				backoff = initialBackoff
+				// Docker includes the events at since, the event already handled is skipped
+				if message.TimeNano <= lastTimeNano {
+					continue
+				}
+				lastTimeNano = message.TimeNano
+				since = formatEventTime(message.TimeNano)
+
+				handle(toProtoEvent(&message))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// toProtoEvent converts a Docker event into the gRPC message
+func toProtoEvent(message *events.Message) *pb.ContainerEvent {
+	event := &pb.ContainerEvent{
+		ContainerId:   message.Actor.ID,
+		ContainerName: message.Actor.Attributes["name"],
+		Image:         message.Actor.Attributes["image"],
+		Action:        string(message.Action),
+		Timestamp:     message.TimeNano,
+		Attributes:    message.Actor.Attributes,
+	}
+
+	// Health status events carry the status in the action, e.g. "health_status: healthy"
+	if action, status, found := strings.Cut(event.Action, ":"); found {
+		event.Action = action
+		event.HealthStatus = strings.TrimSpace(status)
+	}
+
+	if exitCode, ok := message.Actor.Attributes["exitCode"]; ok {
+		if code, err := strconv.Atoi(exitCode); err == nil {
+			event.ExitCode = int32(code)
+		}
+	}
+
+	return event
+}
+
+// eventLabels returns the container labels of the event attributes
+func eventLabels(attributes map[string]string) map[string]string {
+	labels := make(map[string]string, len(attributes))
+	for key, value := range attributes {
+		if !eventAttributes[key] {
+			labels[key] = value
+		}
+	}
+	return labels
+}
+
+// formatEventTime formats an event time for the Since option, in seconds with the
+// nanoseconds padded to 9 digits
+func formatEventTime(timeNano int64) string {
+	return fmt.Sprintf("%d.%09d", timeNano/int64(time.Second), timeNano%int64(time.Second))
+}
@@ -0,0 +1,44 @@
+package docker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFormatEventTime(t *testing.T) {
+	tests := []struct {
+		timeNano int64
+		want     string
+	}{
+		{timeNano: 1714564800000000000, want: "1714564800.000000000"},
+		{timeNano: 1714564800000000042, want: "1714564800.000000042"},
+		{timeNano: 1714564800050000000, want: "1714564800.050000000"},
+		{timeNano: 1714564800999999999, want: "1714564800.999999999"},
+	}
+
+	for _, tt := range tests {
+		if got := formatEventTime(tt.timeNano); got != tt.want {
+			t.Errorf("formatEventTime(%d) = %q, want %q", tt.timeNano, got, tt.want)
+		}
+	}
+}
+
+func TestEventLabels(t *testing.T) {
+	attributes := map[string]string{
+		"name":           "api",
+		"image":          "nginx:1.25",
+		"exitCode":       "137",
+		"execDuration":   "12",
+		"signal":         "9",
+		"app":            "shop",
+		"noxflow.enable": "false",
+	}
+	want := map[string]string{
+		"app":            "shop",
+		"noxflow.enable": "false",
+	}
+
+	if got := eventLabels(attributes); !reflect.DeepEqual(got, want) {
+		t.Errorf("eventLabels() = %v, want %v", got, want)
+	}
+}
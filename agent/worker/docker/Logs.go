@@ -2,27 +2,104 @@ package docker
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"fmt"
+	"io"
 	"log"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
 	pb "github.com/nox/noxflow/agent/pkg/proto"
 	"github.com/nox/noxflow/agent/utils"
 )
 
+const (
+	initialBackoff = time.Second
+	maxBackoff     = time.Minute
+)
+
+// maxLogLineSize is the longest log line shipped, longer lines are truncated so a single
+// line can't stop the log stream
+const maxLogLineSize = 256 * 1024
+
+// truncatedSuffix marks a log line cut at maxLogLineSize
+const truncatedSuffix = " [truncated]"
+
 // GetDockerContainerLogs streams logs from a Docker container and sends them to the server
-func GetDockerContainerLogs(containerID string, monitorClient *utils.MonitorClient, wg *sync.WaitGroup) {
+// until ctx is done. The log stream is restarted with an exponential backoff when it fails,
+// resuming from the last line that was read, and starts from the container checkpoint so
+// the lines written while the agent was down are shipped. Sensitive data is removed with
+// the redactor, when given, before the lines leave the host, and the limiter, when given,
+// rate limits and samples the lines.
+func GetDockerContainerLogs(ctx context.Context, containerID string, sink utils.Sink, redactor *utils.Redactor, limiter *utils.LogLimiter, checkpoints *utils.Checkpoints, status *utils.CollectorStatus, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	since := checkpoints.Get(containerID)
+	if since != "" {
+		log.Printf("Resuming logs of container %s from %s", containerID, since)
+	}
+	backoff := initialBackoff
+	for {
+		status.SetState(utils.CollectorRunning)
+		lastTimestamp, err := streamContainerLogs(ctx, containerID, since, sink, redactor, limiter, checkpoints, status)
+		if lastTimestamp != "" {
+			since = lastTimestamp
+			backoff = initialBackoff
+		}
+
+		if ctx.Err() != nil {
+			log.Printf("Stopped log collection for container %s", containerID)
+			status.SetState(utils.CollectorStopped)
+			return
+		}
+
+		if err != nil && errdefs.IsNotFound(err) {
+			log.Printf("Container %s no longer exists, stopping log collection", containerID)
+			checkpoints.Delete(containerID)
+			status.Fail(err)
+			return
+		}
+
+		if err == nil {
+			// The log stream ends when the container stops
+			running, inspectErr := isContainerRunning(ctx, containerID)
+			if inspectErr != nil {
+				err = inspectErr
+			} else if !running {
+				log.Printf("Container %s is not running, stopping log collection", containerID)
+				status.SetState(utils.CollectorStopped)
+				return
+			} else {
+				err = fmt.Errorf("log stream closed while the container is running")
+			}
+		}
+
+		log.Printf("Error streaming logs for container %s, retrying in %v: %v", containerID, backoff, err)
+		status.RecordRestart(err)
+		select {
+		case <-ctx.Done():
+			status.SetState(utils.CollectorStopped)
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
 
+// streamContainerLogs ships the container logs until the stream ends. It returns the
+// Docker timestamp of the last line handled so a restarted stream can resume from there,
+// and records it as the container checkpoint. Once a line fails to be sent the position
+// stays before it, so the lines from there are shipped again rather than lost.
+func streamContainerLogs(ctx context.Context, containerID, since string, sink utils.Sink, redactor *utils.Redactor, limiter *utils.LogLimiter, checkpoints *utils.Checkpoints, status *utils.CollectorStatus) (string, error) {
 	containerInfo, err := utils.DockerClient.ContainerInspect(ctx, containerID)
 	if err != nil {
-		log.Printf("Error inspecting container %s: %v", containerID, err)
-		return
+		return "", err
 	}
+	status.SetContainerName(containerInfo.Name)
 
 	// Construct the metadata for the logs
 	metadata := &pb.ContainerLogMetadata{
@@ -34,34 +111,169 @@ func GetDockerContainerLogs(containerID string, monitorClient *utils.MonitorClie
 		LogDriver:     containerInfo.HostConfig.LogConfig.Type,
 	}
 
-	reader, err := utils.DockerClient.ContainerLogs(ctx, containerID, container.LogsOptions{
+	options := container.LogsOptions{
 		ShowStdout: true,
 		ShowStderr: true,
 		Follow:     true,
 		Timestamps: true,
 		Details:    true,
 		Tail:       "0",
-	})
+	}
+	// Docker includes the lines written at since, the line already handled is skipped
+	var resumeAfter time.Time
+	if since != "" {
+		options.Since = since
+		options.Tail = ""
+		resumeAfter, _ = time.Parse(time.RFC3339Nano, since)
+	}
 
+	reader, err := utils.DockerClient.ContainerLogs(ctx, containerID, options)
 	if err != nil {
-		log.Printf("Error retrieving logs for container %s: %v", containerID, err)
-		return
+		return "", err
 	}
 	defer reader.Close()
 
-	// Use a scanner to read logs line by line
-	scanner := bufio.NewScanner(reader)
-	for scanner.Scan() {
-		logLine := scanner.Text()
+	// Read the logs line by line
+	lastTimestamp := ""
+	sendFailed := false
+	lines := bufio.NewReader(reader)
+	for {
+		logLine, readErr := readLogLine(lines)
+		if readErr != nil {
+			if readErr != io.EOF {
+				err = readErr
+			}
+			break
+		}
+
+		timestamp, written := parseLogTimestamp(logLine)
+		if !resumeAfter.IsZero() && !written.IsZero() && !written.After(resumeAfter) {
+			continue
+		}
+
+		var lag time.Duration
+		if !written.IsZero() {
+			lag = time.Since(written)
+		}
+		if !shipLogLine(sink, metadata, logLine, lag, redactor, limiter, status) {
+			sendFailed = true
+		}
+
+		if timestamp != "" && !sendFailed {
+			lastTimestamp = timestamp
+			checkpoints.Set(containerID, timestamp)
+		}
+	}
+
+	if limiter != nil {
+		sendLimiterSummary(sink, metadata, limiter)
+	}
+
+	return lastTimestamp, err
+}
+
+// readLogLine reads the next line without its line ending. Lines longer than
+// maxLogLineSize are truncated, the rest of the line is read and discarded.
+func readLogLine(reader *bufio.Reader) (string, error) {
+	var line []byte
+	truncated := false
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if keep := maxLogLineSize - len(line); len(chunk) > keep {
+			line = append(line, chunk[:keep]...)
+			truncated = true
+		} else {
+			line = append(line, chunk...)
+		}
+
+		switch {
+		case err == bufio.ErrBufferFull:
+			continue
+		case err == io.EOF && len(line) > 0:
+			// The last line of the stream has no line ending
+		case err != nil:
+			return "", err
+		}
+		break
+	}
+
+	if truncated {
+		return strings.ToValidUTF8(string(line), "") + truncatedSuffix, nil
+	}
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	return string(bytes.TrimSuffix(line, []byte("\r"))), nil
+}
+
+// shipLogLine rate limits, redacts and sends a log line, lag is how long ago it was written.
+// It reports false when the line couldn't be sent, dropping it on purpose handles it.
+func shipLogLine(sink utils.Sink, metadata *pb.ContainerLogMetadata, logLine string, lag time.Duration, redactor *utils.Redactor, limiter *utils.LogLimiter, status *utils.CollectorStatus) bool {
+	// Rate limit and sample the lines, the dropped lines are reported with a
+	// summary line before the next line shipped
+	if limiter != nil {
+		if decision := limiter.Allow(logLine); decision != utils.LogKeep {
+			status.RecordLimited(decision)
+			return true
+		}
+		sendLimiterSummary(sink, metadata, limiter)
+	}
 
-		// Send the log line to the server
-		_, err := monitorClient.SendLog(metadata, logLine)
-		if err != nil {
-			log.Printf("Error sending log for container %s: %v", containerID, err)
+	// Redact sensitive data before the line leaves the host
+	if redactor != nil {
+		redacted, count, keep := redactor.Redact(logLine)
+		if count > 0 {
+			status.RecordRedactions(count, !keep)
+		}
+		if !keep {
+			return true
 		}
+		logLine = redacted
 	}
 
-	if err := scanner.Err(); err != nil {
-		log.Printf("Error reading logs for container %s: %v", containerID, err)
+	// Send the log line to the server
+	if err := sink.SendLog(metadata, logLine); err != nil {
+		log.Printf("Error sending log for container %s: %v", metadata.ContainerId, err)
+		status.RecordSendError(err)
+		return false
+	}
+	status.RecordSent(len(logLine), lag)
+	return true
+}
+
+// sendLimiterSummary sends the summary of the lines dropped by the rate limit, if any
+func sendLimiterSummary(sink utils.Sink, metadata *pb.ContainerLogMetadata, limiter *utils.LogLimiter) {
+	summary := limiter.Summary()
+	if summary == "" {
+		return
+	}
+	if err := sink.SendLog(metadata, summary); err != nil {
+		log.Printf("Error sending rate limit summary for container %s: %v", metadata.ContainerId, err)
+	}
+}
+
+// parseLogTimestamp extracts the timestamp Docker prepends to every log line, as written
+// and parsed. Lines of non-TTY containers start with an 8 byte stream header.
+func parseLogTimestamp(logLine string) (string, time.Time) {
+	if len(logLine) >= 8 && logLine[0] <= 2 && logLine[1] == 0 && logLine[2] == 0 && logLine[3] == 0 {
+		logLine = logLine[8:]
+	}
+
+	timestamp, _, found := strings.Cut(logLine, " ")
+	if !found {
+		return "", time.Time{}
+	}
+
+	parsed, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return "", time.Time{}
+	}
+	return timestamp, parsed
+}
+
+// isContainerRunning reports whether the container is currently running
+func isContainerRunning(ctx context.Context, containerID string) (bool, error) {
+	containerInfo, err := utils.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return false, err
 	}
+	return containerInfo.State.Running, nil
 }
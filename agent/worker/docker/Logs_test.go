@@ -0,0 +1,111 @@
+package docker
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	pb "github.com/nox/noxflow/agent/pkg/proto"
+	"github.com/nox/noxflow/agent/utils"
+)
+
+func TestReadLogLine(t *testing.T) {
+	long := "2024-11-05T10:16:04.101284113Z " + strings.Repeat("x", 300*1024)
+
+	tests := []struct {
+		name   string
+		stream string
+		want   []string
+	}{
+		{
+			name:   "lines",
+			stream: "first\nsecond\r\nthird\n",
+			want:   []string{"first", "second", "third"},
+		},
+		{
+			name:   "last line without a line ending",
+			stream: "first\nlast",
+			want:   []string{"first", "last"},
+		},
+		{
+			name:   "empty line",
+			stream: "first\n\nthird\n",
+			want:   []string{"first", "", "third"},
+		},
+		{
+			name:   "line over the limit is truncated and the stream goes on",
+			stream: long + "\nnext\n",
+			want:   []string{long[:maxLogLineSize] + truncatedSuffix, "next"},
+		},
+		{
+			name:   "truncation doesn't split a character",
+			stream: strings.Repeat("x", maxLogLineSize-1) + "é\nnext\n",
+			want:   []string{strings.Repeat("x", maxLogLineSize-1) + truncatedSuffix, "next"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := bufio.NewReader(strings.NewReader(tt.stream))
+			var got []string
+			for {
+				line, err := readLogLine(reader)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("readLogLine() error = %v", err)
+				}
+				got = append(got, line)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("read %d lines, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("line %d has %d bytes, want %d", i, len(got[i]), len(tt.want[i]))
+				}
+			}
+		})
+	}
+}
+
+// failingSink fails to send the logs
+type failingSink struct {
+	utils.MultiSink
+}
+
+func (failingSink) SendLog(metadata *pb.ContainerLogMetadata, logLine string) error {
+	return errors.New("unavailable")
+}
+
+func TestShipLogLine(t *testing.T) {
+	metadata := &pb.ContainerLogMetadata{ContainerId: "web"}
+	dropCards, err := utils.NewRedactor([]string{"credit_card"}, nil, utils.RedactDrop, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		sink     utils.Sink
+		redactor *utils.Redactor
+		line     string
+		want     bool
+	}{
+		{name: "sent", sink: utils.MultiSink{}, line: "GET /", want: true},
+		{name: "send failed", sink: failingSink{}, line: "GET /"},
+		{name: "dropped by the redaction", sink: failingSink{}, redactor: dropCards, line: "card 4111 1111 1111 1111", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := utils.NewStatusRegistry().Collector("web", utils.CollectorLogs)
+			if got := shipLogLine(tt.sink, metadata, tt.line, 0, tt.redactor, nil, status); got != tt.want {
+				t.Errorf("shipLogLine() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
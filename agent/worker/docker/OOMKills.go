@@ -0,0 +1,57 @@
+package docker
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nox/noxflow/agent/utils"
+)
+
+// oomKillFiles are the cgroup files counting the OOM kills of a container, relative to
+// the cgroup root: memory.events on cgroup v2 and memory.oom_control on cgroup v1, with
+// the systemd and the cgroupfs cgroup drivers. The Docker stats API doesn't report them.
+var oomKillFiles = []string{
+	"system.slice/docker-%s.scope/memory.events",
+	"docker/%s/memory.events",
+	"memory/system.slice/docker-%s.scope/memory.oom_control",
+	"memory/docker/%s/memory.oom_control",
+}
+
+// readOOMKills returns the number of processes of the container killed by the OOM
+// killer since the container started. It reports false when the cgroup of the container
+// isn't found under utils.CgroupRoot.
+func readOOMKills(containerID string) (uint64, bool) {
+	for _, file := range oomKillFiles {
+		path := filepath.Join(utils.CgroupRoot, strings.ReplaceAll(file, "%s", containerID))
+		if kills, ok := readCgroupCounter(path, "oom_kill"); ok {
+			return kills, true
+		}
+	}
+	return 0, false
+}
+
+// readCgroupCounter reads a counter of a cgroup file made of "<key> <value>" lines
+func readCgroupCounter(path, key string) (uint64, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, value, found := strings.Cut(scanner.Text(), " ")
+		if !found || name != key {
+			continue
+		}
+		counter, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		return counter, true
+	}
+	return 0, false
+}
@@ -0,0 +1,77 @@
+package docker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nox/noxflow/agent/utils"
+)
+
+func TestReadOOMKills(t *testing.T) {
+	const containerID = "0123456789ab"
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    uint64
+		found   bool
+	}{
+		{
+			name:    "cgroup v2 systemd driver",
+			file:    "system.slice/docker-0123456789ab.scope/memory.events",
+			content: "low 0\nhigh 0\nmax 12\noom 3\noom_kill 2\noom_group_kill 0\n",
+			want:    2,
+			found:   true,
+		},
+		{
+			name:    "cgroup v2 cgroupfs driver",
+			file:    "docker/0123456789ab/memory.events",
+			content: "low 0\nhigh 0\nmax 0\noom 0\noom_kill 0\n",
+			want:    0,
+			found:   true,
+		},
+		{
+			name:    "cgroup v1",
+			file:    "memory/docker/0123456789ab/memory.oom_control",
+			content: "oom_kill_disable 0\nunder_oom 0\noom_kill 5\n",
+			want:    5,
+			found:   true,
+		},
+		{
+			name:    "cgroup v1 kernel without the counter",
+			file:    "memory/docker/0123456789ab/memory.oom_control",
+			content: "oom_kill_disable 0\nunder_oom 0\n",
+			found:   false,
+		},
+		{
+			name:  "cgroup not mounted",
+			found: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			if tt.file != "" {
+				path := filepath.Join(root, tt.file)
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			previous := utils.CgroupRoot
+			utils.CgroupRoot = root
+			defer func() { utils.CgroupRoot = previous }()
+
+			got, found := readOOMKills(containerID)
+			if got != tt.want || found != tt.found {
+				t.Errorf("readOOMKills() = %d, %v, want %d, %v", got, found, tt.want, tt.found)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package docker
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+
+	pb "github.com/nox/noxflow/agent/pkg/proto"
+	"github.com/nox/noxflow/agent/utils"
+)
+
+// Supervisor starts the collectors of the containers that pass the filter, both for the
+// containers running when the agent starts and the ones started later on
+type Supervisor struct {
+	// ctx is the lifetime of the collectors, they stop when it is done
+	ctx         context.Context
+	checkpoints *utils.Checkpoints
+	sink        utils.Sink
+	exporter    *utils.MetricsExporter
+	redactor    *utils.Redactor
+	limiter     *utils.LogLimiter
+	registry    *utils.StatusRegistry
+	filter      *utils.ContainerFilter
+	defaults    utils.CollectionSettings
+	wg          *sync.WaitGroup
+
+	mu sync.Mutex
+	// running holds the collectors currently running, keyed by kind and container ID
+	running map[string]bool
+}
+
+// NewSupervisor creates a supervisor, the collectors it starts are added to wg and run
+// until ctx is done
+func NewSupervisor(ctx context.Context, checkpoints *utils.Checkpoints, sink utils.Sink, exporter *utils.MetricsExporter, redactor *utils.Redactor, limiter *utils.LogLimiter, registry *utils.StatusRegistry, filter *utils.ContainerFilter, defaults utils.CollectionSettings, wg *sync.WaitGroup) *Supervisor {
+	return &Supervisor{
+		ctx:         ctx,
+		checkpoints: checkpoints,
+		sink:        sink,
+		exporter:    exporter,
+		redactor:    redactor,
+		limiter:     limiter,
+		registry:    registry,
+		filter:      filter,
+		defaults:    defaults,
+		wg:          wg,
+		running:     make(map[string]bool),
+	}
+}
+
+// WatchRunning starts the collectors of every running container
+func (s *Supervisor) WatchRunning(ctx context.Context) error {
+	dockerContainers, err := utils.DockerListContainers(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, container := range dockerContainers {
+		if container.State != "running" {
+			continue
+		}
+		s.Watch(&utils.ContainerMetadata{
+			ContainerID:   container.ID,
+			ContainerName: containerName(container.Names),
+			Image:         container.Image,
+			Labels:        container.Labels,
+		})
+	}
+	return nil
+}
+
+// Watch starts the collectors of a running container unless it is filtered out or
+// they are already running
+func (s *Supervisor) Watch(container *utils.ContainerMetadata) {
+	if !s.filter.Allows(container) {
+		log.Printf("Skipping container %s (%s): excluded by filter", container.ContainerID, container.ContainerName)
+		return
+	}
+
+	settings := utils.ResolveSettings(s.defaults, container.Labels)
+	if settings.Logs {
+		redactor := s.redactor.ForContainer(container.Labels)
+		limiter := s.limiter.ForContainer(container.Labels)
+		s.start(container.ContainerID, utils.CollectorLogs, func(status *utils.CollectorStatus) {
+			GetDockerContainerLogs(s.ctx, container.ContainerID, s.sink, redactor, limiter, s.checkpoints, status, s.wg)
+		})
+	}
+	if settings.Usage {
+		s.start(container.ContainerID, utils.CollectorUsage, func(status *utils.CollectorStatus) {
+			GetDockerContainerUsage(s.ctx, container.ContainerID, s.sink, s.exporter, status, s.wg, true)
+		})
+	}
+}
+
+// start runs a collector in the background unless one of the same kind is already
+// running for the container or the agent is shutting down
+func (s *Supervisor) start(containerID, kind string, collect func(status *utils.CollectorStatus)) {
+	key := kind + "/" + containerID
+
+	s.mu.Lock()
+	if s.running[key] || s.ctx.Err() != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.running[key] = true
+	s.mu.Unlock()
+
+	log.Printf("Starting %s collection for container %s", kind, containerID)
+	s.wg.Add(1)
+	go func() {
+		collect(s.registry.Collector(containerID, kind))
+
+		s.mu.Lock()
+		delete(s.running, key)
+		s.mu.Unlock()
+	}()
+}
+
+// HandleEvent ships a container event to the server, starts collecting from containers
+// that were just started and forgets the collector statuses of removed containers
+func (s *Supervisor) HandleEvent(event *pb.ContainerEvent) {
+	// Docker adds the container labels to the event attributes, along with its own
+	container := &utils.ContainerMetadata{
+		ContainerID:   event.ContainerId,
+		ContainerName: event.ContainerName,
+		Image:         event.Image,
+		Labels:        eventLabels(event.Attributes),
+	}
+	if event.Action == "destroy" {
+		defer s.registry.Remove(event.ContainerId)
+	}
+	if !s.filter.Allows(container) {
+		s.forget(event.ContainerId)
+		return
+	}
+
+	if utils.ResolveSettings(s.defaults, container.Labels).Events {
+		if err := s.sink.SendEvent(event); err != nil {
+			log.Printf("Error sending %s event for container %s: %v", event.Action, event.ContainerId, err)
+		}
+	}
+
+	if event.Action == "start" {
+		s.Watch(container)
+	}
+}
+
+// forget drops the collector statuses of a container no longer collected, unless one of
+// its collectors is still running
+func (s *Supervisor) forget(containerID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, kind := range []string{utils.CollectorLogs, utils.CollectorUsage} {
+		if s.running[kind+"/"+containerID] {
+			return
+		}
+	}
+	s.registry.Remove(containerID)
+}
+
+// containerName returns the primary name of a listed container
+func containerName(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	return strings.TrimPrefix(names[0], "/")
+}
@@ -5,11 +5,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+	pb "github.com/nox/noxflow/agent/pkg/proto"
 	"github.com/nox/noxflow/agent/utils"
+	"google.golang.org/protobuf/proto"
 )
 
 type ContainerUsageStats struct {
@@ -23,57 +28,145 @@ type ContainerUsageStats struct {
 	MemoryLimit    uint64  `json:"memory_limit"`
 	MemoryPercent  float64 `json:"memory_percent"`
 	MemoryCache    uint64  `json:"memory_cache"`
+	// Network usage per interface
+	Networks []NetworkInterfaceStats `json:"networks"`
+	// Block IO usage, summed across devices
+	BlkioReadBytes  uint64 `json:"blkio_read_bytes"`
+	BlkioWriteBytes uint64 `json:"blkio_write_bytes"`
+	BlkioReadOps    uint64 `json:"blkio_read_ops"`
+	BlkioWriteOps   uint64 `json:"blkio_write_ops"`
+	// Process counts
+	PidsCurrent uint64 `json:"pids_current"`
+	PidsLimit   uint64 `json:"pids_limit"`
+	// CPU throttling, throttled time is in nanoseconds
+	CPUThrottlingPeriods uint64 `json:"cpu_throttling_periods"`
+	CPUThrottledPeriods  uint64 `json:"cpu_throttled_periods"`
+	CPUThrottledTime     uint64 `json:"cpu_throttled_time"`
+	OOMKills             uint64 `json:"oom_kills"`
 }
 
-func GetDockerContainerUsage(containerID string, wg *sync.WaitGroup, stream bool) {
+type NetworkInterfaceStats struct {
+	Interface string `json:"interface"`
+	RxBytes   uint64 `json:"rx_bytes"`
+	RxPackets uint64 `json:"rx_packets"`
+	RxErrors  uint64 `json:"rx_errors"`
+	RxDropped uint64 `json:"rx_dropped"`
+	TxBytes   uint64 `json:"tx_bytes"`
+	TxPackets uint64 `json:"tx_packets"`
+	TxErrors  uint64 `json:"tx_errors"`
+	TxDropped uint64 `json:"tx_dropped"`
+}
+
+// GetDockerContainerUsage collects usage stats for a Docker container and sends them to the server
+// until ctx is done. When an exporter is given the stats are also exposed as Prometheus metrics.
+// In streaming mode the stats stream is restarted with an exponential backoff when it fails.
+func GetDockerContainerUsage(ctx context.Context, containerID string, sink utils.Sink, exporter *utils.MetricsExporter, status *utils.CollectorStatus, wg *sync.WaitGroup, stream bool) {
 	defer wg.Done()
 
 	log.Printf("Starting container stats collection for: %s", containerID)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	inspectCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
+	containerInfo, err := utils.ContainerInspect(inspectCtx, containerID)
+	if err != nil {
+		log.Printf("Error inspecting container %s: %v", containerID, err)
+		status.Fail(err)
+		return
+	}
+	status.SetContainerName(containerInfo.Name)
+	if _, ok := readOOMKills(containerID); !ok {
+		log.Printf("Cgroup of container %s not found under %s, its OOM kills aren't collected", containerID, utils.CgroupRoot)
+	}
+
+	metadata := &utils.ContainerMetadata{
+		ContainerID:   containerID,
+		ContainerName: containerInfo.Name,
+		Image:         containerInfo.Config.Image,
+		Labels:        containerInfo.Config.Labels,
+	}
+
 	if !stream {
 		// Get one-time stats
-		stats, err := getContainerStats(ctx, containerID)
+		stats, err := getContainerStats(inspectCtx, containerID)
 		if err != nil {
 			log.Printf("Error getting one-time stats: %v", err)
+			status.Fail(err)
 			return
 		}
-		processStats(stats)
+		processStats(stats, sink, exporter, status, metadata)
+		status.SetState(utils.CollectorStopped)
 		return
 	}
 
-	// Create new context for streaming
-	streamCtx, streamCancel := context.WithCancel(context.Background())
-	defer streamCancel()
+	if exporter != nil {
+		defer exporter.Remove(containerID)
+	}
+
+	backoff := initialBackoff
+	for {
+		status.SetState(utils.CollectorRunning)
+		received, err := streamContainerStats(ctx, containerID, sink, exporter, status, metadata)
+		if received {
+			backoff = initialBackoff
+		}
+
+		if ctx.Err() != nil {
+			log.Printf("Stopped stats collection for container %s", containerID)
+			status.SetState(utils.CollectorStopped)
+			return
+		}
 
+		if errdefs.IsNotFound(err) {
+			log.Printf("Container %s no longer exists, stopping stats collection", containerID)
+			status.Fail(err)
+			return
+		}
+
+		// The stats stream ends when the container stops
+		running, inspectErr := isContainerRunning(ctx, containerID)
+		if inspectErr == nil && !running {
+			log.Printf("Container %s is not running, stopping stats collection", containerID)
+			status.SetState(utils.CollectorStopped)
+			return
+		}
+
+		log.Printf("Error streaming stats for container %s, retrying in %v: %v", containerID, backoff, err)
+		status.RecordRestart(err)
+		select {
+		case <-ctx.Done():
+			status.SetState(utils.CollectorStopped)
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// streamContainerStats processes the stats stream until it fails. It reports whether any
+// stats were received so the caller can reset its backoff.
+func streamContainerStats(ctx context.Context, containerID string, sink utils.Sink, exporter *utils.MetricsExporter, status *utils.CollectorStatus, metadata *utils.ContainerMetadata) (bool, error) {
 	// Get streaming stats
-	containerStats, err := utils.ContainerStats(streamCtx, containerID, true)
+	containerStats, err := utils.ContainerStats(ctx, containerID, true)
 	if err != nil {
-		log.Printf("Error initializing stats stream for container %s: %v", containerID, err)
-		return
+		return false, fmt.Errorf("failed to initialize stats stream: %w", err)
 	}
 	defer containerStats.Body.Close()
 
 	decoder := json.NewDecoder(containerStats.Body)
 
 	// Start streaming loop
+	received := false
 	for {
-		select {
-		case <-streamCtx.Done():
-			log.Printf("Stopping stats stream for container %s: context cancelled", containerID)
-			return
-		default:
-			var statsJSON container.StatsResponse
-			if err := decoder.Decode(&statsJSON); err != nil {
-				log.Printf("Error decoding stats for container %s: %v", containerID, err)
-				return
-			}
-
-			stats := extractStats(containerID, &statsJSON)
-			processStats(stats)
+		var statsJSON container.StatsResponse
+		if err := decoder.Decode(&statsJSON); err != nil {
+			return received, fmt.Errorf("failed to decode stats: %w", err)
 		}
+		received = true
+
+		stats := extractStats(containerID, &statsJSON)
+		stats.OOMKills, _ = readOOMKills(containerID)
+		processStats(stats, sink, exporter, status, metadata)
 	}
 }
 
@@ -89,7 +182,9 @@ func getContainerStats(ctx context.Context, containerID string) (*ContainerUsage
 		return nil, fmt.Errorf("failed to decode stats JSON: %v", err)
 	}
 
-	return extractStats(containerID, &statsJSON), nil
+	stats := extractStats(containerID, &statsJSON)
+	stats.OOMKills, _ = readOOMKills(containerID)
+	return stats, nil
 }
 
 func extractStats(containerID string, statsJSON *container.StatsResponse) *ContainerUsageStats {
@@ -98,18 +193,54 @@ func extractStats(containerID string, statsJSON *container.StatsResponse) *Conta
 		Timestamp:      time.Now(),
 		CPUUsage:       statsJSON.CPUStats.CPUUsage.TotalUsage,
 		SystemCPUUsage: statsJSON.CPUStats.SystemUsage,
-		MemoryUsage:    statsJSON.MemoryStats.Usage,
+		MemoryUsage:    calculateMemoryUsage(&statsJSON.MemoryStats),
 		MemoryLimit:    statsJSON.MemoryStats.Limit,
-		MemoryCache:    statsJSON.MemoryStats.Stats["cache"],
+		MemoryCache:    calculateMemoryCache(&statsJSON.MemoryStats),
+		CPUPercent:     calculateCPUPercent(&statsJSON.CPUStats, &statsJSON.PreCPUStats),
+		PidsCurrent:    statsJSON.PidsStats.Current,
+		PidsLimit:      utils.PidsLimit(statsJSON.PidsStats.Limit),
+
+		CPUThrottlingPeriods: statsJSON.CPUStats.ThrottlingData.Periods,
+		CPUThrottledPeriods:  statsJSON.CPUStats.ThrottlingData.ThrottledPeriods,
+		CPUThrottledTime:     statsJSON.CPUStats.ThrottlingData.ThrottledTime,
 	}
 
-	// Calculate CPU percent
-	if len(statsJSON.CPUStats.CPUUsage.PercpuUsage) > 0 {
-		cpuDelta := float64(statsJSON.CPUStats.CPUUsage.TotalUsage - statsJSON.PreCPUStats.CPUUsage.TotalUsage)
-		systemDelta := float64(statsJSON.CPUStats.SystemUsage - statsJSON.PreCPUStats.SystemUsage)
+	// Collect network stats, sorted by interface name so the order is stable
+	interfaces := make([]string, 0, len(statsJSON.Networks))
+	for name := range statsJSON.Networks {
+		interfaces = append(interfaces, name)
+	}
+	sort.Strings(interfaces)
+	for _, name := range interfaces {
+		network := statsJSON.Networks[name]
+		stats.Networks = append(stats.Networks, NetworkInterfaceStats{
+			Interface: name,
+			RxBytes:   network.RxBytes,
+			RxPackets: network.RxPackets,
+			RxErrors:  network.RxErrors,
+			RxDropped: network.RxDropped,
+			TxBytes:   network.TxBytes,
+			TxPackets: network.TxPackets,
+			TxErrors:  network.TxErrors,
+			TxDropped: network.TxDropped,
+		})
+	}
 
-		if systemDelta > 0.0 && cpuDelta > 0.0 {
-			stats.CPUPercent = (cpuDelta / systemDelta) * float64(len(statsJSON.CPUStats.CPUUsage.PercpuUsage)) * 100.0
+	// Sum block IO across all devices
+	for _, entry := range statsJSON.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			stats.BlkioReadBytes += entry.Value
+		case "write":
+			stats.BlkioWriteBytes += entry.Value
+		}
+	}
+	for _, entry := range statsJSON.BlkioStats.IoServicedRecursive {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			stats.BlkioReadOps += entry.Value
+		case "write":
+			stats.BlkioWriteOps += entry.Value
 		}
 	}
 
@@ -121,10 +252,108 @@ func extractStats(containerID string, statsJSON *container.StatsResponse) *Conta
 	return stats
 }
 
+// isCgroupV1 reports whether the memory stats come from a cgroup v1 host.
+// Only cgroup v1 reports the hierarchical "total_*" counters.
+func isCgroupV1(memoryStats *container.MemoryStats) bool {
+	_, ok := memoryStats.Stats["total_inactive_file"]
+	return ok
+}
+
+// calculateCPUPercent calculates the CPU percent the same way the Docker CLI does.
+// OnlineCPUs is used when available since PercpuUsage is not populated on cgroup v2.
+func calculateCPUPercent(cpuStats, preCPUStats *container.CPUStats) float64 {
+	cpuDelta := float64(cpuStats.CPUUsage.TotalUsage) - float64(preCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(cpuStats.SystemUsage) - float64(preCPUStats.SystemUsage)
+
+	onlineCPUs := float64(cpuStats.OnlineCPUs)
+	if onlineCPUs == 0.0 {
+		onlineCPUs = float64(len(cpuStats.CPUUsage.PercpuUsage))
+	}
+
+	if systemDelta > 0.0 && cpuDelta > 0.0 {
+		return (cpuDelta / systemDelta) * onlineCPUs * 100.0
+	}
+	return 0.0
+}
+
+// calculateMemoryUsage returns the memory usage without the inactive page cache,
+// which the kernel can reclaim at any time
+func calculateMemoryUsage(memoryStats *container.MemoryStats) uint64 {
+	inactiveFile := memoryStats.Stats["inactive_file"]
+	if isCgroupV1(memoryStats) {
+		inactiveFile = memoryStats.Stats["total_inactive_file"]
+	}
+
+	if inactiveFile < memoryStats.Usage {
+		return memoryStats.Usage - inactiveFile
+	}
+	return memoryStats.Usage
+}
+
+// calculateMemoryCache returns the page cache size, reported as "cache" on cgroup v1
+// and as "file" on cgroup v2
+func calculateMemoryCache(memoryStats *container.MemoryStats) uint64 {
+	if isCgroupV1(memoryStats) {
+		return memoryStats.Stats["cache"]
+	}
+	return memoryStats.Stats["file"]
+}
+
 // processStats handles the stats data
-func processStats(stats *ContainerUsageStats) {
+func processStats(stats *ContainerUsageStats, sink utils.Sink, exporter *utils.MetricsExporter, status *utils.CollectorStatus, metadata *utils.ContainerMetadata) {
 	log.Printf("Container %s - CPU: %.2f%%, Memory: %.2f%%",
 		stats.ContainerID, stats.CPUPercent, stats.MemoryPercent)
 
-	// TODO: Implement server sending logic here
+	protoStats := toProtoStats(stats)
+	if exporter != nil {
+		exporter.Update(metadata, protoStats)
+	}
+
+	if err := sink.SendUsageStats(metadata, protoStats); err != nil {
+		log.Printf("Error sending usage stats for container %s: %v", stats.ContainerID, err)
+		status.RecordSendError(err)
+		return
+	}
+	status.RecordSent(proto.Size(protoStats), time.Since(stats.Timestamp))
+}
+
+// toProtoStats converts the collected stats into the gRPC message
+func toProtoStats(stats *ContainerUsageStats) *pb.ContainerUsageStats {
+	networks := make([]*pb.NetworkInterfaceStats, 0, len(stats.Networks))
+	for _, network := range stats.Networks {
+		networks = append(networks, &pb.NetworkInterfaceStats{
+			Interface: network.Interface,
+			RxBytes:   network.RxBytes,
+			RxPackets: network.RxPackets,
+			RxErrors:  network.RxErrors,
+			RxDropped: network.RxDropped,
+			TxBytes:   network.TxBytes,
+			TxPackets: network.TxPackets,
+			TxErrors:  network.TxErrors,
+			TxDropped: network.TxDropped,
+		})
+	}
+
+	return &pb.ContainerUsageStats{
+		ContainerId:          stats.ContainerID,
+		Timestamp:            stats.Timestamp.UnixMilli(),
+		CpuPercent:           stats.CPUPercent,
+		CpuUsage:             stats.CPUUsage,
+		SystemCpuUsage:       stats.SystemCPUUsage,
+		MemoryUsage:          stats.MemoryUsage,
+		MemoryLimit:          stats.MemoryLimit,
+		MemoryPercent:        stats.MemoryPercent,
+		MemoryCache:          stats.MemoryCache,
+		Networks:             networks,
+		BlkioReadBytes:       stats.BlkioReadBytes,
+		BlkioWriteBytes:      stats.BlkioWriteBytes,
+		BlkioReadOps:         stats.BlkioReadOps,
+		BlkioWriteOps:        stats.BlkioWriteOps,
+		PidsCurrent:          stats.PidsCurrent,
+		PidsLimit:            stats.PidsLimit,
+		CpuThrottlingPeriods: stats.CPUThrottlingPeriods,
+		CpuThrottledPeriods:  stats.CPUThrottledPeriods,
+		CpuThrottledTime:     stats.CPUThrottledTime,
+		OomKills:             stats.OOMKills,
+	}
 }
@@ -0,0 +1,222 @@
+package docker
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// loadStats decodes a stats payload captured from the Docker API
+func loadStats(t *testing.T, name string) *container.StatsResponse {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stats container.StatsResponse
+	if err := json.Unmarshal(data, &stats); err != nil {
+		t.Fatalf("failed to decode %s: %v", name, err)
+	}
+	return &stats
+}
+
+func TestIsCgroupV1(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    bool
+	}{
+		{"stats_cgroup_v1.json", true},
+		{"stats_cgroup_v2.json", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.payload, func(t *testing.T) {
+			stats := loadStats(t, tt.payload)
+			if got := isCgroupV1(&stats.MemoryStats); got != tt.want {
+				t.Errorf("isCgroupV1() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCalculateCPUPercent(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		modify  func(stats *container.StatsResponse)
+		want    float64
+	}{
+		{
+			// 0.4s of CPU over 4s of system time on 4 CPUs
+			name:    "cgroup v1",
+			payload: "stats_cgroup_v1.json",
+			want:    40,
+		},
+		{
+			// 0.5s of CPU over 2s of system time on 2 CPUs, without percpu_usage
+			name:    "cgroup v2",
+			payload: "stats_cgroup_v2.json",
+			want:    50,
+		},
+		{
+			name:    "no online CPUs falls back to the per CPU usage",
+			payload: "stats_cgroup_v1.json",
+			modify: func(stats *container.StatsResponse) {
+				stats.CPUStats.OnlineCPUs = 0
+				stats.PreCPUStats.OnlineCPUs = 0
+			},
+			want: 40,
+		},
+		{
+			name:    "no online CPUs nor per CPU usage",
+			payload: "stats_cgroup_v2.json",
+			modify: func(stats *container.StatsResponse) {
+				stats.CPUStats.OnlineCPUs = 0
+			},
+			want: 0,
+		},
+		{
+			name:    "zero system delta",
+			payload: "stats_cgroup_v1.json",
+			modify: func(stats *container.StatsResponse) {
+				stats.PreCPUStats.SystemUsage = stats.CPUStats.SystemUsage
+			},
+			want: 0,
+		},
+		{
+			// The first sample of a stream has no previous sample
+			name:    "empty previous sample",
+			payload: "stats_cgroup_v2.json",
+			modify: func(stats *container.StatsResponse) {
+				stats.PreCPUStats = container.CPUStats{}
+			},
+			want: 5000000000.0 / 200000000000.0 * 2 * 100,
+		},
+		{
+			name:    "CPU usage reset",
+			payload: "stats_cgroup_v1.json",
+			modify: func(stats *container.StatsResponse) {
+				stats.CPUStats.CPUUsage.TotalUsage = 100
+			},
+			want: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats := loadStats(t, tt.payload)
+			if tt.modify != nil {
+				tt.modify(stats)
+			}
+			got := calculateCPUPercent(&stats.CPUStats, &stats.PreCPUStats)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("calculateCPUPercent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCalculateMemory(t *testing.T) {
+	tests := []struct {
+		name      string
+		payload   string
+		modify    func(stats *container.StatsResponse)
+		wantUsage uint64
+		wantCache uint64
+	}{
+		{
+			// cgroup v1 subtracts total_inactive_file, not inactive_file
+			name:      "cgroup v1",
+			payload:   "stats_cgroup_v1.json",
+			wantUsage: 104857600 - 8388608,
+			wantCache: 20971520,
+		},
+		{
+			name:      "cgroup v2",
+			payload:   "stats_cgroup_v2.json",
+			wantUsage: 52428800 - 6291456,
+			wantCache: 10485760,
+		},
+		{
+			name:    "inactive file larger than the usage",
+			payload: "stats_cgroup_v2.json",
+			modify: func(stats *container.StatsResponse) {
+				stats.MemoryStats.Stats["inactive_file"] = stats.MemoryStats.Usage + 1
+			},
+			wantUsage: 52428800,
+			wantCache: 10485760,
+		},
+		{
+			name:    "no memory stats",
+			payload: "stats_cgroup_v2.json",
+			modify: func(stats *container.StatsResponse) {
+				stats.MemoryStats.Stats = nil
+			},
+			wantUsage: 52428800,
+			wantCache: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats := loadStats(t, tt.payload)
+			if tt.modify != nil {
+				tt.modify(stats)
+			}
+			if got := calculateMemoryUsage(&stats.MemoryStats); got != tt.wantUsage {
+				t.Errorf("calculateMemoryUsage() = %d, want %d", got, tt.wantUsage)
+			}
+			if got := calculateMemoryCache(&stats.MemoryStats); got != tt.wantCache {
+				t.Errorf("calculateMemoryCache() = %d, want %d", got, tt.wantCache)
+			}
+		})
+	}
+}
+
+func TestExtractStats(t *testing.T) {
+	stats := extractStats("web", loadStats(t, "stats_cgroup_v1.json"))
+
+	if stats.BlkioReadBytes != 5361664 || stats.BlkioWriteBytes != 1216512 {
+		t.Errorf("block IO bytes = %d/%d, want 5361664/1216512", stats.BlkioReadBytes, stats.BlkioWriteBytes)
+	}
+	if stats.BlkioReadOps != 112 || stats.BlkioWriteOps != 41 {
+		t.Errorf("block IO ops = %d/%d, want 112/41", stats.BlkioReadOps, stats.BlkioWriteOps)
+	}
+	if stats.CPUThrottledPeriods != 37 || stats.PidsCurrent != 23 {
+		t.Errorf("throttled periods = %d, pids = %d, want 37 and 23", stats.CPUThrottledPeriods, stats.PidsCurrent)
+	}
+	if len(stats.Networks) != 1 || stats.Networks[0].RxBytes != 1048576 {
+		t.Errorf("networks = %+v, want eth0 with 1048576 bytes received", stats.Networks)
+	}
+	wantPercent := float64(104857600-8388608) / 2147483648 * 100
+	if math.Abs(stats.MemoryPercent-wantPercent) > 1e-9 {
+		t.Errorf("memory percent = %v, want %v", stats.MemoryPercent, wantPercent)
+	}
+}
+
+func TestUnlimitedPidsFitTheDatabase(t *testing.T) {
+	// Docker reports the largest uint64 as the pids limit of a cgroup v2 container without one
+	raw := loadStats(t, "stats_cgroup_v2.json")
+	if raw.PidsStats.Limit != math.MaxUint64 {
+		t.Fatalf("fixture pids limit = %d, want the largest uint64", raw.PidsStats.Limit)
+	}
+
+	stats := toProtoStats(extractStats("web", raw))
+	if stats.PidsLimit != 0 {
+		t.Errorf("PidsLimit = %d, want 0 for no limit", stats.PidsLimit)
+	}
+	// database/sql rejects uint64 values with the high bit set, the server stores them all
+	stats.ProtoReflect().Range(func(field protoreflect.FieldDescriptor, value protoreflect.Value) bool {
+		if field.Kind() == protoreflect.Uint64Kind {
+			if _, err := driver.DefaultParameterConverter.ConvertValue(value.Uint()); err != nil {
+				t.Errorf("%s: %v", field.Name(), err)
+			}
+		}
+		return true
+	})
+}
@@ -0,0 +1,129 @@
+package heartbeat
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	pb "github.com/nox/noxflow/agent/pkg/proto"
+	"github.com/nox/noxflow/agent/utils"
+)
+
+// SendHeartbeats registers the agent with the server and then periodically reports the
+// collector statuses and the container inventory until the context is cancelled
+func SendHeartbeats(ctx context.Context, monitorClient *utils.MonitorClient, registry *utils.StatusRegistry, interval time.Duration) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Printf("Error getting hostname for heartbeats: %v", err)
+	}
+
+	registered := register(ctx, monitorClient, hostname)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Keep trying to register until the server accepts it
+			if !registered {
+				registered = register(ctx, monitorClient, hostname)
+				continue
+			}
+
+			// Without the inventory the server would take the heartbeat for a host without
+			// containers, the heartbeat is skipped instead
+			containers, err := listContainers(ctx)
+			if err != nil {
+				log.Printf("Skipping heartbeat, error listing containers: %v", err)
+				continue
+			}
+
+			heartbeat := &pb.AgentHeartbeat{
+				AgentId:    hostname,
+				Timestamp:  time.Now().UnixMilli(),
+				Collectors: toProtoStatuses(registry.Snapshot()),
+				Containers: containers,
+			}
+			if _, err := monitorClient.SendHeartbeat(heartbeat); err != nil {
+				log.Printf("Error sending heartbeat: %v", err)
+			}
+		}
+	}
+}
+
+// register announces the agent to the server, it reports whether the registration succeeded
+func register(ctx context.Context, monitorClient *utils.MonitorClient, hostname string) bool {
+	containers, err := listContainers(ctx)
+	if err != nil {
+		log.Printf("Error listing containers for registration: %v", err)
+		return false
+	}
+
+	_, err = monitorClient.Register(&pb.AgentRegistration{
+		AgentId:       hostname,
+		Hostname:      hostname,
+		AgentVersion:  utils.AgentVersion,
+		DockerVersion: utils.DockerVersion,
+		Containers:    containers,
+	})
+	if err != nil {
+		log.Printf("Error registering agent: %v", err)
+		return false
+	}
+
+	log.Printf("Registered agent %s with the server", hostname)
+	return true
+}
+
+// listContainers returns the inventory of every container on the host
+func listContainers(ctx context.Context) ([]*pb.ContainerInfo, error) {
+	dockerContainers, err := utils.DockerListContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	containers := make([]*pb.ContainerInfo, 0, len(dockerContainers))
+	for _, container := range dockerContainers {
+		name := ""
+		if len(container.Names) > 0 {
+			name = container.Names[0]
+		}
+		containers = append(containers, &pb.ContainerInfo{
+			ContainerId: container.ID,
+			Name:        name,
+			Image:       container.Image,
+			State:       container.State,
+			Labels:      container.Labels,
+			Created:     container.Created,
+		})
+	}
+	return containers, nil
+}
+
+// toProtoStatuses converts the collector statuses into the gRPC messages
+func toProtoStatuses(statuses []utils.CollectorStatus) []*pb.CollectorStatus {
+	collectors := make([]*pb.CollectorStatus, 0, len(statuses))
+	for _, status := range statuses {
+		collector := &pb.CollectorStatus{
+			ContainerId:   status.ContainerID,
+			ContainerName: status.ContainerName,
+			Kind:          status.Kind,
+			State:         string(status.State),
+			LinesShipped:  status.LinesShipped,
+			BytesShipped:  status.BytesShipped,
+			SendErrors:    status.SendErrors,
+			Restarts:      status.Restarts,
+			LagMs:         status.Lag.Milliseconds(),
+			LastError:     status.LastError,
+		}
+		if !status.LastSent.IsZero() {
+			collector.LastSent = status.LastSent.UnixMilli()
+		}
+		collectors = append(collectors, collector)
+	}
+	return collectors
+}
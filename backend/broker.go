@@ -0,0 +1,201 @@
+package main
+
+import (
+	"sync"
+)
+
+// SlowClientPolicy is what happens when a subscriber's queue is full
+type SlowClientPolicy string
+
+const (
+	// DropLogs drops the logs the subscriber can't keep up with
+	DropLogs SlowClientPolicy = "drop"
+	// Disconnect disconnects the subscriber so it can reconnect and resume
+	Disconnect SlowClientPolicy = "disconnect"
+)
+
+// EventKind is the kind of data an event carries
+type EventKind string
+
+const (
+	KindLog   EventKind = "log"
+	KindUsage EventKind = "usage"
+)
+
+// Event is a log or usage update broadcast to the subscribers. IDs increase so clients
+// can resume from the last event they received.
+type Event struct {
+	ID    uint64
+	Kind  EventKind
+	Data  LogData
+	Usage UsageData
+}
+
+// Subscriber receives the events published to the broker on a buffered queue
+type Subscriber struct {
+	// filters all have to match for an event to be queued
+	filters []*LogFilter
+	kinds   map[EventKind]bool
+	queue   chan Event
+	// done is closed when the subscriber is disconnected by the broker or unsubscribes
+	done      chan struct{}
+	closeOnce sync.Once
+	// dropped counts the events dropped since the last call to Dropped
+	dropped uint64
+}
+
+// Events returns the queue of events
+func (s *Subscriber) Events() <-chan Event {
+	return s.queue
+}
+
+// Done is closed once the subscriber no longer receives events
+func (s *Subscriber) Done() <-chan struct{} {
+	return s.done
+}
+
+// matches reports whether the subscriber wants the event
+func (s *Subscriber) matches(event *Event) bool {
+	if !s.kinds[event.Kind] {
+		return false
+	}
+	for _, filter := range s.filters {
+		if event.Kind == KindLog && !filter.Matches(&event.Data) {
+			return false
+		}
+		if event.Kind == KindUsage && !filter.MatchesUsage(&event.Usage) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Subscriber) close() {
+	s.closeOnce.Do(func() { close(s.done) })
+}
+
+// Broker fans out the published logs to the subscribers without ever blocking the
+// publisher, and keeps the most recent events so clients can resume after a reconnect
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[*Subscriber]struct{}
+	// history is a ring buffer of the most recent events, start is the oldest one
+	history     []Event
+	start       int
+	historySize int
+	// evictedID is the ID of the last log evicted from the history
+	evictedID  uint64
+	nextID     uint64
+	bufferSize int
+	policy     SlowClientPolicy
+}
+
+// NewBroker creates a broker. bufferSize is the queue size of every subscriber and
+// historySize the number of events kept for resuming.
+func NewBroker(bufferSize, historySize int, policy SlowClientPolicy) *Broker {
+	return &Broker{
+		subscribers: make(map[*Subscriber]struct{}),
+		history:     make([]Event, 0, historySize),
+		historySize: historySize,
+		nextID:      1,
+		bufferSize:  bufferSize,
+		policy:      policy,
+	}
+}
+
+// Publish sends the log to every subscriber
+func (b *Broker) Publish(data LogData) {
+	b.publish(Event{Kind: KindLog, Data: data})
+}
+
+// PublishUsage sends the usage update to every subscriber. Only logs are kept in the
+// history, usage updates are superseded by the next one anyway.
+func (b *Broker) PublishUsage(usage UsageData) {
+	b.publish(Event{Kind: KindUsage, Usage: usage})
+}
+
+func (b *Broker) publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	event.ID = b.nextID
+	b.nextID++
+
+	if event.Kind == KindLog {
+		if b.historySize == 0 {
+			b.evictedID = event.ID
+		} else if len(b.history) < b.historySize {
+			b.history = append(b.history, event)
+		} else {
+			b.evictedID = b.history[b.start].ID
+			b.history[b.start] = event
+			b.start = (b.start + 1) % b.historySize
+		}
+	}
+
+	for subscriber := range b.subscribers {
+		if !subscriber.matches(&event) {
+			continue
+		}
+		select {
+		case subscriber.queue <- event:
+		default:
+			if b.policy == Disconnect {
+				delete(b.subscribers, subscriber)
+				subscriber.close()
+			} else {
+				subscriber.dropped++
+			}
+		}
+	}
+}
+
+// Subscribe registers a new subscriber receiving the events of the given kinds matching
+// every filter. When resuming, the matching logs after lastEventID still in the history
+// are returned, along with whether logs were lost because they were no longer kept.
+func (b *Broker) Subscribe(kinds []EventKind, filters []*LogFilter, lastEventID uint64, resume bool) (*Subscriber, []Event, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	subscriber := &Subscriber{
+		filters: filters,
+		kinds:   make(map[EventKind]bool),
+		queue:   make(chan Event, b.bufferSize),
+		done:    make(chan struct{}),
+	}
+	for _, kind := range kinds {
+		subscriber.kinds[kind] = true
+	}
+	b.subscribers[subscriber] = struct{}{}
+
+	if !resume {
+		return subscriber, nil, false
+	}
+
+	var replay []Event
+	for i := range b.history {
+		event := b.history[(b.start+i)%len(b.history)]
+		if event.ID > lastEventID && subscriber.matches(&event) {
+			replay = append(replay, event)
+		}
+	}
+	lost := b.evictedID > lastEventID && subscriber.kinds[KindLog]
+	return subscriber, replay, lost
+}
+
+// Unsubscribe removes the subscriber, it is safe to call after it was disconnected
+func (b *Broker) Unsubscribe(subscriber *Subscriber) {
+	b.mu.Lock()
+	delete(b.subscribers, subscriber)
+	b.mu.Unlock()
+	subscriber.close()
+}
+
+// Dropped returns the number of events dropped for the subscriber since the last call
+func (b *Broker) Dropped(subscriber *Subscriber) uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	dropped := subscriber.dropped
+	subscriber.dropped = 0
+	return dropped
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+var allKinds = []EventKind{KindLog, KindUsage}
+
+func testLog(container, line string) LogData {
+	return LogData{
+		Metadata: ContainerLogMetadata{ContainerID: container + "-id", ContainerName: "/" + container, Image: "nginx:1.25"},
+		Log:      line,
+	}
+}
+
+// received returns the events queued for the subscriber without waiting
+func received(subscriber *Subscriber) []Event {
+	var events []Event
+	for {
+		select {
+		case event := <-subscriber.Events():
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func isDone(subscriber *Subscriber) bool {
+	select {
+	case <-subscriber.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+func TestBrokerPublish(t *testing.T) {
+	broker := NewBroker(10, 10, DropLogs)
+	everything, _, _ := broker.Subscribe(allKinds, nil, 0, false)
+	api, _, _ := broker.Subscribe(allKinds, []*LogFilter{{Containers: []string{"api"}, MinLevel: -1}}, 0, false)
+	usage, _, _ := broker.Subscribe([]EventKind{KindUsage}, nil, 0, false)
+
+	broker.Publish(testLog("api", "GET /"))
+	broker.Publish(testLog("db", "checkpoint"))
+	broker.PublishUsage(UsageData{ContainerID: "api-id", ContainerName: "/api"})
+
+	tests := []struct {
+		name       string
+		subscriber *Subscriber
+		wantIDs    []uint64
+	}{
+		{name: "everything", subscriber: everything, wantIDs: []uint64{1, 2, 3}},
+		{name: "container filter", subscriber: api, wantIDs: []uint64{1, 3}},
+		{name: "usage only", subscriber: usage, wantIDs: []uint64{3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := received(tt.subscriber)
+			if len(events) != len(tt.wantIDs) {
+				t.Fatalf("received %d events, want %d", len(events), len(tt.wantIDs))
+			}
+			for i, event := range events {
+				if event.ID != tt.wantIDs[i] {
+					t.Errorf("event %d has ID %d, want %d", i, event.ID, tt.wantIDs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBrokerUnsubscribe(t *testing.T) {
+	broker := NewBroker(10, 10, DropLogs)
+	subscriber, _, _ := broker.Subscribe(allKinds, nil, 0, false)
+
+	broker.Unsubscribe(subscriber)
+	broker.Publish(testLog("api", "GET /"))
+
+	if !isDone(subscriber) {
+		t.Error("Done() is not closed after Unsubscribe")
+	}
+	if events := received(subscriber); len(events) != 0 {
+		t.Errorf("received %d events after Unsubscribe", len(events))
+	}
+	// Unsubscribing twice is safe
+	broker.Unsubscribe(subscriber)
+}
+
+func TestBrokerSlowClientPolicies(t *testing.T) {
+	tests := []struct {
+		name         string
+		policy       SlowClientPolicy
+		wantDone     bool
+		wantReceived int
+		wantDropped  uint64
+	}{
+		{name: "drop", policy: DropLogs, wantReceived: 2, wantDropped: 3},
+		{name: "disconnect", policy: Disconnect, wantDone: true, wantReceived: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := NewBroker(2, 10, tt.policy)
+			slow, _, _ := broker.Subscribe(allKinds, nil, 0, false)
+			fast, _, _ := broker.Subscribe(allKinds, nil, 0, false)
+
+			for range 5 {
+				broker.Publish(testLog("api", "GET /"))
+				received(fast)
+			}
+
+			if done := isDone(slow); done != tt.wantDone {
+				t.Errorf("slow subscriber disconnected = %v, want %v", done, tt.wantDone)
+			}
+			if isDone(fast) {
+				t.Error("a subscriber keeping up was disconnected")
+			}
+			if events := received(slow); len(events) != tt.wantReceived {
+				t.Errorf("slow subscriber received %d events, want %d", len(events), tt.wantReceived)
+			}
+			if dropped := broker.Dropped(slow); dropped != tt.wantDropped {
+				t.Errorf("Dropped() = %d, want %d", dropped, tt.wantDropped)
+			}
+			if dropped := broker.Dropped(slow); dropped != 0 {
+				t.Errorf("Dropped() = %d after being read, want 0", dropped)
+			}
+		})
+	}
+}
+
+func TestBrokerResume(t *testing.T) {
+	broker := NewBroker(10, 3, DropLogs)
+	for _, container := range []string{"api", "db", "api", "db", "api"} {
+		broker.Publish(testLog(container, "line"))
+	}
+	// Usage updates take an ID but are not kept
+	broker.PublishUsage(UsageData{ContainerID: "api-id", ContainerName: "/api"})
+
+	tests := []struct {
+		name        string
+		kinds       []EventKind
+		filters     []*LogFilter
+		lastEventID uint64
+		resume      bool
+		wantIDs     []uint64
+		wantLost    bool
+	}{
+		{name: "not resuming", kinds: allKinds, lastEventID: 0},
+		{name: "up to date", kinds: allKinds, lastEventID: 6, resume: true},
+		{name: "kept in the history", kinds: allKinds, lastEventID: 3, resume: true, wantIDs: []uint64{4, 5}},
+		{name: "oldest kept", kinds: allKinds, lastEventID: 2, resume: true, wantIDs: []uint64{3, 4, 5}},
+		{name: "evicted", kinds: allKinds, lastEventID: 1, resume: true, wantIDs: []uint64{3, 4, 5}, wantLost: true},
+		{name: "from the start", kinds: allKinds, lastEventID: 0, resume: true, wantIDs: []uint64{3, 4, 5}, wantLost: true},
+		{
+			name:        "filtered",
+			kinds:       allKinds,
+			filters:     []*LogFilter{{Containers: []string{"api"}, MinLevel: -1}},
+			lastEventID: 2,
+			resume:      true,
+			wantIDs:     []uint64{3, 5},
+		},
+		{name: "usage only never loses logs", kinds: []EventKind{KindUsage}, lastEventID: 0, resume: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subscriber, replay, lost := broker.Subscribe(tt.kinds, tt.filters, tt.lastEventID, tt.resume)
+			defer broker.Unsubscribe(subscriber)
+
+			if lost != tt.wantLost {
+				t.Errorf("lost = %v, want %v", lost, tt.wantLost)
+			}
+			if len(replay) != len(tt.wantIDs) {
+				t.Fatalf("replayed %d events, want %d", len(replay), len(tt.wantIDs))
+			}
+			for i, event := range replay {
+				if event.ID != tt.wantIDs[i] {
+					t.Errorf("replayed event %d has ID %d, want %d", i, event.ID, tt.wantIDs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBrokerWithoutHistory(t *testing.T) {
+	broker := NewBroker(10, 0, DropLogs)
+	broker.Publish(testLog("api", "line"))
+
+	_, replay, lost := broker.Subscribe(allKinds, nil, 0, true)
+	if len(replay) != 0 || !lost {
+		t.Errorf("Subscribe() = %d events, lost %v, want none and lost", len(replay), lost)
+	}
+}
+
+func TestBrokerConcurrentUse(t *testing.T) {
+	for _, policy := range []SlowClientPolicy{DropLogs, Disconnect} {
+		t.Run(string(policy), func(t *testing.T) {
+			broker := NewBroker(4, 16, policy)
+			var wg sync.WaitGroup
+			for range 4 {
+				wg.Add(2)
+				go func() {
+					defer wg.Done()
+					for range 200 {
+						broker.Publish(testLog("api", "line"))
+						broker.PublishUsage(UsageData{ContainerID: "api-id"})
+					}
+				}()
+				go func() {
+					defer wg.Done()
+					for range 20 {
+						subscriber, _, _ := broker.Subscribe(allKinds, nil, 0, true)
+						received(subscriber)
+						broker.Dropped(subscriber)
+						broker.Unsubscribe(subscriber)
+					}
+				}()
+			}
+			wg.Wait()
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// levels are the log levels from the least to the most severe
+var levels = []string{"trace", "debug", "info", "warn", "error", "fatal"}
+
+// levelPattern finds the level of a log line, in plain text or JSON logs
+var levelPattern = regexp.MustCompile(`(?i)\b(trace|debug|info|warn|warning|error|err|fatal|panic|critical)\b`)
+
+// LogFilter selects the logs a subscriber receives. Each field matches everything when
+// empty, and the values of a field are alternatives.
+type LogFilter struct {
+	// Containers are globs matched against the container name or a container ID prefix
+	Containers []string
+	// Images are globs matched against the image
+	Images []string
+	States []string
+	Regex  *regexp.Regexp
+	// MinLevel keeps the lines at or above this level, lines without a level are dropped
+	MinLevel int
+}
+
+// ParseLogFilter reads a filter from the container, image, state, regex and level
+// query parameters. container, image and state can be repeated or comma separated.
+func ParseLogFilter(query url.Values) (*LogFilter, error) {
+	filter := &LogFilter{
+		Containers: queryList(query, "container"),
+		Images:     queryList(query, "image"),
+		States:     queryList(query, "state"),
+		MinLevel:   -1,
+	}
+
+	for _, pattern := range append(filter.Containers, filter.Images...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+	}
+
+	if value := query.Get("regex"); value != "" {
+		regex, err := regexp.Compile(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex: %v", err)
+		}
+		filter.Regex = regex
+	}
+
+	if value := query.Get("level"); value != "" {
+		filter.MinLevel = levelIndex(value)
+		if filter.MinLevel < 0 {
+			return nil, fmt.Errorf("invalid level %q: expected one of %s", value, strings.Join(levels, ", "))
+		}
+	}
+
+	return filter, nil
+}
+
+// Matches reports whether the log passes the filter
+func (f *LogFilter) Matches(logData *LogData) bool {
+	metadata := &logData.Metadata
+	name := strings.TrimPrefix(metadata.ContainerName, "/")
+
+	if len(f.Containers) > 0 && !matchAny(f.Containers, name) && !hasAnyPrefix(metadata.ContainerID, f.Containers) {
+		return false
+	}
+	if len(f.Images) > 0 && !matchAny(f.Images, metadata.Image) {
+		return false
+	}
+	if len(f.States) > 0 && !matchAny(f.States, metadata.State) {
+		return false
+	}
+	if f.Regex != nil && !f.Regex.MatchString(logData.Log) {
+		return false
+	}
+	if f.MinLevel >= 0 && lineLevel(logData.Log) < f.MinLevel {
+		return false
+	}
+	return true
+}
+
+// MatchesUsage reports whether the usage update passes the container and image filters,
+// the other fields only apply to logs
+func (f *LogFilter) MatchesUsage(usage *UsageData) bool {
+	name := strings.TrimPrefix(usage.ContainerName, "/")
+	if len(f.Containers) > 0 && !matchAny(f.Containers, name) && !hasAnyPrefix(usage.ContainerID, f.Containers) {
+		return false
+	}
+	if len(f.Images) > 0 && !matchAny(f.Images, usage.Image) {
+		return false
+	}
+	return true
+}
+
+// lineLevel returns the index of the level of a log line, -1 when it has none
+func lineLevel(line string) int {
+	match := levelPattern.FindString(line)
+	if match == "" {
+		return -1
+	}
+	return levelIndex(match)
+}
+
+// levelIndex returns the index of a level name, accepting the common aliases
+func levelIndex(level string) int {
+	switch level = strings.ToLower(level); level {
+	case "warning":
+		level = "warn"
+	case "err":
+		level = "error"
+	case "panic", "critical":
+		level = "fatal"
+	}
+	for i, name := range levels {
+		if name == level {
+			return i
+		}
+	}
+	return -1
+}
+
+// queryList returns the values of a repeated or comma separated query parameter
+func queryList(query url.Values, key string) []string {
+	var items []string
+	for _, value := range query[key] {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAnyPrefix matches container IDs, short IDs are prefixes of the full ID
+func hasAnyPrefix(id string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if id != "" && strings.HasPrefix(id, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,3 +1,5 @@
 module github.com/nox/noxflow/backend
 
 go 1.23.2
+
+require github.com/gorilla/websocket v1.5.3
@@ -2,10 +2,14 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
-	"sync"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type ContainerLogMetadata struct {
@@ -22,67 +26,249 @@ type LogData struct {
 	Log      string
 }
 
-var (
-	clients   = make(map[chan LogData]bool)
-	clientsMu sync.RWMutex
-)
+// UsageData is a container usage update, Timestamp is in unix milliseconds and the
+// network and block IO counters are totals since the container started
+type UsageData struct {
+	ContainerID     string
+	ContainerName   string
+	Image           string
+	Timestamp       int64
+	CPUPercent      float64
+	MemoryUsage     uint64
+	MemoryLimit     uint64
+	MemoryPercent   float64
+	NetworkRxBytes  uint64
+	NetworkTxBytes  uint64
+	BlkioReadBytes  uint64
+	BlkioWriteBytes uint64
+	PidsCurrent     uint64
+}
+
+// maxBodySize bounds the size of the logs posted in one request
+const maxBodySize = 10 << 20
+
+// writeTimeout is how long writing an event to a client can take before it is
+// considered gone
+const writeTimeout = 10 * time.Second
+
+// logStreamHandler receives logs and usage updates over HTTP and streams them to the SSE
+// and WebSocket clients
+type logStreamHandler struct {
+	broker    *Broker
+	heartbeat time.Duration
+	// streams are the named endpoints with their preset filter
+	streams map[string]*LogFilter
+	// origins are the other sites whose pages can open the SSE and WebSocket streams
+	origins AllowedOrigins
+}
+
+func (h *logStreamHandler) handlePublish(w http.ResponseWriter, r *http.Request) {
+	// Handle incoming logs
+	var logData LogData
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&logData); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Broadcast to all connected clients, this never blocks on a slow client
+	h.broker.Publish(logData)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handlePublishBatch receives a JSON array of logs, as sent by the agent's backend output
+func (h *logStreamHandler) handlePublishBatch(w http.ResponseWriter, r *http.Request) {
+	var batch []LogData
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&batch); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	for _, logData := range batch {
+		h.broker.Publish(logData)
+	}
 
-func handleLogStream(w http.ResponseWriter, r *http.Request) {
-	if r.Method == "POST" {
-		// Handle incoming logs
-		var logData LogData
-		if err := json.NewDecoder(r.Body).Decode(&logData); err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+	w.WriteHeader(http.StatusOK)
+}
+
+// handlePublishUsage receives a JSON array of usage updates
+func (h *logStreamHandler) handlePublishUsage(w http.ResponseWriter, r *http.Request) {
+	var batch []UsageData
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&batch); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	for _, usage := range batch {
+		h.broker.PublishUsage(usage)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleStream streams the logs to an SSE client, filtered by the query parameters and
+// the preset filter of a named endpoint
+func (h *logStreamHandler) handleStream(w http.ResponseWriter, r *http.Request) {
+	if !h.origins.Allowed(r) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
+	}
+
+	filter, err := ParseLogFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filters := []*LogFilter{filter}
+	if name := r.PathValue("name"); name != "" {
+		preset, ok := h.streams[name]
+		if !ok {
+			http.Error(w, fmt.Sprintf("Unknown stream %q", name), http.StatusNotFound)
 			return
 		}
+		filters = append(filters, preset)
+	}
 
-		// Broadcast to all connected clients
-		clientsMu.RLock()
-		for client := range clients {
-			client <- logData
+	// Resume after the last event the client received, browsers send it on reconnect
+	var lastEventID uint64
+	resume := false
+	if value := r.Header.Get("Last-Event-ID"); value != "" {
+		parsed, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
 		}
-		clientsMu.RUnlock()
-
-		w.WriteHeader(http.StatusOK)
-		return
+		lastEventID = parsed
+		resume = true
 	}
 
 	// Handle SSE connection
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
 
-	// Create a channel for this client
-	logChan := make(chan LogData)
+	subscriber, replay, lost := h.broker.Subscribe([]EventKind{KindLog}, filters, lastEventID, resume)
+	defer h.broker.Unsubscribe(subscriber)
 
-	clientsMu.Lock()
-	clients[logChan] = true
-	clientsMu.Unlock()
+	rc := http.NewResponseController(w)
+	send := func(format string, args ...any) error {
+		rc.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	if err := send(": connected\n\n"); err != nil {
+		return
+	}
+	if lost {
+		if err := send("event: lost\ndata: {}\n\n"); err != nil {
+			return
+		}
+	}
+	for _, event := range replay {
+		if err := sendEvent(send, event); err != nil {
+			return
+		}
+	}
 
-	// Clean up when the client disconnects
-	defer func() {
-		clientsMu.Lock()
-		delete(clients, logChan)
-		close(logChan)
-		clientsMu.Unlock()
-	}()
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
 
-	// Stream logs to the client
-	for logData := range logChan {
-		data, err := json.Marshal(logData)
-		if err != nil {
-			continue
+	// Stream logs to the client until it goes away or is disconnected for being too slow
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-subscriber.Done():
+			log.Printf("Disconnecting slow client %s", r.RemoteAddr)
+			return
+		case <-heartbeat.C:
+			// Comments keep proxies from closing idle connections
+			if err := send(": heartbeat\n\n"); err != nil {
+				return
+			}
+		case event := <-subscriber.Events():
+			if dropped := h.broker.Dropped(subscriber); dropped > 0 {
+				if err := send("event: dropped\ndata: {\"count\":%d}\n\n", dropped); err != nil {
+					return
+				}
+			}
+			if err := sendEvent(send, event); err != nil {
+				return
+			}
 		}
-		fmt.Fprintf(w, "data: %s\n\n", data)
-		w.(http.Flusher).Flush()
 	}
 }
 
+// sendEvent writes a log as an SSE event with its ID
+func sendEvent(send func(format string, args ...any) error, event Event) error {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return nil
+	}
+	return send("id: %d\ndata: %s\n\n", event.ID, data)
+}
+
 func main() {
-	http.HandleFunc("/logs/stream", handleLogStream)
+	addr := flag.String("addr", ":8080", "address to listen on")
+	bufferSize := flag.Int("client-buffer", 256, "number of logs queued per SSE client")
+	historySize := flag.Int("history", 1000, "number of recent logs kept for clients resuming with Last-Event-ID")
+	slowClients := flag.String("slow-clients", string(DropLogs), "what to do when a client can't keep up: drop (logs) or disconnect")
+	heartbeat := flag.Duration("heartbeat", 15*time.Second, "interval of the keep-alive comments sent to the SSE clients")
+	allowedOrigins := flag.String("allowed-origins", "", "comma separated origins of the pages allowed to open the SSE and WebSocket streams from another site, e.g. https://dashboard.example.com, or * for any")
+	var streams []string
+	flag.Func("stream", "named stream served on /logs/stream/<name>, as <name>=<query>, e.g. payments=container=payments-*&level=warn (can be repeated)", func(value string) error {
+		streams = append(streams, value)
+		return nil
+	})
+	flag.Parse()
+
+	// The WebSocket clients are pinged every heartbeat and dropped after pongTimeout
+	if *heartbeat <= 0 || *heartbeat >= pongTimeout {
+		log.Fatalf("Invalid -heartbeat value %v: expected more than 0 and less than %v", *heartbeat, pongTimeout)
+	}
+
+	policy := SlowClientPolicy(*slowClients)
+	if policy != DropLogs && policy != Disconnect {
+		log.Fatalf("Invalid -slow-clients value %q: expected drop or disconnect", *slowClients)
+	}
+
+	handler := &logStreamHandler{
+		broker:    NewBroker(*bufferSize, *historySize, policy),
+		heartbeat: *heartbeat,
+		streams:   make(map[string]*LogFilter),
+		origins:   ParseAllowedOrigins(*allowedOrigins),
+	}
+	for _, stream := range streams {
+		name, query, _ := strings.Cut(stream, "=")
+		values, err := url.ParseQuery(query)
+		if err != nil || name == "" {
+			log.Fatalf("Invalid stream %q: expected <name>=<query>", stream)
+		}
+		filter, err := ParseLogFilter(values)
+		if err != nil {
+			log.Fatalf("Invalid stream %q: %v", stream, err)
+		}
+		handler.streams[name] = filter
+		log.Printf("Serving stream %s on /logs/stream/%s", name, name)
+	}
+
+	http.HandleFunc("POST /logs/stream", handler.handlePublish)
+	http.HandleFunc("POST /logs/batch", handler.handlePublishBatch)
+	http.HandleFunc("POST /usage/batch", handler.handlePublishUsage)
+	http.HandleFunc("GET /logs/stream", handler.handleStream)
+	http.HandleFunc("GET /logs/stream/{name}", handler.handleStream)
+	http.HandleFunc("GET /ws", handler.handleWebSocket)
 
-	log.Println("Starting server on :8080")
-	if err := http.ListenAndServe(":8080", nil); err != nil {
+	log.Printf("Starting server on %s", *addr)
+	if err := http.ListenAndServe(*addr, nil); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// AllowedOrigins are the origins of the pages allowed to open the SSE and WebSocket
+// streams from another site. Pages served by the backend itself are always allowed.
+type AllowedOrigins []string
+
+// ParseAllowedOrigins reads a comma separated list of origins such as
+// https://dashboard.example.com, "*" allows every origin
+func ParseAllowedOrigins(value string) AllowedOrigins {
+	var origins AllowedOrigins
+	for _, origin := range strings.Split(value, ",") {
+		if origin = strings.TrimRight(strings.TrimSpace(origin), "/"); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
+// Allowed reports whether the page that sent the request may read the streams. Requests
+// without an Origin header don't come from a browser and are allowed.
+func (o AllowedOrigins) Allowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if parsed, err := url.Parse(origin); err == nil && strings.EqualFold(parsed.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range o {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
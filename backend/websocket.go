@@ -0,0 +1,388 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// The WebSocket message schema is documented in WEBSOCKET.md
+
+// pongTimeout is how long a WebSocket client can stay silent before it is considered gone
+const pongTimeout = 60 * time.Second
+
+// maxControlMessageSize bounds the size of the messages a client can send
+const maxControlMessageSize = 64 << 10
+
+// maxSubscriptions bounds the subscriptions of a connection, each one holds a broker
+// queue and a goroutine
+const maxSubscriptions = 16
+
+// FilterSpec is the filter of a subscription, the fields are the same as the query
+// parameters of /logs/stream
+type FilterSpec struct {
+	Container []string `json:"container,omitempty"`
+	Image     []string `json:"image,omitempty"`
+	State     []string `json:"state,omitempty"`
+	Regex     string   `json:"regex,omitempty"`
+	Level     string   `json:"level,omitempty"`
+}
+
+// parse converts the spec into a filter
+func (f *FilterSpec) parse() (*LogFilter, error) {
+	query := url.Values{
+		"container": f.Container,
+		"image":     f.Image,
+		"state":     f.State,
+	}
+	if f.Regex != "" {
+		query.Set("regex", f.Regex)
+	}
+	if f.Level != "" {
+		query.Set("level", f.Level)
+	}
+	return ParseLogFilter(query)
+}
+
+// ClientMessage is a control message sent by a WebSocket client
+type ClientMessage struct {
+	Type string `json:"type"`
+	// ID identifies the subscription, pause and resume apply to every subscription
+	// when it is empty
+	ID       string      `json:"id,omitempty"`
+	Channels []EventKind `json:"channels,omitempty"`
+	Filter   *FilterSpec `json:"filter,omitempty"`
+}
+
+// ServerMessage is a message sent to a WebSocket client
+type ServerMessage struct {
+	Type         string     `json:"type"`
+	Subscription string     `json:"subscription,omitempty"`
+	EventID      uint64     `json:"event_id,omitempty"`
+	Log          *LogData   `json:"log,omitempty"`
+	Usage        *UsageData `json:"usage,omitempty"`
+	Count        uint64     `json:"count,omitempty"`
+	Error        string     `json:"error,omitempty"`
+}
+
+// wsSubscription is a subscription of a WebSocket client
+type wsSubscription struct {
+	id       string
+	channels []EventKind
+	filter   *LogFilter
+	paused   bool
+	// lastEventID is the last log sent, used to resume after a pause
+	lastEventID uint64
+
+	subscriber *Subscriber
+	// stop is closed to stop forwarding the events of subscriber
+	stop chan struct{}
+	// stopped is closed once the forwarding stopped
+	stopped chan struct{}
+}
+
+// wsConn is a WebSocket client connection
+type wsConn struct {
+	conn   *websocket.Conn
+	broker *Broker
+
+	writeMu sync.Mutex
+
+	// subscriptions are only accessed by the read loop
+	subscriptions map[string]*wsSubscription
+	// mu protects the lastEventID of the subscriptions, updated by the forwarding goroutines
+	mu sync.Mutex
+}
+
+// handleWebSocket serves the live tail over a WebSocket, clients control their
+// subscriptions with messages on the same connection
+func (h *logStreamHandler) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  4096,
+		WriteBufferSize: 4096,
+		// The dashboard may be served from another origin listed in -allowed-origins
+		CheckOrigin: h.origins.Allowed,
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already replied with an error
+		return
+	}
+	defer conn.Close()
+
+	c := &wsConn{
+		conn:          conn,
+		broker:        h.broker,
+		subscriptions: make(map[string]*wsSubscription),
+	}
+	defer c.unsubscribeAll()
+
+	conn.SetReadLimit(maxControlMessageSize)
+	conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	})
+
+	// Ping the client to keep proxies from closing the connection and detect dead clients
+	done := make(chan struct{})
+	defer close(done)
+	go c.ping(h.heartbeat, done)
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(pongTimeout))
+
+		var message ClientMessage
+		if err := json.Unmarshal(data, &message); err != nil {
+			c.send(&ServerMessage{Type: "error", Error: "invalid message"})
+			continue
+		}
+
+		if err := c.handle(&message); err != nil {
+			c.send(&ServerMessage{Type: "error", Subscription: message.ID, Error: err.Error()})
+		}
+	}
+}
+
+// handle applies a control message
+func (c *wsConn) handle(message *ClientMessage) error {
+	switch message.Type {
+	case "subscribe":
+		if message.ID == "" {
+			return fmt.Errorf("subscribe needs an id")
+		}
+		if _, ok := c.subscriptions[message.ID]; ok {
+			return fmt.Errorf("subscription %q already exists", message.ID)
+		}
+		if len(c.subscriptions) >= maxSubscriptions {
+			return fmt.Errorf("too many subscriptions: at most %d per connection", maxSubscriptions)
+		}
+		channels := message.Channels
+		if len(channels) == 0 {
+			channels = []EventKind{KindLog}
+		}
+		for _, channel := range channels {
+			if channel != KindLog && channel != KindUsage {
+				return fmt.Errorf("unknown channel %q: expected log or usage", channel)
+			}
+		}
+		filter, err := parseFilterSpec(message.Filter)
+		if err != nil {
+			return err
+		}
+
+		subscription := &wsSubscription{id: message.ID, channels: channels, filter: filter}
+		c.subscriptions[message.ID] = subscription
+		c.start(subscription, false)
+		return c.send(&ServerMessage{Type: "subscribed", Subscription: message.ID})
+
+	case "unsubscribe":
+		subscription, err := c.lookup(message.ID)
+		if err != nil {
+			return err
+		}
+		c.stopForwarding(subscription)
+		delete(c.subscriptions, message.ID)
+		return c.send(&ServerMessage{Type: "unsubscribed", Subscription: message.ID})
+
+	case "filter":
+		subscription, err := c.lookup(message.ID)
+		if err != nil {
+			return err
+		}
+		filter, err := parseFilterSpec(message.Filter)
+		if err != nil {
+			return err
+		}
+		subscription.filter = filter
+		if !subscription.paused {
+			c.stopForwarding(subscription)
+			c.start(subscription, false)
+		}
+		return c.send(&ServerMessage{Type: "filtered", Subscription: message.ID})
+
+	case "pause", "resume":
+		pause := message.Type == "pause"
+		subscriptions, err := c.selectSubscriptions(message.ID)
+		if err != nil {
+			return err
+		}
+		for _, subscription := range subscriptions {
+			if subscription.paused == pause {
+				continue
+			}
+			subscription.paused = pause
+			if pause {
+				c.stopForwarding(subscription)
+			} else {
+				// Send the logs missed while paused that are still in the history
+				c.start(subscription, true)
+			}
+		}
+		return c.send(&ServerMessage{Type: message.Type + "d", Subscription: message.ID})
+
+	case "ping":
+		return c.send(&ServerMessage{Type: "pong"})
+	}
+	return fmt.Errorf("unknown message type %q", message.Type)
+}
+
+// start subscribes to the broker and forwards the events in the background
+func (c *wsConn) start(subscription *wsSubscription, resume bool) {
+	c.mu.Lock()
+	lastEventID := subscription.lastEventID
+	c.mu.Unlock()
+
+	subscriber, replay, lost := c.broker.Subscribe(subscription.channels, []*LogFilter{subscription.filter}, lastEventID, resume && lastEventID > 0)
+	subscription.subscriber = subscriber
+	subscription.stop = make(chan struct{})
+	subscription.stopped = make(chan struct{})
+
+	go c.forward(subscription, subscriber, replay, lost)
+}
+
+// forward sends the events of a subscriber to the client until it is stopped
+func (c *wsConn) forward(subscription *wsSubscription, subscriber *Subscriber, replay []Event, lost bool) {
+	defer close(subscription.stopped)
+
+	if lost {
+		c.send(&ServerMessage{Type: "lost", Subscription: subscription.id})
+	}
+	for _, event := range replay {
+		if c.sendEvent(subscription, event) != nil {
+			return
+		}
+	}
+
+	for {
+		select {
+		case <-subscription.stop:
+			return
+		case <-subscriber.Done():
+			select {
+			case <-subscription.stop:
+				return
+			default:
+			}
+			// The broker disconnected the subscription for being too slow
+			c.send(&ServerMessage{Type: "error", Subscription: subscription.id, Error: "subscription stopped: client too slow"})
+			return
+		case event := <-subscriber.Events():
+			if dropped := c.broker.Dropped(subscriber); dropped > 0 {
+				if c.send(&ServerMessage{Type: "dropped", Subscription: subscription.id, Count: dropped}) != nil {
+					return
+				}
+			}
+			if c.sendEvent(subscription, event) != nil {
+				return
+			}
+		}
+	}
+}
+
+// sendEvent sends a log or usage update
+func (c *wsConn) sendEvent(subscription *wsSubscription, event Event) error {
+	message := &ServerMessage{Type: string(event.Kind), Subscription: subscription.id, EventID: event.ID}
+	if event.Kind == KindLog {
+		message.Log = &event.Data
+		c.mu.Lock()
+		subscription.lastEventID = event.ID
+		c.mu.Unlock()
+	} else {
+		message.Usage = &event.Usage
+	}
+	return c.send(message)
+}
+
+// stopForwarding unsubscribes from the broker and waits for the forwarding to stop
+func (c *wsConn) stopForwarding(subscription *wsSubscription) {
+	if subscription.subscriber == nil {
+		return
+	}
+	close(subscription.stop)
+	c.broker.Unsubscribe(subscription.subscriber)
+	<-subscription.stopped
+	subscription.subscriber = nil
+}
+
+func (c *wsConn) unsubscribeAll() {
+	for _, subscription := range c.subscriptions {
+		c.stopForwarding(subscription)
+	}
+}
+
+// lookup returns the subscription with the given ID
+func (c *wsConn) lookup(id string) (*wsSubscription, error) {
+	subscription, ok := c.subscriptions[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown subscription %q", id)
+	}
+	return subscription, nil
+}
+
+// selectSubscriptions returns the subscription with the given ID, or all of them
+func (c *wsConn) selectSubscriptions(id string) ([]*wsSubscription, error) {
+	if id != "" {
+		subscription, err := c.lookup(id)
+		if err != nil {
+			return nil, err
+		}
+		return []*wsSubscription{subscription}, nil
+	}
+	subscriptions := make([]*wsSubscription, 0, len(c.subscriptions))
+	for _, subscription := range c.subscriptions {
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, nil
+}
+
+// send writes a message, the connection only supports one writer at a time
+func (c *wsConn) send(message *ServerMessage) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if err := c.conn.WriteJSON(message); err != nil {
+		// Unblock the read loop so the connection is cleaned up
+		c.conn.Close()
+		return err
+	}
+	return nil
+}
+
+// ping sends ping frames until done is closed
+func (c *wsConn) ping(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			c.writeMu.Lock()
+			err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout))
+			c.writeMu.Unlock()
+			if err != nil {
+				log.Printf("Error pinging WebSocket client: %v", err)
+				c.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// parseFilterSpec parses an optional filter, no filter matches everything
+func parseFilterSpec(spec *FilterSpec) (*LogFilter, error) {
+	if spec == nil {
+		spec = &FilterSpec{}
+	}
+	return spec.parse()
+}
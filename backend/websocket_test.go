@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialWebSocket serves the WebSocket endpoint of a handler using broker and connects to it
+func dialWebSocket(t *testing.T, broker *Broker) *websocket.Conn {
+	t.Helper()
+	handler := &logStreamHandler{broker: broker, heartbeat: time.Second}
+	server := httptest.NewServer(http.HandlerFunc(handler.handleWebSocket))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func readMessage(t *testing.T, conn *websocket.Conn) ServerMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var message ServerMessage
+	if err := conn.ReadJSON(&message); err != nil {
+		t.Fatalf("reading a message: %v", err)
+	}
+	return message
+}
+
+// exchange sends a control message and returns the reply
+func exchange(t *testing.T, conn *websocket.Conn, message ClientMessage) ServerMessage {
+	t.Helper()
+	if err := conn.WriteJSON(message); err != nil {
+		t.Fatal(err)
+	}
+	return readMessage(t, conn)
+}
+
+func expectReply(t *testing.T, conn *websocket.Conn, message ClientMessage, want string) {
+	t.Helper()
+	if reply := exchange(t, conn, message); reply.Type != want {
+		t.Fatalf("%s: got %q (%s), want %q", message.Type, reply.Type, reply.Error, want)
+	}
+}
+
+// expectLogs reads the next messages and checks they are the logs with the given IDs
+func expectLogs(t *testing.T, conn *websocket.Conn, subscription string, ids ...uint64) {
+	t.Helper()
+	for _, id := range ids {
+		message := readMessage(t, conn)
+		if message.Type != "log" || message.Subscription != subscription || message.EventID != id {
+			t.Fatalf("got %s %d on %q, want log %d on %q", message.Type, message.EventID, message.Subscription, id, subscription)
+		}
+	}
+}
+
+func TestWebSocketSubscribe(t *testing.T) {
+	broker := NewBroker(16, 16, DropLogs)
+	conn := dialWebSocket(t, broker)
+
+	expectReply(t, conn, ClientMessage{Type: "subscribe", ID: "api", Filter: &FilterSpec{Container: []string{"api"}}}, "subscribed")
+	broker.Publish(testLog("db", "checkpoint"))
+	broker.Publish(testLog("api", "GET /"))
+	expectLogs(t, conn, "api", 2)
+
+	expectReply(t, conn, ClientMessage{Type: "unsubscribe", ID: "api"}, "unsubscribed")
+	broker.Publish(testLog("api", "GET /"))
+	expectReply(t, conn, ClientMessage{Type: "ping"}, "pong")
+}
+
+func TestWebSocketChannels(t *testing.T) {
+	broker := NewBroker(16, 16, DropLogs)
+	conn := dialWebSocket(t, broker)
+
+	expectReply(t, conn, ClientMessage{Type: "subscribe", ID: "usage", Channels: []EventKind{KindUsage}}, "subscribed")
+	broker.Publish(testLog("api", "GET /"))
+	broker.PublishUsage(UsageData{ContainerID: "api-id", ContainerName: "/api", CPUPercent: 3.2})
+
+	message := readMessage(t, conn)
+	if message.Type != "usage" || message.EventID != 2 || message.Usage == nil || message.Usage.CPUPercent != 3.2 {
+		t.Errorf("got %+v, want the usage update", message)
+	}
+}
+
+func TestWebSocketFilter(t *testing.T) {
+	broker := NewBroker(16, 16, DropLogs)
+	conn := dialWebSocket(t, broker)
+
+	expectReply(t, conn, ClientMessage{Type: "subscribe", ID: "web"}, "subscribed")
+	expectReply(t, conn, ClientMessage{Type: "filter", ID: "web", Filter: &FilterSpec{Container: []string{"db"}, Level: "error"}}, "filtered")
+	broker.Publish(testLog("api", "ERROR timeout"))
+	broker.Publish(testLog("db", "INFO checkpoint"))
+	broker.Publish(testLog("db", "ERROR disk full"))
+	expectLogs(t, conn, "web", 3)
+}
+
+func TestWebSocketPauseResume(t *testing.T) {
+	broker := NewBroker(16, 2, DropLogs)
+	conn := dialWebSocket(t, broker)
+
+	expectReply(t, conn, ClientMessage{Type: "subscribe", ID: "web"}, "subscribed")
+	broker.Publish(testLog("api", "1"))
+	expectLogs(t, conn, "web", 1)
+
+	expectReply(t, conn, ClientMessage{Type: "pause"}, "paused")
+	broker.Publish(testLog("api", "2"))
+	broker.Publish(testLog("api", "3"))
+	broker.Publish(testLog("api", "4"))
+
+	// The logs kept in the history are replayed, the older one is reported lost. The
+	// replay can come before or after the reply.
+	if err := conn.WriteJSON(ClientMessage{Type: "resume", ID: "web"}); err != nil {
+		t.Fatal(err)
+	}
+	var replay []string
+	resumed := false
+	for range 4 {
+		message := readMessage(t, conn)
+		if message.Type == "resumed" {
+			resumed = true
+			continue
+		}
+		replay = append(replay, fmt.Sprintf("%s %d", message.Type, message.EventID))
+	}
+	if want := "lost 0, log 3, log 4"; !resumed || strings.Join(replay, ", ") != want {
+		t.Errorf("resume sent %q (reply %v), want %q and the reply", strings.Join(replay, ", "), resumed, want)
+	}
+
+	broker.Publish(testLog("api", "5"))
+	expectLogs(t, conn, "web", 5)
+}
+
+func TestWebSocketErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		message ClientMessage
+		want    string
+	}{
+		{name: "subscribe without id", message: ClientMessage{Type: "subscribe"}, want: "needs an id"},
+		{name: "duplicate id", message: ClientMessage{Type: "subscribe", ID: "web"}, want: "already exists"},
+		{name: "unknown channel", message: ClientMessage{Type: "subscribe", ID: "new", Channels: []EventKind{"events"}}, want: "unknown channel"},
+		{name: "invalid filter", message: ClientMessage{Type: "subscribe", ID: "new", Filter: &FilterSpec{Regex: "("}}, want: "invalid regex"},
+		{name: "unknown subscription", message: ClientMessage{Type: "unsubscribe", ID: "other"}, want: "unknown subscription"},
+		{name: "filter of unknown subscription", message: ClientMessage{Type: "filter", ID: "other"}, want: "unknown subscription"},
+		{name: "unknown type", message: ClientMessage{Type: "tail"}, want: "unknown message type"},
+	}
+
+	conn := dialWebSocket(t, NewBroker(16, 16, DropLogs))
+	expectReply(t, conn, ClientMessage{Type: "subscribe", ID: "web"}, "subscribed")
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply := exchange(t, conn, tt.message)
+			if reply.Type != "error" || !strings.Contains(reply.Error, tt.want) {
+				t.Errorf("got %s %q, want an error containing %q", reply.Type, reply.Error, tt.want)
+			}
+		})
+	}
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("{")); err != nil {
+		t.Fatal(err)
+	}
+	if reply := readMessage(t, conn); reply.Type != "error" || reply.Error != "invalid message" {
+		t.Errorf("invalid JSON: got %s %q, want invalid message", reply.Type, reply.Error)
+	}
+}
+
+func TestWebSocketSubscriptionLimit(t *testing.T) {
+	conn := dialWebSocket(t, NewBroker(16, 16, DropLogs))
+	for i := range maxSubscriptions {
+		expectReply(t, conn, ClientMessage{Type: "subscribe", ID: fmt.Sprint(i)}, "subscribed")
+	}
+
+	reply := exchange(t, conn, ClientMessage{Type: "subscribe", ID: "extra"})
+	if reply.Type != "error" || !strings.Contains(reply.Error, "too many subscriptions") {
+		t.Fatalf("got %s %q, want the limit error", reply.Type, reply.Error)
+	}
+
+	// Unsubscribing makes room for another one
+	expectReply(t, conn, ClientMessage{Type: "unsubscribe", ID: "0"}, "unsubscribed")
+	expectReply(t, conn, ClientMessage{Type: "subscribe", ID: "extra"}, "subscribed")
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Alert is an alert, as returned by /api/alerts
+type Alert struct {
+	ID            int64     `json:"id"`
+	Fingerprint   string    `json:"fingerprint"`
+	Rule          string    `json:"rule"`
+	Kind          string    `json:"kind"`
+	Severity      string    `json:"severity"`
+	State         string    `json:"state"`
+	ContainerID   string    `json:"container_id"`
+	ContainerName string    `json:"container_name"`
+	Image         string    `json:"image"`
+	Value         float64   `json:"value"`
+	Summary       string    `json:"summary"`
+	Samples       []string  `json:"samples,omitempty"`
+	StartedAt     time.Time `json:"started_at"`
+	FiredAt       time.Time `json:"fired_at,omitempty"`
+	ResolvedAt    time.Time `json:"resolved_at,omitempty"`
+}
+
+// runAlerts lists the alert history, or the pending and firing alerts
+func runAlerts(ctx context.Context, args []string) error {
+	fs, common := newFlagSet("alerts", outputTable, outputJSON)
+	active := fs.Bool("active", false, "only the pending and firing alerts")
+	state := fs.String("state", "", "only the alerts in this state: pending, firing or resolved")
+	limit := fs.Int("limit", 100, "maximum number of alerts from the history")
+	fs.Parse(args)
+
+	client, err := common.client()
+	if err != nil {
+		return err
+	}
+	if *active && *state != "" {
+		return fmt.Errorf("-active can't be combined with -state")
+	}
+
+	var alerts []Alert
+	if *active {
+		err = client.Get(ctx, "/api/alerts/active", nil, &alerts)
+	} else {
+		query := url.Values{"limit": {strconv.Itoa(*limit)}}
+		if *state != "" {
+			query.Set("state", *state)
+		}
+		err = client.Get(ctx, "/api/alerts", query, &alerts)
+	}
+	if err != nil {
+		return err
+	}
+
+	if common.output == outputJSON {
+		return printJSON(os.Stdout, alerts)
+	}
+	table := newTable(os.Stdout)
+	row(table, "STATE", "SEVERITY", "RULE", "CONTAINER", "VALUE", "STARTED", "SUMMARY")
+	for _, a := range alerts {
+		container := a.ContainerName
+		if container == "" {
+			container = shortID(a.ContainerID)
+		}
+		row(table, a.State, a.Severity, a.Rule, container, strconv.FormatFloat(a.Value, 'g', 4, 64), ago(a.StartedAt), a.Summary)
+	}
+	return table.Flush()
+}
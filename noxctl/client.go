@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// serverEnv is the environment variable holding the default server URL
+const serverEnv = "NOXCTL_SERVER"
+
+// defaultServer is the server's default HTTP address
+const defaultServer = "http://localhost:8889"
+
+// Client reads the server's JSON API
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// NewClient creates a client for the server at baseURL
+func NewClient(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Get fetches an API path and decodes the JSON response into out
+func (c *Client) Get(ctx context.Context, path string, query url.Values, out any) error {
+	target := c.BaseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error querying the server: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// The API reports errors as {"error": "..."}
+		var apiError struct {
+			Error string `json:"error"`
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Unmarshal(body, &apiError) == nil && apiError.Error != "" {
+			return fmt.Errorf("server returned %d: %s", resp.StatusCode, apiError.Error)
+		}
+		return fmt.Errorf("server returned %d", resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("error decoding the response: %v", err)
+	}
+	return nil
+}
+
+// commonFlags are the flags every command takes
+type commonFlags struct {
+	server  string
+	output  string
+	outputs []string
+}
+
+// newFlagSet creates the flag set of a command with the common flags. outputs are the
+// accepted -output values, the first one is the default.
+func newFlagSet(name string, outputs ...string) (*flag.FlagSet, *commonFlags) {
+	fs := flag.NewFlagSet("noxctl "+name, flag.ExitOnError)
+	common := &commonFlags{outputs: outputs}
+
+	server := os.Getenv(serverEnv)
+	if server == "" {
+		server = defaultServer
+	}
+	fs.StringVar(&common.server, "server", server, "URL of the NoxFlow server HTTP API")
+	if len(outputs) > 0 {
+		fs.StringVar(&common.output, "o", outputs[0], "output format: "+strings.Join(outputs, " or "))
+	}
+	return fs, common
+}
+
+// client validates the output format and creates the API client
+func (f *commonFlags) client() (*Client, error) {
+	if len(f.outputs) > 0 && !slices.Contains(f.outputs, f.output) {
+		return nil, fmt.Errorf("invalid output %q: expected %s", f.output, strings.Join(f.outputs, " or "))
+	}
+	return NewClient(f.server), nil
+}
@@ -0,0 +1,3 @@
+module github.com/nox/noxflow/noxctl
+
+go 1.23.2
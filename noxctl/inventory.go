@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"time"
+)
+
+// Agent is an agent, as returned by /api/agents
+type Agent struct {
+	AgentID       string    `json:"agent_id"`
+	Hostname      string    `json:"hostname"`
+	AgentVersion  string    `json:"agent_version"`
+	DockerVersion string    `json:"docker_version"`
+	RegisteredAt  time.Time `json:"registered_at"`
+	LastSeen      time.Time `json:"last_seen"`
+	Reporting     bool      `json:"reporting"`
+	Containers    int       `json:"containers"`
+}
+
+// Container is a container known to an agent, as returned by /api/containers
+type Container struct {
+	AgentID     string            `json:"agent_id"`
+	ContainerID string            `json:"container_id"`
+	Name        string            `json:"name"`
+	Image       string            `json:"image"`
+	State       string            `json:"state"`
+	Labels      map[string]string `json:"labels"`
+	CreatedAt   time.Time         `json:"created_at"`
+	FirstSeen   time.Time         `json:"first_seen"`
+	LastSeen    time.Time         `json:"last_seen"`
+}
+
+// runContainers lists the container inventory of the agents
+func runContainers(ctx context.Context, args []string) error {
+	fs, common := newFlagSet("containers", outputTable, outputJSON)
+	agent := fs.String("agent", "", "only the containers of this agent")
+	state := fs.String("state", "", "only the containers in this state, e.g. running")
+	fs.Parse(args)
+
+	client, err := common.client()
+	if err != nil {
+		return err
+	}
+
+	var containers []Container
+	if err := client.Get(ctx, "/api/containers", nil, &containers); err != nil {
+		return err
+	}
+	selected := containers[:0]
+	for _, container := range containers {
+		if (*agent == "" || container.AgentID == *agent) && (*state == "" || container.State == *state) {
+			selected = append(selected, container)
+		}
+	}
+
+	if common.output == outputJSON {
+		return printJSON(os.Stdout, selected)
+	}
+	table := newTable(os.Stdout)
+	row(table, "AGENT", "NAME", "ID", "IMAGE", "STATE", "CREATED", "LAST SEEN")
+	for _, c := range selected {
+		row(table, c.AgentID, c.Name, shortID(c.ContainerID), c.Image, c.State, ago(c.CreatedAt), ago(c.LastSeen))
+	}
+	return table.Flush()
+}
+
+// runAgents lists the agents and whether they are still reporting
+func runAgents(ctx context.Context, args []string) error {
+	fs, common := newFlagSet("agents", outputTable, outputJSON)
+	darkAfter := fs.Duration("dark-after", 2*time.Minute, "how long an agent can stay silent before it is reported as dark")
+	fs.Parse(args)
+
+	client, err := common.client()
+	if err != nil {
+		return err
+	}
+
+	var agents []Agent
+	query := url.Values{"dark_after": {darkAfter.String()}}
+	if err := client.Get(ctx, "/api/agents", query, &agents); err != nil {
+		return err
+	}
+
+	if common.output == outputJSON {
+		return printJSON(os.Stdout, agents)
+	}
+	table := newTable(os.Stdout)
+	row(table, "AGENT", "HOSTNAME", "STATUS", "CONTAINERS", "VERSION", "DOCKER", "LAST SEEN")
+	for _, a := range agents {
+		status := "reporting"
+		if !a.Reporting {
+			status = "dark"
+		}
+		row(table, a.AgentID, a.Hostname, status, a.Containers, a.AgentVersion, a.DockerVersion, ago(a.LastSeen))
+	}
+	return table.Flush()
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogEntry is a stored log line, as returned by /api/logs
+type LogEntry struct {
+	ID            int64     `json:"id"`
+	Timestamp     time.Time `json:"timestamp"`
+	ContainerName string    `json:"container_name"`
+	Message       string    `json:"message"`
+}
+
+// runLogs queries the stored logs and optionally follows the new ones
+func runLogs(ctx context.Context, args []string) error {
+	fs, common := newFlagSet("logs", outputPlain, outputJSON)
+	container := fs.String("container", "", "container name")
+	search := fs.String("q", "", "only logs containing this text, case insensitive")
+	since := fs.String("since", "", "only logs after this time, a duration like 15m or an RFC 3339 timestamp")
+	until := fs.String("until", "", "only logs before this time, a duration like 15m or an RFC 3339 timestamp")
+	limit := fs.Int("limit", 100, "maximum number of logs printed before following")
+	follow := fs.Bool("f", false, "follow new logs")
+	interval := fs.Duration("interval", 2*time.Second, "how often to poll for new logs with -f")
+	fs.Parse(args)
+
+	client, err := common.client()
+	if err != nil {
+		return err
+	}
+	if *follow && *until != "" {
+		return fmt.Errorf("-f can't be combined with -until")
+	}
+	if *limit <= 0 {
+		return fmt.Errorf("-limit must be positive")
+	}
+
+	query := url.Values{}
+	if *container != "" {
+		query.Set("container", *container)
+	}
+	if *search != "" {
+		query.Set("q", *search)
+	}
+	for key, value := range map[string]string{"since": *since, "until": *until} {
+		t, err := parseTime(value)
+		if err != nil {
+			return err
+		}
+		if !t.IsZero() {
+			query.Set(key, formatTime(t))
+		}
+	}
+	query.Set("limit", strconv.Itoa(*limit))
+
+	printer := &logPrinter{json: common.output == outputJSON, showContainer: *container == ""}
+
+	var logs []LogEntry
+	if err := client.Get(ctx, "/api/logs", query, &logs); err != nil {
+		return err
+	}
+	lastID := printer.print(logs, 0)
+	if !*follow {
+		return nil
+	}
+
+	// Poll for the logs stored after the last one printed. The server writes the logs in
+	// batches, so they show up a few seconds after the containers wrote them.
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		if lastID > 0 {
+			query.Set("after_id", strconv.FormatInt(lastID, 10))
+		}
+		logs = nil
+		if err := client.Get(ctx, "/api/logs", query, &logs); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			fmt.Fprintf(os.Stderr, "noxctl logs: %v\n", err)
+			continue
+		}
+		lastID = printer.print(logs, lastID)
+	}
+}
+
+// logPrinter prints log entries as plain text or JSON lines
+type logPrinter struct {
+	json bool
+	// showContainer prefixes the plain lines with the container name, for queries
+	// spanning several containers
+	showContainer bool
+}
+
+// print prints the entries and returns the ID of the last one
+func (p *logPrinter) print(logs []LogEntry, lastID int64) int64 {
+	encoder := json.NewEncoder(os.Stdout)
+	for _, entry := range logs {
+		if p.json {
+			encoder.Encode(entry)
+		} else if p.showContainer {
+			fmt.Printf("%s %s %s\n", entry.Timestamp.Local().Format(time.RFC3339), strings.TrimPrefix(entry.ContainerName, "/"), entry.Message)
+		} else {
+			fmt.Printf("%s %s\n", entry.Timestamp.Local().Format(time.RFC3339), entry.Message)
+		}
+		lastID = max(lastID, entry.ID)
+	}
+	return lastID
+}
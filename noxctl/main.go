@@ -0,0 +1,66 @@
+// Command noxctl queries the NoxFlow server from the terminal: logs, container usage,
+// the container inventory, the agents and the alerts.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// command is a noxctl subcommand
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, args []string) error
+}
+
+var commands = []command{
+	{"logs", "query the stored logs, -f follows new logs", runLogs},
+	{"top", "live usage of the containers", runTop},
+	{"containers", "container inventory of the agents", runContainers},
+	{"agents", "agents and whether they are reporting", runAgents},
+	{"alerts", "alert history, or the active alerts with -active", runAlerts},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: noxctl <command> [flags]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun noxctl <command> -h for the flags of a command. The server URL defaults to $%s.\n", serverEnv)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	name := os.Args[1]
+	if name == "-h" || name == "-help" || name == "--help" || name == "help" {
+		usage()
+		return
+	}
+
+	// Stop following and refreshing on Ctrl-C
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		if err := cmd.run(ctx, os.Args[2:]); err != nil && ctx.Err() == nil {
+			fmt.Fprintf(os.Stderr, "noxctl %s: %v\n", name, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "noxctl: unknown command %q\n\n", name)
+	usage()
+	os.Exit(2)
+}
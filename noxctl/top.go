@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ContainerUsage is the latest usage of a container, as returned by /api/usage/latest
+type ContainerUsage struct {
+	AgentID       string    `json:"agent_id"`
+	ContainerID   string    `json:"container_id"`
+	Name          string    `json:"name"`
+	Image         string    `json:"image"`
+	State         string    `json:"state"`
+	Timestamp     time.Time `json:"timestamp"`
+	CPUPercent    float64   `json:"cpu_percent"`
+	MemoryPercent float64   `json:"memory_percent"`
+	MemoryUsage   uint64    `json:"memory_usage"`
+	MemoryLimit   uint64    `json:"memory_limit"`
+	PidsCurrent   uint64    `json:"pids_current"`
+}
+
+// clearScreen moves the cursor home and clears the terminal
+const clearScreen = "\033[H\033[2J"
+
+// runTop shows the latest usage of the containers, refreshed until interrupted
+func runTop(ctx context.Context, args []string) error {
+	fs, common := newFlagSet("top")
+	interval := fs.Duration("interval", 2*time.Second, "refresh interval")
+	iterations := fs.Int("n", 0, "number of refreshes before exiting, 0 refreshes until interrupted")
+	sortBy := fs.String("sort", "cpu", "sort order: cpu, mem or name")
+	fs.Parse(args)
+
+	client, err := common.client()
+	if err != nil {
+		return err
+	}
+	less, err := usageOrder(*sortBy)
+	if err != nil {
+		return err
+	}
+	terminal := isTerminal(os.Stdout)
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for i := 1; ; i++ {
+		var usage []ContainerUsage
+		if err := client.Get(ctx, "/api/usage/latest", nil, &usage); err != nil {
+			return err
+		}
+		sort.SliceStable(usage, func(a, b int) bool { return less(&usage[a], &usage[b]) })
+
+		// Render the whole screen at once to avoid flickering
+		var out bytes.Buffer
+		if terminal {
+			out.WriteString(clearScreen)
+		}
+		writeUsageTable(&out, usage)
+		os.Stdout.Write(out.Bytes())
+
+		if *iterations > 0 && i >= *iterations {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// usageOrder returns the comparison of a -sort value
+func usageOrder(sortBy string) (func(a, b *ContainerUsage) bool, error) {
+	switch sortBy {
+	case "cpu":
+		return func(a, b *ContainerUsage) bool { return a.CPUPercent > b.CPUPercent }, nil
+	case "mem":
+		return func(a, b *ContainerUsage) bool { return a.MemoryUsage > b.MemoryUsage }, nil
+	case "name":
+		return func(a, b *ContainerUsage) bool { return a.Name < b.Name }, nil
+	}
+	return nil, fmt.Errorf("invalid sort %q: expected cpu, mem or name", sortBy)
+}
+
+func writeUsageTable(out *bytes.Buffer, usage []ContainerUsage) {
+	var cpu float64
+	var memory uint64
+	for _, u := range usage {
+		cpu += u.CPUPercent
+		memory += u.MemoryUsage
+	}
+	fmt.Fprintf(out, "%s  %d containers  CPU %.1f%%  memory %s\n\n",
+		time.Now().Format(time.TimeOnly), len(usage), cpu, formatBytes(memory))
+
+	table := newTable(out)
+	row(table, "NAME", "ID", "IMAGE", "STATE", "CPU %", "MEM USAGE / LIMIT", "MEM %", "PIDS", "UPDATED")
+	for _, u := range usage {
+		memoryUsage := formatBytes(u.MemoryUsage)
+		if u.MemoryLimit > 0 {
+			memoryUsage += " / " + formatBytes(u.MemoryLimit)
+		}
+		row(table,
+			strings.TrimPrefix(u.Name, "/"),
+			shortID(u.ContainerID),
+			u.Image,
+			u.State,
+			fmt.Sprintf("%.1f", u.CPUPercent),
+			memoryUsage,
+			fmt.Sprintf("%.1f", u.MemoryPercent),
+			u.PidsCurrent,
+			ago(u.Timestamp),
+		)
+	}
+	table.Flush()
+}
@@ -0,0 +1,9 @@
+// Package db holds the database migrations of the server
+package db
+
+import "embed"
+
+// Migrations are the migrations of the server's schema, run on startup
+//
+//go:embed migrations/*.sql
+var Migrations embed.FS
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS container_usage;
+DROP TABLE IF EXISTS container_logs;
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS container_logs (
+    id BIGSERIAL PRIMARY KEY,
+    timestamp TIMESTAMPTZ NOT NULL,
+    container_name TEXT NOT NULL,
+    log_message TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_container_logs_container_time
+    ON container_logs (container_name, timestamp);
+
+CREATE TABLE IF NOT EXISTS container_usage (
+    id BIGSERIAL PRIMARY KEY,
+    timestamp TIMESTAMPTZ NOT NULL,
+    container_id TEXT NOT NULL,
+    cpu_percent DOUBLE PRECISION NOT NULL,
+    memory_percent DOUBLE PRECISION NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_container_usage_container_time
+    ON container_usage (container_id, timestamp);
@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS container_network_usage;
+
+ALTER TABLE container_usage
+    DROP COLUMN IF EXISTS blkio_read_bytes,
+    DROP COLUMN IF EXISTS blkio_write_bytes,
+    DROP COLUMN IF EXISTS blkio_read_ops,
+    DROP COLUMN IF EXISTS blkio_write_ops,
+    DROP COLUMN IF EXISTS pids_current,
+    DROP COLUMN IF EXISTS pids_limit,
+    DROP COLUMN IF EXISTS cpu_throttling_periods,
+    DROP COLUMN IF EXISTS cpu_throttled_periods,
+    DROP COLUMN IF EXISTS cpu_throttled_time,
+    DROP COLUMN IF EXISTS oom_kills;
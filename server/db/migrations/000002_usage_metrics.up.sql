@@ -0,0 +1,29 @@
+ALTER TABLE container_usage
+    ADD COLUMN IF NOT EXISTS blkio_read_bytes BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS blkio_write_bytes BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS blkio_read_ops BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS blkio_write_ops BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS pids_current BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS pids_limit BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS cpu_throttling_periods BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS cpu_throttled_periods BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS cpu_throttled_time BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS oom_kills BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS container_network_usage (
+    id BIGSERIAL PRIMARY KEY,
+    timestamp TIMESTAMPTZ NOT NULL,
+    container_id TEXT NOT NULL,
+    interface TEXT NOT NULL,
+    rx_bytes BIGINT NOT NULL,
+    rx_packets BIGINT NOT NULL,
+    rx_errors BIGINT NOT NULL,
+    rx_dropped BIGINT NOT NULL,
+    tx_bytes BIGINT NOT NULL,
+    tx_packets BIGINT NOT NULL,
+    tx_errors BIGINT NOT NULL,
+    tx_dropped BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_container_network_usage_container_time
+    ON container_network_usage (container_id, timestamp);
@@ -0,0 +1,6 @@
+ALTER TABLE container_usage
+    DROP COLUMN IF EXISTS cpu_usage,
+    DROP COLUMN IF EXISTS system_cpu_usage,
+    DROP COLUMN IF EXISTS memory_usage,
+    DROP COLUMN IF EXISTS memory_limit,
+    DROP COLUMN IF EXISTS memory_cache;
@@ -0,0 +1,6 @@
+ALTER TABLE container_usage
+    ADD COLUMN IF NOT EXISTS cpu_usage BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS system_cpu_usage BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS memory_usage BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS memory_limit BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS memory_cache BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS agent_collectors (
+    agent_id TEXT NOT NULL,
+    container_id TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    container_name TEXT NOT NULL,
+    state TEXT NOT NULL,
+    lines_shipped BIGINT NOT NULL,
+    bytes_shipped BIGINT NOT NULL,
+    send_errors BIGINT NOT NULL,
+    restarts INTEGER NOT NULL,
+    last_sent TIMESTAMPTZ,
+    lag_ms BIGINT NOT NULL,
+    last_error TEXT NOT NULL,
+    reported_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (agent_id, container_id, kind)
+);
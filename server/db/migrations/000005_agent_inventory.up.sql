@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS agents (
+    agent_id TEXT PRIMARY KEY,
+    hostname TEXT NOT NULL,
+    agent_version TEXT NOT NULL,
+    docker_version TEXT NOT NULL,
+    registered_at TIMESTAMPTZ NOT NULL,
+    last_seen TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS agent_containers (
+    agent_id TEXT NOT NULL REFERENCES agents (agent_id) ON DELETE CASCADE,
+    container_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    image TEXT NOT NULL,
+    state TEXT NOT NULL,
+    labels JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ,
+    first_seen TIMESTAMPTZ NOT NULL,
+    last_seen TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (agent_id, container_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_agent_containers_last_seen
+    ON agent_containers (last_seen);
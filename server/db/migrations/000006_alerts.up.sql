@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS alerts (
+    id BIGSERIAL PRIMARY KEY,
+    fingerprint TEXT NOT NULL,
+    rule TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    severity TEXT NOT NULL,
+    state TEXT NOT NULL,
+    container_id TEXT NOT NULL,
+    container_name TEXT NOT NULL,
+    image TEXT NOT NULL,
+    value DOUBLE PRECISION NOT NULL,
+    summary TEXT NOT NULL,
+    started_at TIMESTAMPTZ NOT NULL,
+    fired_at TIMESTAMPTZ,
+    resolved_at TIMESTAMPTZ,
+    UNIQUE (fingerprint, started_at)
+);
+
+CREATE INDEX IF NOT EXISTS idx_alerts_state_started
+    ON alerts (state, started_at);
@@ -0,0 +1,2 @@
+ALTER TABLE alerts
+    DROP COLUMN IF EXISTS samples;
@@ -0,0 +1,2 @@
+ALTER TABLE alerts
+    ADD COLUMN IF NOT EXISTS samples JSONB NOT NULL DEFAULT '[]';
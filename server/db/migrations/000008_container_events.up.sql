@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS container_events (
+    id BIGSERIAL PRIMARY KEY,
+    timestamp TIMESTAMPTZ NOT NULL,
+    agent_id TEXT NOT NULL,
+    container_id TEXT NOT NULL,
+    container_name TEXT NOT NULL,
+    image TEXT NOT NULL,
+    action TEXT NOT NULL,
+    exit_code INTEGER,
+    health_status TEXT,
+    attributes JSONB NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX IF NOT EXISTS idx_container_events_container_timestamp
+    ON container_events (container_id, timestamp);
+
+CREATE INDEX IF NOT EXISTS idx_container_events_timestamp
+    ON container_events (timestamp);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_container_network_usage_time;
+DROP INDEX IF EXISTS idx_container_usage_time;
+DROP TABLE IF EXISTS usage_rollup_state;
+DROP TABLE IF EXISTS container_usage_1h;
+DROP TABLE IF EXISTS container_usage_1m;
@@ -0,0 +1,112 @@
+CREATE TABLE IF NOT EXISTS container_usage_1m (
+    container_id TEXT NOT NULL,
+    bucket TIMESTAMPTZ NOT NULL,
+    samples INTEGER NOT NULL,
+    cpu_percent_min DOUBLE PRECISION NOT NULL,
+    cpu_percent_avg DOUBLE PRECISION NOT NULL,
+    cpu_percent_max DOUBLE PRECISION NOT NULL,
+    cpu_percent_last DOUBLE PRECISION NOT NULL,
+    memory_percent_min DOUBLE PRECISION NOT NULL,
+    memory_percent_avg DOUBLE PRECISION NOT NULL,
+    memory_percent_max DOUBLE PRECISION NOT NULL,
+    memory_percent_last DOUBLE PRECISION NOT NULL,
+    memory_usage_min DOUBLE PRECISION NOT NULL,
+    memory_usage_avg DOUBLE PRECISION NOT NULL,
+    memory_usage_max DOUBLE PRECISION NOT NULL,
+    memory_usage_last DOUBLE PRECISION NOT NULL,
+    memory_limit_min DOUBLE PRECISION NOT NULL,
+    memory_limit_avg DOUBLE PRECISION NOT NULL,
+    memory_limit_max DOUBLE PRECISION NOT NULL,
+    memory_limit_last DOUBLE PRECISION NOT NULL,
+    memory_cache_min DOUBLE PRECISION NOT NULL,
+    memory_cache_avg DOUBLE PRECISION NOT NULL,
+    memory_cache_max DOUBLE PRECISION NOT NULL,
+    memory_cache_last DOUBLE PRECISION NOT NULL,
+    blkio_read_bytes_min DOUBLE PRECISION NOT NULL,
+    blkio_read_bytes_avg DOUBLE PRECISION NOT NULL,
+    blkio_read_bytes_max DOUBLE PRECISION NOT NULL,
+    blkio_read_bytes_last DOUBLE PRECISION NOT NULL,
+    blkio_write_bytes_min DOUBLE PRECISION NOT NULL,
+    blkio_write_bytes_avg DOUBLE PRECISION NOT NULL,
+    blkio_write_bytes_max DOUBLE PRECISION NOT NULL,
+    blkio_write_bytes_last DOUBLE PRECISION NOT NULL,
+    pids_current_min DOUBLE PRECISION NOT NULL,
+    pids_current_avg DOUBLE PRECISION NOT NULL,
+    pids_current_max DOUBLE PRECISION NOT NULL,
+    pids_current_last DOUBLE PRECISION NOT NULL,
+    cpu_throttled_min DOUBLE PRECISION NOT NULL,
+    cpu_throttled_avg DOUBLE PRECISION NOT NULL,
+    cpu_throttled_max DOUBLE PRECISION NOT NULL,
+    cpu_throttled_last DOUBLE PRECISION NOT NULL,
+    oom_kills_min DOUBLE PRECISION NOT NULL,
+    oom_kills_avg DOUBLE PRECISION NOT NULL,
+    oom_kills_max DOUBLE PRECISION NOT NULL,
+    oom_kills_last DOUBLE PRECISION NOT NULL,
+    PRIMARY KEY (container_id, bucket)
+);
+
+CREATE INDEX IF NOT EXISTS idx_container_usage_1m_bucket
+    ON container_usage_1m (bucket);
+
+CREATE TABLE IF NOT EXISTS container_usage_1h (
+    container_id TEXT NOT NULL,
+    bucket TIMESTAMPTZ NOT NULL,
+    samples INTEGER NOT NULL,
+    cpu_percent_min DOUBLE PRECISION NOT NULL,
+    cpu_percent_avg DOUBLE PRECISION NOT NULL,
+    cpu_percent_max DOUBLE PRECISION NOT NULL,
+    cpu_percent_last DOUBLE PRECISION NOT NULL,
+    memory_percent_min DOUBLE PRECISION NOT NULL,
+    memory_percent_avg DOUBLE PRECISION NOT NULL,
+    memory_percent_max DOUBLE PRECISION NOT NULL,
+    memory_percent_last DOUBLE PRECISION NOT NULL,
+    memory_usage_min DOUBLE PRECISION NOT NULL,
+    memory_usage_avg DOUBLE PRECISION NOT NULL,
+    memory_usage_max DOUBLE PRECISION NOT NULL,
+    memory_usage_last DOUBLE PRECISION NOT NULL,
+    memory_limit_min DOUBLE PRECISION NOT NULL,
+    memory_limit_avg DOUBLE PRECISION NOT NULL,
+    memory_limit_max DOUBLE PRECISION NOT NULL,
+    memory_limit_last DOUBLE PRECISION NOT NULL,
+    memory_cache_min DOUBLE PRECISION NOT NULL,
+    memory_cache_avg DOUBLE PRECISION NOT NULL,
+    memory_cache_max DOUBLE PRECISION NOT NULL,
+    memory_cache_last DOUBLE PRECISION NOT NULL,
+    blkio_read_bytes_min DOUBLE PRECISION NOT NULL,
+    blkio_read_bytes_avg DOUBLE PRECISION NOT NULL,
+    blkio_read_bytes_max DOUBLE PRECISION NOT NULL,
+    blkio_read_bytes_last DOUBLE PRECISION NOT NULL,
+    blkio_write_bytes_min DOUBLE PRECISION NOT NULL,
+    blkio_write_bytes_avg DOUBLE PRECISION NOT NULL,
+    blkio_write_bytes_max DOUBLE PRECISION NOT NULL,
+    blkio_write_bytes_last DOUBLE PRECISION NOT NULL,
+    pids_current_min DOUBLE PRECISION NOT NULL,
+    pids_current_avg DOUBLE PRECISION NOT NULL,
+    pids_current_max DOUBLE PRECISION NOT NULL,
+    pids_current_last DOUBLE PRECISION NOT NULL,
+    cpu_throttled_min DOUBLE PRECISION NOT NULL,
+    cpu_throttled_avg DOUBLE PRECISION NOT NULL,
+    cpu_throttled_max DOUBLE PRECISION NOT NULL,
+    cpu_throttled_last DOUBLE PRECISION NOT NULL,
+    oom_kills_min DOUBLE PRECISION NOT NULL,
+    oom_kills_avg DOUBLE PRECISION NOT NULL,
+    oom_kills_max DOUBLE PRECISION NOT NULL,
+    oom_kills_last DOUBLE PRECISION NOT NULL,
+    PRIMARY KEY (container_id, bucket)
+);
+
+CREATE INDEX IF NOT EXISTS idx_container_usage_1h_bucket
+    ON container_usage_1h (bucket);
+
+-- How far each resolution is rolled up, the buckets before rolled_up_to are complete
+CREATE TABLE IF NOT EXISTS usage_rollup_state (
+    resolution TEXT PRIMARY KEY,
+    rolled_up_to TIMESTAMPTZ NOT NULL
+);
+
+-- The rollups and the retention read and delete the raw samples by time
+CREATE INDEX IF NOT EXISTS idx_container_usage_time
+    ON container_usage (timestamp);
+
+CREATE INDEX IF NOT EXISTS idx_container_network_usage_time
+    ON container_network_usage (timestamp);
@@ -0,0 +1,95 @@
+package alerting
+
+import "time"
+
+// Alert states
+const (
+	StatePending  = "pending"
+	StateFiring   = "firing"
+	StateResolved = "resolved"
+)
+
+// Alert kinds
+const (
+	KindUsage   = "usage"
+	KindLog     = "log"
+	KindAbsence = "absence"
+)
+
+// maxSamples is how many matching log lines are attached to an alert
+const maxSamples = 5
+
+// Alert is a single occurrence of a rule matching a container, from the first
+// matching sample until it is resolved
+type Alert struct {
+	ID            int64     `json:"id"`
+	Fingerprint   string    `json:"fingerprint"`
+	Rule          string    `json:"rule"`
+	Kind          string    `json:"kind"`
+	Severity      string    `json:"severity"`
+	State         string    `json:"state"`
+	ContainerID   string    `json:"container_id"`
+	ContainerName string    `json:"container_name"`
+	Image         string    `json:"image"`
+	Value         float64   `json:"value"`
+	Summary       string    `json:"summary"`
+	Samples       []string  `json:"samples,omitempty"`
+	StartedAt     time.Time `json:"started_at"`
+	FiredAt       time.Time `json:"fired_at,omitempty"`
+	ResolvedAt    time.Time `json:"resolved_at,omitempty"`
+
+	// lastSeen is when the rule was last evaluated for the container
+	lastSeen time.Time
+}
+
+// ContainerInfo is what the rule selectors match against
+type ContainerInfo struct {
+	ID     string
+	Name   string
+	Image  string
+	Labels map[string]string
+}
+
+// LogEntry is a log line received from an agent, it is evaluated when it is received
+type LogEntry struct {
+	ContainerID   string
+	ContainerName string
+	Image         string
+	Line          string
+}
+
+// UsageSample is a usage stats sample of a container, keyed by metric name. It is
+// evaluated when it is received, not at the time the agent took it.
+type UsageSample struct {
+	ContainerID string
+	Values      map[string]float64
+}
+
+// usageMetrics are the metrics usage rules can use
+var usageMetrics = map[string]bool{
+	"cpu_percent":            true,
+	"memory_percent":         true,
+	"memory_usage":           true,
+	"memory_limit":           true,
+	"memory_cache":           true,
+	"pids_current":           true,
+	"pids_limit":             true,
+	"oom_kills":              true,
+	"cpu_throttled_periods":  true,
+	"cpu_throttled_time":     true,
+	"blkio_read_bytes":       true,
+	"blkio_write_bytes":      true,
+	"network_rx_bytes":       true,
+	"network_tx_bytes":       true,
+	"network_rx_errors":      true,
+	"network_tx_errors":      true,
+	"cpu_throttling_periods": true,
+}
+
+// Store persists alerts. An alert is identified by its fingerprint and start time,
+// saving it again updates the existing record and sets the alert ID.
+type Store interface {
+	SaveAlert(alert *Alert) error
+	// UnresolvedAlerts returns the alerts that were not resolved yet, oldest first
+	UnresolvedAlerts() ([]Alert, error)
+}
@@ -0,0 +1,283 @@
+package alerting
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// staleAfter is how long an active alert is kept without new samples before it is resolved,
+// so alerts of stopped containers don't fire forever
+const staleAfter = 10 * time.Minute
+
+// Engine evaluates the alert rules against incoming data and tracks active alerts
+type Engine struct {
+	mu         sync.RWMutex
+	rules      *Rules
+	store      Store
+	containers map[string]*ContainerInfo
+	active     map[string]*Alert
+	listeners  []func(Alert)
+
+	// logMu guards the log rule state, every log line takes it while mu is only read
+	// unless an alert changes. When both are needed mu is taken first.
+	logMu sync.Mutex
+	// logCounters counts the matches of each log rule per container
+	logCounters map[string]*logCounter
+	// sources are the containers that sent logs, for the absence rules
+	sources map[string]*logSource
+
+	// now is the clock of every alert transition. The server clock is used rather than the
+	// timestamps of the agents, so the clock skew of an agent doesn't fire or hold alerts.
+	now func() time.Time
+}
+
+// NewEngine creates an engine for the rules, persisting alerts in the store
+func NewEngine(rules *Rules, store Store) *Engine {
+	if rules == nil {
+		rules = &Rules{}
+	}
+	return &Engine{
+		rules:       rules,
+		store:       store,
+		containers:  make(map[string]*ContainerInfo),
+		active:      make(map[string]*Alert),
+		logCounters: make(map[string]*logCounter),
+		sources:     make(map[string]*logSource),
+		now:         time.Now,
+	}
+}
+
+// Restore loads the alerts left unresolved by the previous run, so a condition that is
+// still true continues the same alert instead of opening a second one. Alerts of rules
+// that no longer exist are resolved, as are older duplicates of the same alert.
+func (e *Engine) Restore() error {
+	if e.store == nil {
+		return nil
+	}
+	alerts, err := e.store.UnresolvedAlerts()
+	if err != nil {
+		return err
+	}
+	now := e.now()
+
+	var transitions []Alert
+	e.mu.Lock()
+	for i := range alerts {
+		alert := &alerts[i]
+		if !e.rules.has(alert.Kind, alert.Rule) {
+			alert.State = StateResolved
+			alert.ResolvedAt = now
+			alert.Summary += " (rule removed)"
+			transitions = append(transitions, *alert)
+			continue
+		}
+		if previous := e.active[alert.Fingerprint]; previous != nil {
+			previous.State = StateResolved
+			previous.ResolvedAt = now
+			previous.Summary += " (superseded)"
+			transitions = append(transitions, *previous)
+		}
+
+		// Usage alerts resolve when the samples stop, log alerts once their rate is
+		// evaluated again and absence alerts once the container logs again or is
+		// forgotten
+		alert.lastSeen = now
+		e.active[alert.Fingerprint] = alert
+		if alert.Kind == KindAbsence {
+			e.logMu.Lock()
+			if _, ok := e.sources[alert.ContainerID]; !ok {
+				e.sources[alert.ContainerID] = &logSource{
+					container: ContainerInfo{ID: alert.ContainerID, Name: alert.ContainerName, Image: alert.Image},
+					lastLog:   alert.StartedAt,
+				}
+			}
+			e.logMu.Unlock()
+		}
+	}
+	restored := len(e.active)
+	e.mu.Unlock()
+
+	if restored > 0 || len(transitions) > 0 {
+		slog.Info("Restored unresolved alerts", "active", restored, "resolved", len(transitions))
+	}
+	e.publish(transitions)
+	return nil
+}
+
+// Subscribe registers a function called for every alert that starts firing or resolves
+func (e *Engine) Subscribe(listener func(Alert)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.listeners = append(e.listeners, listener)
+}
+
+// UpdateContainers refreshes the container details the selectors match against
+func (e *Engine) UpdateContainers(containers []ContainerInfo) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i := range containers {
+		e.containers[containers[i].ID] = &containers[i]
+	}
+}
+
+// container returns the known details of a container, only the ID when it is unknown
+func (e *Engine) container(containerID string) *ContainerInfo {
+	if container, ok := e.containers[containerID]; ok {
+		return container
+	}
+	return &ContainerInfo{ID: containerID}
+}
+
+// ObserveUsage evaluates the usage rules against a sample
+func (e *Engine) ObserveUsage(sample *UsageSample) {
+	var transitions []Alert
+
+	e.mu.Lock()
+	now := e.now()
+	container := e.container(sample.ContainerID)
+	for _, rule := range e.rules.Usage {
+		if !rule.Selector.Matches(container) {
+			continue
+		}
+		value, ok := sample.Values[rule.metric]
+		if !ok {
+			continue
+		}
+
+		fingerprint := KindUsage + "/" + rule.Name + "/" + container.ID
+		alert := e.active[fingerprint]
+
+		if !compare(value, rule.operator, rule.threshold) {
+			if alert != nil {
+				delete(e.active, fingerprint)
+				if alert.State == StateFiring {
+					alert.State = StateResolved
+					alert.ResolvedAt = now
+					alert.Value = value
+					transitions = append(transitions, *alert)
+				}
+			}
+			continue
+		}
+
+		if alert == nil {
+			alert = &Alert{
+				Fingerprint:   fingerprint,
+				Rule:          rule.Name,
+				Kind:          KindUsage,
+				Severity:      rule.Severity,
+				State:         StatePending,
+				ContainerID:   container.ID,
+				ContainerName: container.Name,
+				Image:         container.Image,
+				StartedAt:     now,
+			}
+			e.active[fingerprint] = alert
+		}
+		alert.Value = value
+		alert.lastSeen = now
+		alert.Summary = fmt.Sprintf("%s is %.2f on %s (%s %s %g)",
+			rule.metric, value, containerLabel(container), rule.metric, rule.operator, rule.threshold)
+
+		if alert.State == StatePending && now.Sub(alert.StartedAt) >= time.Duration(rule.For) {
+			alert.State = StateFiring
+			alert.FiredAt = now
+			transitions = append(transitions, *alert)
+		}
+	}
+	e.mu.Unlock()
+
+	e.publish(transitions)
+}
+
+// Run periodically resolves alerts that stopped receiving data, re-evaluates log rates
+// and checks for absent logs until the context is cancelled
+func (e *Engine) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.sweep(e.now())
+		}
+	}
+}
+
+// sweep resolves usage alerts without recent data and evaluates the time based log rules
+func (e *Engine) sweep(now time.Time) {
+	var transitions []Alert
+
+	e.mu.Lock()
+	for fingerprint, alert := range e.active {
+		if alert.Kind != KindUsage || now.Sub(alert.lastSeen) < staleAfter {
+			continue
+		}
+		delete(e.active, fingerprint)
+		if alert.State == StateFiring {
+			alert.State = StateResolved
+			alert.ResolvedAt = now
+			alert.Summary += " (no data)"
+			transitions = append(transitions, *alert)
+		}
+	}
+	transitions = append(transitions, e.sweepLogs(now)...)
+	e.mu.Unlock()
+
+	e.publish(transitions)
+}
+
+// publish persists the state changes and notifies the listeners. Each alert is only
+// published when it starts firing and when it resolves, which deduplicates notifications.
+func (e *Engine) publish(transitions []Alert) {
+	if len(transitions) == 0 {
+		return
+	}
+
+	e.mu.RLock()
+	listeners := e.listeners
+	e.mu.RUnlock()
+
+	for _, alert := range transitions {
+		if e.store != nil {
+			if err := e.store.SaveAlert(&alert); err != nil {
+				slog.Error("Error saving alert", "fingerprint", alert.Fingerprint, "error", err)
+			}
+		}
+
+		slog.Info("Alert "+alert.State, "rule", alert.Rule, "container", alert.ContainerName, "summary", alert.Summary)
+		for _, listener := range listeners {
+			listener(alert)
+		}
+	}
+}
+
+// Active returns the pending and firing alerts
+func (e *Engine) Active() []Alert {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	alerts := make([]Alert, 0, len(e.active))
+	for _, alert := range e.active {
+		alerts = append(alerts, *alert)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		return alerts[i].StartedAt.Before(alerts[j].StartedAt)
+	})
+	return alerts
+}
+
+// containerLabel names a container in alert summaries
+func containerLabel(container *ContainerInfo) string {
+	if container.Name != "" {
+		return strings.TrimPrefix(container.Name, "/")
+	}
+	return container.ID
+}
@@ -0,0 +1,290 @@
+package alerting
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeStore keeps the saved alerts in memory
+type fakeStore struct {
+	unresolved []Alert
+	saved      []Alert
+}
+
+func (s *fakeStore) SaveAlert(alert *Alert) error {
+	s.saved = append(s.saved, *alert)
+	return nil
+}
+
+func (s *fakeStore) UnresolvedAlerts() ([]Alert, error) {
+	return s.unresolved, nil
+}
+
+// fakeClock is the clock of a test engine, moved forward by the tests
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) advance(d time.Duration) time.Time {
+	c.now = c.now.Add(d)
+	return c.now
+}
+
+// newTestEngine creates an engine on a fake clock, recording the published transitions
+func newTestEngine(t *testing.T, rules *Rules, store Store) (*Engine, *fakeClock, *[]Alert) {
+	t.Helper()
+	for _, rule := range rules.Usage {
+		if err := rule.validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, rule := range rules.Logs {
+		if err := rule.validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, rule := range rules.Absence {
+		if err := rule.validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	clock := &fakeClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	engine := NewEngine(rules, store)
+	engine.now = func() time.Time { return clock.now }
+
+	var published []Alert
+	engine.Subscribe(func(alert Alert) {
+		published = append(published, alert)
+	})
+	return engine, clock, &published
+}
+
+func cpuSample(value float64) *UsageSample {
+	return &UsageSample{ContainerID: "web", Values: map[string]float64{"cpu_percent": value}}
+}
+
+func TestObserveUsage(t *testing.T) {
+	// step is a sample observed after a delay, with the states published by it
+	type step struct {
+		after     time.Duration
+		value     float64
+		published []string
+		active    string
+	}
+	tests := []struct {
+		name  string
+		rule  UsageRule
+		steps []step
+	}{
+		{
+			name: "fires once the condition held for the duration",
+			rule: UsageRule{Name: "cpu", Condition: "cpu_percent > 90", For: Duration(time.Minute)},
+			steps: []step{
+				{value: 95, active: StatePending},
+				{after: 30 * time.Second, value: 97, active: StatePending},
+				{after: 30 * time.Second, value: 96, published: []string{StateFiring}, active: StateFiring},
+				{after: 30 * time.Second, value: 99, active: StateFiring},
+			},
+		},
+		{
+			name: "resolves when the condition stops holding",
+			rule: UsageRule{Name: "cpu", Condition: "cpu_percent > 90"},
+			steps: []step{
+				{value: 95, published: []string{StateFiring}, active: StateFiring},
+				{after: 10 * time.Second, value: 40, published: []string{StateResolved}},
+				{after: 10 * time.Second, value: 40},
+			},
+		},
+		{
+			name: "pending alert is dropped without a notification",
+			rule: UsageRule{Name: "cpu", Condition: "cpu_percent >= 90", For: Duration(time.Minute)},
+			steps: []step{
+				{value: 90, active: StatePending},
+				{after: 30 * time.Second, value: 89.9},
+				{after: 30 * time.Second, value: 95, active: StatePending},
+			},
+		},
+		{
+			name: "the duration starts again after a dip",
+			rule: UsageRule{Name: "cpu", Condition: "cpu_percent > 90", For: Duration(time.Minute)},
+			steps: []step{
+				{value: 95, active: StatePending},
+				{after: 50 * time.Second, value: 10},
+				{after: 10 * time.Second, value: 95, active: StatePending},
+				{after: 50 * time.Second, value: 95, active: StatePending},
+				{after: 10 * time.Second, value: 95, published: []string{StateFiring}, active: StateFiring},
+			},
+		},
+		{
+			name: "container not selected",
+			rule: UsageRule{Name: "cpu", Condition: "cpu_percent > 90", Selector: Selector{ContainerName: "db*"}},
+			steps: []step{
+				{value: 95},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := tt.rule
+			engine, clock, published := newTestEngine(t, &Rules{Usage: []*UsageRule{&rule}}, nil)
+			engine.UpdateContainers([]ContainerInfo{{ID: "web", Name: "web-1"}})
+
+			for i, step := range tt.steps {
+				clock.advance(step.after)
+				before := len(*published)
+				engine.ObserveUsage(cpuSample(step.value))
+
+				var states []string
+				for _, alert := range (*published)[before:] {
+					states = append(states, alert.State)
+				}
+				if strings.Join(states, ",") != strings.Join(step.published, ",") {
+					t.Errorf("step %d: published %v, want %v", i, states, step.published)
+				}
+
+				active := ""
+				if alerts := engine.Active(); len(alerts) > 0 {
+					active = alerts[0].State
+				}
+				if active != step.active {
+					t.Errorf("step %d: active alert is %q, want %q", i, active, step.active)
+				}
+			}
+		})
+	}
+}
+
+func TestObserveUsageTimes(t *testing.T) {
+	rule := &UsageRule{Name: "cpu", Condition: "cpu_percent > 90", For: Duration(time.Minute)}
+	engine, clock, published := newTestEngine(t, &Rules{Usage: []*UsageRule{rule}}, nil)
+
+	started := clock.now
+	engine.ObserveUsage(cpuSample(95))
+	fired := clock.advance(time.Minute)
+	engine.ObserveUsage(cpuSample(95))
+	resolved := clock.advance(time.Minute)
+	engine.ObserveUsage(cpuSample(10))
+
+	if len(*published) != 2 {
+		t.Fatalf("published %d transitions, want 2", len(*published))
+	}
+	firing, done := (*published)[0], (*published)[1]
+	if !firing.StartedAt.Equal(started) || !firing.FiredAt.Equal(fired) {
+		t.Errorf("firing alert started at %v and fired at %v, want %v and %v", firing.StartedAt, firing.FiredAt, started, fired)
+	}
+	if !done.ResolvedAt.Equal(resolved) || done.Value != 10 {
+		t.Errorf("resolved alert at %v with %v, want %v with 10", done.ResolvedAt, done.Value, resolved)
+	}
+}
+
+func TestSweepResolvesStaleUsageAlerts(t *testing.T) {
+	rules := &Rules{Usage: []*UsageRule{
+		{Name: "cpu", Condition: "cpu_percent > 90"},
+		{Name: "cpu-sustained", Condition: "cpu_percent > 90", For: Duration(time.Hour)},
+	}}
+	engine, clock, published := newTestEngine(t, rules, nil)
+
+	engine.ObserveUsage(cpuSample(95))
+	if len(*published) != 1 || len(engine.Active()) != 2 {
+		t.Fatalf("published %d, active %d, want a firing and a pending alert", len(*published), len(engine.Active()))
+	}
+
+	engine.sweep(clock.advance(staleAfter - time.Second))
+	if len(engine.Active()) != 2 {
+		t.Fatalf("alerts resolved before %v without data", staleAfter)
+	}
+
+	// The pending alert is dropped silently, the firing one resolves
+	engine.sweep(clock.advance(time.Second))
+	if len(engine.Active()) != 0 {
+		t.Fatalf("active = %+v after %v without data, want none", engine.Active(), staleAfter)
+	}
+	if len(*published) != 2 {
+		t.Fatalf("published %d transitions, want the firing and the resolved one", len(*published))
+	}
+	if resolved := (*published)[1]; resolved.Rule != "cpu" || resolved.State != StateResolved || !strings.HasSuffix(resolved.Summary, "(no data)") {
+		t.Errorf("resolved alert = %+v", resolved)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	rules := &Rules{
+		Usage:   []*UsageRule{{Name: "cpu", Condition: "cpu_percent > 90"}},
+		Absence: []*AbsenceRule{{Name: "silent", For: Duration(10 * time.Minute)}},
+	}
+	startedAt := time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC)
+	store := &fakeStore{unresolved: []Alert{
+		{ID: 1, Fingerprint: "usage/cpu/web", Rule: "cpu", Kind: KindUsage, State: StateFiring, ContainerID: "web", StartedAt: startedAt},
+		{ID: 2, Fingerprint: "usage/cpu/web", Rule: "cpu", Kind: KindUsage, State: StateFiring, ContainerID: "web", StartedAt: startedAt.Add(time.Minute)},
+		{ID: 3, Fingerprint: "usage/memory/web", Rule: "memory", Kind: KindUsage, State: StateFiring, ContainerID: "web", StartedAt: startedAt},
+		{ID: 4, Fingerprint: "absence/silent/db", Rule: "silent", Kind: KindAbsence, State: StateFiring, ContainerID: "db", ContainerName: "db", StartedAt: startedAt},
+	}}
+	engine, clock, published := newTestEngine(t, rules, store)
+
+	if err := engine.Restore(); err != nil {
+		t.Fatal(err)
+	}
+
+	resolved := map[int64]string{}
+	for _, alert := range *published {
+		if alert.State != StateResolved || !alert.ResolvedAt.Equal(clock.now) {
+			t.Errorf("published %+v, want only resolutions at %v", alert, clock.now)
+		}
+		resolved[alert.ID] = alert.Summary
+	}
+	if len(resolved) != 2 || !strings.HasSuffix(resolved[1], "(superseded)") || !strings.HasSuffix(resolved[3], "(rule removed)") {
+		t.Fatalf("resolved %v, want the older duplicate and the alert of the removed rule", resolved)
+	}
+
+	active := engine.Active()
+	if len(active) != 2 || active[0].ID != 4 || active[1].ID != 2 {
+		t.Fatalf("active = %+v, want the restored absence and cpu alerts", active)
+	}
+
+	// A condition still true continues the restored alert without notifying again
+	before := len(*published)
+	engine.ObserveUsage(cpuSample(95))
+	if len(*published) != before {
+		t.Errorf("restored alert was published again: %+v", (*published)[before:])
+	}
+
+	// and it resolves as the same alert
+	clock.advance(time.Minute)
+	engine.ObserveUsage(cpuSample(10))
+	if last := (*published)[len(*published)-1]; last.ID != 2 || last.State != StateResolved || !last.StartedAt.Equal(startedAt.Add(time.Minute)) {
+		t.Errorf("resolution = %+v, want the restored alert 2", last)
+	}
+
+	// The restored absence alert resolves once the container logs again
+	engine.ObserveLog(&LogEntry{ContainerID: "db", Line: "ready"})
+	if last := (*published)[len(*published)-1]; last.ID != 4 || last.State != StateResolved {
+		t.Errorf("resolution = %+v, want the restored absence alert 4", last)
+	}
+}
+
+func TestRestoredUsageAlertResolvesWithoutData(t *testing.T) {
+	rules := &Rules{Usage: []*UsageRule{{Name: "cpu", Condition: "cpu_percent > 90"}}}
+	store := &fakeStore{unresolved: []Alert{
+		{ID: 1, Fingerprint: "usage/cpu/web", Rule: "cpu", Kind: KindUsage, State: StateFiring, ContainerID: "web"},
+	}}
+	engine, clock, published := newTestEngine(t, rules, store)
+	if err := engine.Restore(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The restored alert waits for samples as long as any other
+	engine.sweep(clock.advance(staleAfter - time.Second))
+	if len(*published) != 0 {
+		t.Fatalf("restored alert resolved after %v", staleAfter-time.Second)
+	}
+	engine.sweep(clock.advance(time.Second))
+	if len(*published) != 1 || (*published)[0].ID != 1 || (*published)[0].State != StateResolved {
+		t.Fatalf("published %+v, want the restored alert resolved", *published)
+	}
+	if len(store.saved) != 1 || store.saved[0].State != StateResolved {
+		t.Errorf("saved %+v, want the resolution", store.saved)
+	}
+}
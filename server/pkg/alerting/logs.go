@@ -0,0 +1,348 @@
+package alerting
+
+import (
+	"fmt"
+	"time"
+)
+
+// sourceExpiry is how long a container that stopped logging is remembered for absence rules
+const sourceExpiry = 24 * time.Hour
+
+// logCounter counts matching log lines in one second buckets over a sliding window
+type logCounter struct {
+	buckets []logBucket
+	total   int
+	samples []logSample
+}
+
+// logSample is a matching line attached to the alert while it is in the window
+type logSample struct {
+	second int64
+	line   string
+}
+
+type logBucket struct {
+	second int64
+	count  int
+}
+
+// add records a matching line
+func (c *logCounter) add(timestamp time.Time, line string) {
+	second := timestamp.Unix()
+	if n := len(c.buckets); n > 0 && c.buckets[n-1].second == second {
+		c.buckets[n-1].count++
+	} else {
+		c.buckets = append(c.buckets, logBucket{second: second, count: 1})
+	}
+	c.total++
+
+	c.samples = append(c.samples, logSample{second: second, line: line})
+	if len(c.samples) > maxSamples {
+		c.samples = c.samples[len(c.samples)-maxSamples:]
+	}
+}
+
+// prune drops the buckets and the samples that fell out of the window
+func (c *logCounter) prune(now time.Time, window time.Duration) {
+	oldest := now.Add(-window).Unix()
+	dropped := 0
+	for dropped < len(c.buckets) && c.buckets[dropped].second <= oldest {
+		c.total -= c.buckets[dropped].count
+		dropped++
+	}
+	c.buckets = c.buckets[dropped:]
+
+	dropped = 0
+	for dropped < len(c.samples) && c.samples[dropped].second <= oldest {
+		dropped++
+	}
+	c.samples = c.samples[dropped:]
+}
+
+// lines returns the samples in the window
+func (c *logCounter) lines() []string {
+	lines := make([]string, len(c.samples))
+	for i, sample := range c.samples {
+		lines[i] = sample.line
+	}
+	return lines
+}
+
+// logSource is a container that sent logs, tracked for absence rules
+type logSource struct {
+	container ContainerInfo
+	lastLog   time.Time
+	// stopped is set once the container stopped, the lines it wrote before are still
+	// received afterwards
+	stopped bool
+}
+
+// ObserveLog evaluates the log rules against a log line and clears absence alerts
+// of the container it came from
+func (e *Engine) ObserveLog(entry *LogEntry) {
+	now := e.now()
+
+	// Every line goes through here, the shared state is only read until an alert changes
+	e.mu.RLock()
+	container := *e.container(entry.ContainerID)
+	absent := e.hasAbsenceAlert(container.ID)
+	e.mu.RUnlock()
+	if container.Name == "" {
+		container.Name = entry.ContainerName
+	}
+	if container.Image == "" {
+		container.Image = entry.Image
+	}
+
+	var matched []*LogRule
+	for _, rule := range e.rules.Logs {
+		if rule.Selector.Matches(&container) && rule.matches(entry.Line) {
+			matched = append(matched, rule)
+		}
+	}
+
+	// exceeded holds the rules over their threshold, with the count and samples in the window
+	type exceeded struct {
+		rule    *LogRule
+		total   int
+		samples []string
+	}
+	var over []exceeded
+	e.logMu.Lock()
+	if source := e.sources[container.ID]; source != nil {
+		source.container = container
+		source.lastLog = now
+	} else {
+		e.sources[container.ID] = &logSource{container: container, lastLog: now}
+	}
+	for _, rule := range matched {
+		fingerprint := KindLog + "/" + rule.Name + "/" + container.ID
+		counter := e.logCounters[fingerprint]
+		if counter == nil {
+			counter = &logCounter{}
+			e.logCounters[fingerprint] = counter
+		}
+		counter.add(now, entry.Line)
+		counter.prune(now, time.Duration(rule.Window))
+		if counter.total > rule.Threshold {
+			over = append(over, exceeded{rule: rule, total: counter.total, samples: counter.lines()})
+		}
+	}
+	e.logMu.Unlock()
+
+	if !absent && len(over) == 0 {
+		return
+	}
+
+	e.mu.Lock()
+	// Logs are flowing again
+	transitions := e.resolveAbsence(container.ID, now, "")
+
+	for _, match := range over {
+		rule := match.rule
+		fingerprint := KindLog + "/" + rule.Name + "/" + container.ID
+		alert := e.active[fingerprint]
+		fired := alert == nil
+		if fired {
+			alert = &Alert{
+				Fingerprint:   fingerprint,
+				Rule:          rule.Name,
+				Kind:          KindLog,
+				Severity:      rule.Severity,
+				State:         StateFiring,
+				ContainerID:   container.ID,
+				ContainerName: container.Name,
+				Image:         container.Image,
+				StartedAt:     now,
+				FiredAt:       now,
+			}
+			e.active[fingerprint] = alert
+		}
+		alert.Value = float64(match.total)
+		alert.lastSeen = now
+		alert.Samples = match.samples
+		alert.Summary = fmt.Sprintf("%d log lines matching %s in %v on %s",
+			match.total, rule.describe(), time.Duration(rule.Window), containerLabel(&container))
+
+		// Only the first time the rate is exceeded is published, later matches update the alert
+		if fired {
+			transitions = append(transitions, *alert)
+		}
+	}
+	e.mu.Unlock()
+
+	e.publish(transitions)
+}
+
+// hasAbsenceAlert reports whether an absence alert is active for the container.
+// It must be called with mu held.
+func (e *Engine) hasAbsenceAlert(containerID string) bool {
+	for _, rule := range e.rules.Absence {
+		if e.active[KindAbsence+"/"+rule.Name+"/"+containerID] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// ObserveEvent follows the lifecycle events of a container. The absence rules don't fire
+// for a container that was stopped until it starts again, and a removed container is
+// forgotten. Either resolves the absence alerts of the container.
+func (e *Engine) ObserveEvent(containerID, action string) {
+	var note string
+	e.mu.Lock()
+	e.logMu.Lock()
+	source := e.sources[containerID]
+	switch action {
+	case "start":
+		if source != nil {
+			source.stopped = false
+			source.lastLog = e.now()
+		}
+	case "stop", "die":
+		if source != nil {
+			source.stopped = true
+		}
+		note = " (container stopped)"
+	case "destroy":
+		delete(e.sources, containerID)
+		note = " (container removed)"
+	}
+	e.logMu.Unlock()
+
+	var transitions []Alert
+	if note != "" {
+		transitions = e.resolveAbsence(containerID, e.now(), note)
+	}
+	e.mu.Unlock()
+
+	e.publish(transitions)
+}
+
+// resolveAbsence resolves the absence alerts of a container, adding note to the summary.
+// It must be called with mu held.
+func (e *Engine) resolveAbsence(containerID string, now time.Time, note string) []Alert {
+	var transitions []Alert
+	for _, rule := range e.rules.Absence {
+		fingerprint := KindAbsence + "/" + rule.Name + "/" + containerID
+		if alert := e.active[fingerprint]; alert != nil {
+			delete(e.active, fingerprint)
+			alert.State = StateResolved
+			alert.ResolvedAt = now
+			alert.Summary += note
+			transitions = append(transitions, *alert)
+		}
+	}
+	return transitions
+}
+
+// describe names the pattern of a log rule in alert summaries
+func (r *LogRule) describe() string {
+	if r.Regex != "" {
+		return fmt.Sprintf("/%s/", r.Regex)
+	}
+	return fmt.Sprintf("%q", r.Contains)
+}
+
+// sweepLogs resolves log alerts whose rate dropped and fires absence alerts.
+// It must be called with mu held.
+func (e *Engine) sweepLogs(now time.Time) []Alert {
+	var transitions []Alert
+
+	e.logMu.Lock()
+	defer e.logMu.Unlock()
+
+	rules := make(map[string]*LogRule, len(e.rules.Logs))
+	for _, rule := range e.rules.Logs {
+		rules[rule.Name] = rule
+	}
+
+	for fingerprint, counter := range e.logCounters {
+		alert := e.active[fingerprint]
+		var rule *LogRule
+		if alert != nil {
+			rule = rules[alert.Rule]
+		}
+		if rule == nil {
+			// No alert to resolve, drop the counter once it is empty
+			counter.prune(now, maxLogWindow(e.rules.Logs))
+			if counter.total == 0 {
+				delete(e.logCounters, fingerprint)
+			}
+			continue
+		}
+
+		counter.prune(now, time.Duration(rule.Window))
+		if counter.total <= rule.Threshold {
+			delete(e.active, fingerprint)
+			alert.State = StateResolved
+			alert.ResolvedAt = now
+			alert.Value = float64(counter.total)
+			transitions = append(transitions, *alert)
+		}
+		if counter.total == 0 {
+			delete(e.logCounters, fingerprint)
+		}
+	}
+
+	// Log alerts restored from the previous run have no counter until a line matches
+	// again, they resolve once a whole window passed without one
+	for fingerprint, alert := range e.active {
+		if alert.Kind != KindLog || e.logCounters[fingerprint] != nil {
+			continue
+		}
+		if rule := rules[alert.Rule]; rule != nil && now.Sub(alert.lastSeen) < time.Duration(rule.Window) {
+			continue
+		}
+		delete(e.active, fingerprint)
+		alert.State = StateResolved
+		alert.ResolvedAt = now
+		alert.Value = 0
+		transitions = append(transitions, *alert)
+	}
+
+	for containerID, source := range e.sources {
+		silence := now.Sub(source.lastLog)
+		for _, rule := range e.rules.Absence {
+			fingerprint := KindAbsence + "/" + rule.Name + "/" + containerID
+			if source.stopped || silence < time.Duration(rule.For) || e.active[fingerprint] != nil || !rule.Selector.Matches(&source.container) {
+				continue
+			}
+			alert := &Alert{
+				Fingerprint:   fingerprint,
+				Rule:          rule.Name,
+				Kind:          KindAbsence,
+				Severity:      rule.Severity,
+				State:         StateFiring,
+				ContainerID:   containerID,
+				ContainerName: source.container.Name,
+				Image:         source.container.Image,
+				Value:         silence.Seconds(),
+				Summary: fmt.Sprintf("no logs from %s for %v",
+					containerLabel(&source.container), silence.Truncate(time.Second)),
+				StartedAt: now,
+				FiredAt:   now,
+			}
+			e.active[fingerprint] = alert
+			transitions = append(transitions, *alert)
+		}
+
+		// Forget containers that have been silent for a long time, in case their stop
+		// event was missed
+		if silence >= sourceExpiry {
+			delete(e.sources, containerID)
+			transitions = append(transitions, e.resolveAbsence(containerID, now, " (container forgotten)")...)
+		}
+	}
+
+	return transitions
+}
+
+// maxLogWindow returns the longest window of the log rules
+func maxLogWindow(rules []*LogRule) time.Duration {
+	window := defaultLogWindow
+	for _, rule := range rules {
+		window = max(window, time.Duration(rule.Window))
+	}
+	return window
+}
@@ -0,0 +1,248 @@
+package alerting
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func logLine(line string) *LogEntry {
+	return &LogEntry{ContainerID: "web", ContainerName: "web-1", Image: "nginx:1.25", Line: line}
+}
+
+func TestLogRuleMatches(t *testing.T) {
+	tests := []struct {
+		name string
+		rule LogRule
+		line string
+		want bool
+	}{
+		{name: "contains", rule: LogRule{Name: "errors", Contains: "ERROR"}, line: "12:00 ERROR db timeout", want: true},
+		{name: "contains is case sensitive", rule: LogRule{Name: "errors", Contains: "ERROR"}, line: "12:00 error db timeout"},
+		{name: "regex", rule: LogRule{Name: "5xx", Regex: `status=5\d\d`}, line: "GET / status=503", want: true},
+		{name: "regex no match", rule: LogRule{Name: "5xx", Regex: `status=5\d\d`}, line: "GET / status=404"},
+		{name: "contains and regex", rule: LogRule{Name: "slow", Contains: "GET", Regex: `took \d{4,}ms`}, line: "GET / took 2300ms", want: true},
+		{name: "contains and regex, only the regex matches", rule: LogRule{Name: "slow", Contains: "GET", Regex: `took \d{4,}ms`}, line: "POST / took 2300ms"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := tt.rule
+			if err := rule.validate(); err != nil {
+				t.Fatal(err)
+			}
+			if got := rule.matches(tt.line); got != tt.want {
+				t.Errorf("matches(%q) = %v, want %v", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogRateRule(t *testing.T) {
+	rule := &LogRule{Name: "errors", Contains: "ERROR", Threshold: 2, Window: Duration(time.Minute)}
+	engine, clock, published := newTestEngine(t, &Rules{Logs: []*LogRule{rule}}, nil)
+
+	// Two matches are within the threshold, lines that don't match don't count
+	engine.ObserveLog(logLine("ERROR one"))
+	clock.advance(10 * time.Second)
+	engine.ObserveLog(logLine("INFO fine"))
+	engine.ObserveLog(logLine("ERROR two"))
+	if len(*published) != 0 {
+		t.Fatalf("fired at the threshold: %+v", *published)
+	}
+
+	// The third match in the window fires once, later ones update the alert
+	clock.advance(10 * time.Second)
+	engine.ObserveLog(logLine("ERROR three"))
+	engine.ObserveLog(logLine("ERROR four"))
+	if len(*published) != 1 || (*published)[0].State != StateFiring {
+		t.Fatalf("published %+v, want one firing alert", *published)
+	}
+	active := engine.Active()
+	if len(active) != 1 || active[0].Value != 4 {
+		t.Fatalf("active = %+v, want the alert with 4 lines", active)
+	}
+	if got := strings.Join(active[0].Samples, ","); got != "ERROR one,ERROR two,ERROR three,ERROR four" {
+		t.Errorf("samples = %s", got)
+	}
+
+	// The rate is evaluated again by the sweep, the alert holds while the lines are in the window
+	engine.sweep(clock.advance(30 * time.Second))
+	if len(*published) != 1 {
+		t.Fatalf("resolved while 4 lines are in the window")
+	}
+	engine.sweep(clock.advance(10 * time.Second))
+	if len(*published) != 1 {
+		t.Fatalf("resolved while 3 lines are in the window")
+	}
+	engine.sweep(clock.advance(10 * time.Second))
+	if len(*published) != 2 || (*published)[1].State != StateResolved || (*published)[1].Value != 2 {
+		t.Fatalf("published %+v, want the alert resolved with 2 lines in the window", *published)
+	}
+
+	// The samples of the previous alert are gone once they leave the window
+	engine.sweep(clock.advance(time.Minute))
+	rule.Threshold = 0
+	engine.ObserveLog(logLine("ERROR five"))
+	if last := (*published)[len(*published)-1]; strings.Join(last.Samples, ",") != "ERROR five" {
+		t.Errorf("samples = %v, want only the new line", last.Samples)
+	}
+}
+
+func TestLogRuleSelector(t *testing.T) {
+	rule := &LogRule{Name: "errors", Contains: "ERROR", Selector: Selector{Image: "postgres:*"}}
+	engine, _, published := newTestEngine(t, &Rules{Logs: []*LogRule{rule}}, nil)
+
+	engine.ObserveLog(logLine("ERROR from nginx"))
+	engine.ObserveLog(&LogEntry{ContainerID: "db", ContainerName: "db", Image: "postgres:16", Line: "ERROR from postgres"})
+
+	if len(*published) != 1 || (*published)[0].ContainerID != "db" {
+		t.Fatalf("published %+v, want only the postgres alert", *published)
+	}
+}
+
+func TestAbsenceRule(t *testing.T) {
+	const silence = 10 * time.Minute
+	tests := []struct {
+		name string
+		// event is the lifecycle event received after the last line, if any
+		event string
+		// then runs after the container has been silent for the duration
+		then      func(engine *Engine, clock *fakeClock)
+		wantFired bool
+		want      []string
+	}{
+		{
+			name:      "fires after the silence",
+			wantFired: true,
+			want:      []string{StateFiring},
+		},
+		{
+			name:      "resolves when the container logs again",
+			wantFired: true,
+			then: func(engine *Engine, clock *fakeClock) {
+				engine.ObserveLog(logLine("back"))
+			},
+			want: []string{StateFiring, StateResolved},
+		},
+		{
+			name:  "stopped container doesn't fire",
+			event: "stop",
+		},
+		{
+			name:  "lines received after the stop don't bring the container back",
+			event: "die",
+			then: func(engine *Engine, clock *fakeClock) {
+				engine.ObserveLog(logLine("last line"))
+				engine.sweep(clock.advance(2 * silence))
+			},
+		},
+		{
+			name:  "restarted container fires again",
+			event: "stop",
+			then: func(engine *Engine, clock *fakeClock) {
+				engine.ObserveEvent("web", "start")
+				engine.sweep(clock.advance(silence))
+			},
+			want: []string{StateFiring},
+		},
+		{
+			name:  "removed container is forgotten",
+			event: "destroy",
+			then: func(engine *Engine, clock *fakeClock) {
+				engine.sweep(clock.advance(sourceExpiry))
+			},
+		},
+		{
+			name:      "stop resolves the alert",
+			wantFired: true,
+			then: func(engine *Engine, clock *fakeClock) {
+				engine.ObserveEvent("web", "stop")
+			},
+			want: []string{StateFiring, StateResolved},
+		},
+		{
+			name:      "forgotten after a long silence",
+			wantFired: true,
+			then: func(engine *Engine, clock *fakeClock) {
+				engine.sweep(clock.advance(sourceExpiry))
+			},
+			want: []string{StateFiring, StateResolved},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := &AbsenceRule{Name: "silent", For: Duration(silence)}
+			engine, clock, published := newTestEngine(t, &Rules{Absence: []*AbsenceRule{rule}}, nil)
+
+			engine.ObserveLog(logLine("hello"))
+			if tt.event != "" {
+				engine.ObserveEvent("web", tt.event)
+			}
+			engine.sweep(clock.advance(silence - time.Second))
+			if len(*published) != 0 {
+				t.Fatalf("fired before %v of silence", silence)
+			}
+			engine.sweep(clock.advance(time.Second))
+			if fired := len(*published) == 1; fired != tt.wantFired {
+				t.Fatalf("fired = %v after %v of silence, want %v", fired, silence, tt.wantFired)
+			}
+			if tt.then != nil {
+				tt.then(engine, clock)
+			}
+
+			var states []string
+			for _, alert := range *published {
+				states = append(states, alert.State)
+			}
+			if strings.Join(states, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("published %v, want %v", states, tt.want)
+			}
+		})
+	}
+}
+
+func TestObserveLogConcurrently(t *testing.T) {
+	rules := &Rules{
+		Logs:    []*LogRule{{Name: "errors", Contains: "ERROR", Threshold: 10}},
+		Absence: []*AbsenceRule{{Name: "silent", For: Duration(time.Minute)}},
+	}
+	engine, _, _ := newTestEngine(t, rules, nil)
+	clock := time.Now()
+	var clockMu sync.Mutex
+	engine.now = func() time.Time {
+		clockMu.Lock()
+		defer clockMu.Unlock()
+		clock = clock.Add(time.Millisecond)
+		return clock
+	}
+
+	var wg sync.WaitGroup
+	for i := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 500 {
+				engine.ObserveLog(&LogEntry{ContainerID: fmt.Sprintf("c%d", i), Line: fmt.Sprintf("ERROR %d", j)})
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range 100 {
+			engine.sweep(engine.now())
+			engine.ObserveEvent("c0", "die")
+			engine.ObserveEvent("c0", "start")
+			engine.Active()
+		}
+	}()
+	wg.Wait()
+
+	if active := engine.Active(); len(active) < 3 {
+		t.Errorf("active = %d alerts, want at least the ones of the containers still logging", len(active))
+	}
+}
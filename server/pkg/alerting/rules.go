@@ -0,0 +1,283 @@
+package alerting
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Severity levels for alert rules
+const (
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Selector picks the containers a rule applies to. Empty fields match everything,
+// names and images are glob patterns.
+type Selector struct {
+	ContainerName string            `json:"container_name,omitempty"`
+	Image         string            `json:"image,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+}
+
+// Matches reports whether the container matches the selector
+func (s *Selector) Matches(container *ContainerInfo) bool {
+	if s.ContainerName != "" && !globMatch(s.ContainerName, container.Name) {
+		return false
+	}
+	if s.Image != "" && !globMatch(s.Image, container.Image) {
+		return false
+	}
+	for key, value := range s.Labels {
+		actual, ok := container.Labels[key]
+		if !ok || (value != "*" && actual != value) {
+			return false
+		}
+	}
+	return true
+}
+
+// globMatch matches a glob pattern, an invalid pattern only matches itself
+func globMatch(pattern, value string) bool {
+	matched, err := path.Match(pattern, value)
+	if err != nil {
+		return pattern == value
+	}
+	return matched
+}
+
+// UsageRule fires when a usage metric crosses a threshold for a duration,
+// e.g. "cpu_percent > 90" for 5m
+type UsageRule struct {
+	Name      string   `json:"name"`
+	Condition string   `json:"condition"`
+	For       Duration `json:"for"`
+	Severity  string   `json:"severity"`
+	Selector  Selector `json:"selector"`
+
+	metric    string
+	operator  string
+	threshold float64
+}
+
+// LogRule fires when more than Threshold log lines match within Window, e.g. more than
+// 50 lines containing "ERROR" per minute. With the default threshold of 0 any match fires.
+type LogRule struct {
+	Name      string   `json:"name"`
+	Contains  string   `json:"contains,omitempty"`
+	Regex     string   `json:"regex,omitempty"`
+	Threshold int      `json:"threshold"`
+	Window    Duration `json:"window"`
+	Severity  string   `json:"severity"`
+	Selector  Selector `json:"selector"`
+
+	pattern *regexp.Regexp
+}
+
+// AbsenceRule fires when a container that was logging has not sent a log line for the duration
+type AbsenceRule struct {
+	Name     string   `json:"name"`
+	For      Duration `json:"for"`
+	Severity string   `json:"severity"`
+	Selector Selector `json:"selector"`
+}
+
+// Rules is the content of the alert rules file
+type Rules struct {
+	Usage   []*UsageRule   `json:"usage"`
+	Logs    []*LogRule     `json:"logs"`
+	Absence []*AbsenceRule `json:"absence"`
+}
+
+// has reports whether a rule of the kind exists
+func (r *Rules) has(kind, name string) bool {
+	switch kind {
+	case KindUsage:
+		for _, rule := range r.Usage {
+			if rule.Name == name {
+				return true
+			}
+		}
+	case KindLog:
+		for _, rule := range r.Logs {
+			if rule.Name == name {
+				return true
+			}
+		}
+	case KindAbsence:
+		for _, rule := range r.Absence {
+			if rule.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Duration is a time.Duration read from a string such as "5m"
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("duration must be a string like \"5m\": %v", err)
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+var operators = []string{">=", "<=", "==", "!=", ">", "<"}
+
+// parseCondition splits a condition like "memory_percent >= 95" into its parts
+func parseCondition(condition string) (string, string, float64, error) {
+	for _, operator := range operators {
+		metric, value, found := strings.Cut(condition, operator)
+		if !found {
+			continue
+		}
+		threshold, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return "", "", 0, fmt.Errorf("invalid threshold in condition %q: %v", condition, err)
+		}
+		return strings.TrimSpace(metric), operator, threshold, nil
+	}
+	return "", "", 0, fmt.Errorf("condition %q has no comparison operator", condition)
+}
+
+// compare applies the operator to the value and the threshold
+func compare(value float64, operator string, threshold float64) bool {
+	switch operator {
+	case ">":
+		return value > threshold
+	case ">=":
+		return value >= threshold
+	case "<":
+		return value < threshold
+	case "<=":
+		return value <= threshold
+	case "==":
+		return value == threshold
+	case "!=":
+		return value != threshold
+	}
+	return false
+}
+
+// validate parses the condition and fills in defaults
+func (r *UsageRule) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("usage rule with condition %q has no name", r.Condition)
+	}
+	metric, operator, threshold, err := parseCondition(r.Condition)
+	if err != nil {
+		return fmt.Errorf("rule %s: %v", r.Name, err)
+	}
+	if _, ok := usageMetrics[metric]; !ok {
+		return fmt.Errorf("rule %s: unknown metric %q", r.Name, metric)
+	}
+	if r.Severity == "" {
+		r.Severity = SeverityWarning
+	}
+	r.metric, r.operator, r.threshold = metric, operator, threshold
+	return nil
+}
+
+// defaultLogWindow is the window of log rules that don't set one
+const defaultLogWindow = time.Minute
+
+// validate compiles the pattern and fills in defaults
+func (r *LogRule) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("log rule has no name")
+	}
+	if r.Contains == "" && r.Regex == "" {
+		return fmt.Errorf("rule %s: needs contains or regex", r.Name)
+	}
+	if r.Regex != "" {
+		pattern, err := regexp.Compile(r.Regex)
+		if err != nil {
+			return fmt.Errorf("rule %s: invalid regex: %v", r.Name, err)
+		}
+		r.pattern = pattern
+	}
+	if r.Threshold < 0 {
+		return fmt.Errorf("rule %s: threshold must not be negative", r.Name)
+	}
+	if r.Window <= 0 {
+		r.Window = Duration(defaultLogWindow)
+	}
+	if r.Severity == "" {
+		r.Severity = SeverityWarning
+	}
+	return nil
+}
+
+// matches reports whether the log line matches the rule
+func (r *LogRule) matches(line string) bool {
+	if r.Contains != "" && !strings.Contains(line, r.Contains) {
+		return false
+	}
+	if r.pattern != nil && !r.pattern.MatchString(line) {
+		return false
+	}
+	return true
+}
+
+// validate fills in defaults
+func (r *AbsenceRule) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("absence rule has no name")
+	}
+	if r.For <= 0 {
+		return fmt.Errorf("rule %s: needs a positive for duration", r.Name)
+	}
+	if r.Severity == "" {
+		r.Severity = SeverityWarning
+	}
+	return nil
+}
+
+// LoadRules reads and validates the alert rules from a JSON file
+func LoadRules(filename string) (*Rules, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read alert rules: %v", err)
+	}
+
+	var rules Rules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse alert rules: %v", err)
+	}
+
+	for _, rule := range rules.Usage {
+		if err := rule.validate(); err != nil {
+			return nil, err
+		}
+	}
+	for _, rule := range rules.Logs {
+		if err := rule.validate(); err != nil {
+			return nil, err
+		}
+	}
+	for _, rule := range rules.Absence {
+		if err := rule.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return &rules, nil
+}
@@ -0,0 +1,94 @@
+// Package compression registers the gRPC compressors supported between the agent and the
+// server. gzip comes with gRPC, zstd compresses log lines better for less CPU. The agent
+// and the server are separate modules, each has a copy of this package.
+package compression
+
+import (
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/grpc/encoding"
+	_ "google.golang.org/grpc/encoding/gzip"
+)
+
+// Zstd is the name of the zstd compressor
+const Zstd = "zstd"
+
+// maxDecodedSize bounds the memory a single decompressed message can use
+const maxDecodedSize = 64 << 20
+
+func init() {
+	encoding.RegisterCompressor(&zstdCompressor{})
+}
+
+// zstdCompressor implements encoding.Compressor, the encoders and decoders are pooled
+// since gRPC compresses every message on its own
+type zstdCompressor struct {
+	encoders sync.Pool
+	decoders sync.Pool
+}
+
+type zstdWriter struct {
+	*zstd.Encoder
+	pool *sync.Pool
+}
+
+// Close flushes the message and returns the encoder to the pool
+func (w *zstdWriter) Close() error {
+	defer w.pool.Put(w)
+	return w.Encoder.Close()
+}
+
+type zstdReader struct {
+	*zstd.Decoder
+	pool *sync.Pool
+}
+
+// Read returns the decoder to the pool once the message is read
+func (r *zstdReader) Read(p []byte) (int, error) {
+	n, err := r.Decoder.Read(p)
+	if err == io.EOF {
+		r.Decoder.Reset(nil)
+		r.pool.Put(r)
+	}
+	return n, err
+}
+
+func (c *zstdCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	if writer, ok := c.encoders.Get().(*zstdWriter); ok {
+		writer.Reset(w)
+		return writer, nil
+	}
+
+	encoder, err := zstd.NewWriter(w,
+		zstd.WithEncoderLevel(zstd.SpeedFastest),
+		zstd.WithEncoderConcurrency(1),
+		zstd.WithLowerEncoderMem(true))
+	if err != nil {
+		return nil, err
+	}
+	return &zstdWriter{Encoder: encoder, pool: &c.encoders}, nil
+}
+
+func (c *zstdCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	if reader, ok := c.decoders.Get().(*zstdReader); ok {
+		if err := reader.Reset(r); err != nil {
+			c.decoders.Put(reader)
+			return nil, err
+		}
+		return reader, nil
+	}
+
+	decoder, err := zstd.NewReader(r,
+		zstd.WithDecoderConcurrency(1),
+		zstd.WithDecoderMaxMemory(maxDecodedSize))
+	if err != nil {
+		return nil, err
+	}
+	return &zstdReader{Decoder: decoder, pool: &c.decoders}, nil
+}
+
+func (c *zstdCompressor) Name() string {
+	return Zstd
+}
@@ -0,0 +1,22 @@
+package dashboard
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// static holds the dashboard, a single page reading the server's JSON API
+//
+//go:embed static
+var static embed.FS
+
+// Handler serves the dashboard files
+func Handler() http.Handler {
+	files, err := fs.Sub(static, "static")
+	if err != nil {
+		// The directory is embedded at build time, it is always there
+		panic(err)
+	}
+	return http.FileServerFS(files)
+}
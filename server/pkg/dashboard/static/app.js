@@ -0,0 +1,289 @@
+// NoxFlow dashboard, reads the server's JSON API under /api
+
+const refreshInterval = 10000;
+const followInterval = 2000;
+// maxLogLines bounds how many lines are kept in the page while following
+const maxLogLines = 2000;
+
+const state = {
+  containers: [],
+  selected: null,
+  lastLogID: 0,
+  logRequest: 0,
+};
+
+const $ = (id) => document.getElementById(id);
+
+async function getJSON(path, params = {}) {
+  const query = new URLSearchParams();
+  for (const [key, value] of Object.entries(params)) {
+    if (value !== undefined && value !== null && value !== "") {
+      query.set(key, value);
+    }
+  }
+  const url = query.toString() ? `${path}?${query}` : path;
+  const response = await fetch(url);
+  if (!response.ok) {
+    const body = await response.json().catch(() => ({}));
+    throw new Error(body.error || `${response.status} ${response.statusText}`);
+  }
+  return response.json();
+}
+
+// Containers
+
+async function loadContainers() {
+  try {
+    const [containers, agents] = await Promise.all([getJSON("/api/containers"), getJSON("/api/agents")]);
+    state.containers = containers;
+    const running = containers.filter((c) => c.state === "running").length;
+    const reporting = agents.filter((a) => a.reporting).length;
+    $("summary").textContent =
+      `${reporting}/${agents.length} agents reporting, ${running}/${containers.length} containers running`;
+    renderContainers();
+  } catch (err) {
+    $("summary").textContent = `Error loading containers: ${err.message}`;
+  }
+}
+
+function renderContainers() {
+  const filter = $("container-filter").value.toLowerCase();
+  const rows = $("container-rows");
+  rows.replaceChildren();
+
+  for (const container of state.containers) {
+    const text = `${container.name} ${container.image} ${container.state}`.toLowerCase();
+    if (filter && !text.includes(filter)) {
+      continue;
+    }
+    const row = document.createElement("tr");
+    if (state.selected && state.selected.container_id === container.container_id) {
+      row.classList.add("selected");
+    }
+    row.append(
+      cell(container.name),
+      cell(container.image),
+      cell(container.state, `state ${container.state}`),
+      cell(container.agent_id),
+    );
+    row.addEventListener("click", () => selectContainer(container));
+    rows.append(row);
+  }
+}
+
+function cell(text, badgeClass) {
+  const td = document.createElement("td");
+  td.title = text;
+  if (badgeClass) {
+    const badge = document.createElement("span");
+    badge.className = badgeClass;
+    badge.textContent = text;
+    td.append(badge);
+  } else {
+    td.textContent = text;
+  }
+  return td;
+}
+
+function selectContainer(container) {
+  state.selected = container;
+  $("selected").textContent = `${container.name} (${container.container_id.slice(0, 12)})`;
+  renderContainers();
+  loadUsage();
+  reloadLogs();
+}
+
+// Usage charts
+
+async function loadUsage() {
+  const container = state.selected;
+  if (!container) {
+    return;
+  }
+  const minutes = Number($("usage-range").value);
+  const since = new Date(Date.now() - minutes * 60000).toISOString().replace(/\.\d+Z$/, "Z");
+  try {
+    const points = await getJSON("/api/usage", { container: container.container_id, since });
+    if (state.selected !== container) {
+      return;
+    }
+    const times = points.map((p) => Date.parse(p.timestamp));
+    drawChart($("cpu-chart"), times, points.map((p) => p.cpu_percent), {
+      color: "#4fa3ff",
+      format: (v) => `${v.toFixed(1)}%`,
+    });
+    const limit = points.length ? points[points.length - 1].memory_limit : 0;
+    drawChart($("memory-chart"), times, points.map((p) => p.memory_usage), {
+      color: "#b38cff",
+      format: formatBytes,
+      max: limit,
+    });
+  } catch (err) {
+    console.error("Error loading usage", err);
+  }
+}
+
+// drawChart draws a line chart of the values, scaled to the device pixel ratio
+function drawChart(canvas, times, values, { color, format, max = 0 }) {
+  const ratio = window.devicePixelRatio || 1;
+  const width = canvas.clientWidth;
+  const height = canvas.clientHeight;
+  canvas.width = width * ratio;
+  canvas.height = height * ratio;
+
+  const ctx = canvas.getContext("2d");
+  ctx.scale(ratio, ratio);
+  ctx.clearRect(0, 0, width, height);
+  ctx.font = "11px system-ui, sans-serif";
+  ctx.fillStyle = "#8a93a3";
+
+  if (values.length === 0) {
+    ctx.fillText("No data", 8, 16);
+    return;
+  }
+
+  const pad = { top: 18, right: 8, bottom: 18, left: 8 };
+  const top = Math.max(max, ...values) || 1;
+  const start = times[0];
+  const span = times[times.length - 1] - start || 1;
+  const x = (t) => pad.left + ((t - start) / span) * (width - pad.left - pad.right);
+  const y = (v) => height - pad.bottom - (v / top) * (height - pad.top - pad.bottom);
+
+  ctx.fillText(`max ${format(top)}`, pad.left, 12);
+  ctx.fillText(`now ${format(values[values.length - 1])}`, width / 2, 12);
+  ctx.fillText(new Date(start).toLocaleTimeString(), pad.left, height - 4);
+  const end = new Date(times[times.length - 1]).toLocaleTimeString();
+  ctx.fillText(end, width - pad.right - ctx.measureText(end).width, height - 4);
+
+  ctx.strokeStyle = color;
+  ctx.lineWidth = 1.5;
+  ctx.beginPath();
+  values.forEach((v, i) => {
+    if (i === 0) {
+      ctx.moveTo(x(times[i]), y(v));
+    } else {
+      ctx.lineTo(x(times[i]), y(v));
+    }
+  });
+  ctx.stroke();
+}
+
+function formatBytes(bytes) {
+  const units = ["B", "KiB", "MiB", "GiB", "TiB"];
+  let i = 0;
+  while (bytes >= 1024 && i < units.length - 1) {
+    bytes /= 1024;
+    i++;
+  }
+  return `${bytes.toFixed(i === 0 ? 0 : 1)} ${units[i]}`;
+}
+
+// Logs
+
+// reloadLogs replaces the logs with the latest ones matching the search
+async function reloadLogs() {
+  const container = state.selected;
+  if (!container) {
+    return;
+  }
+  // Ignore the responses of older requests when the search changes quickly
+  const request = ++state.logRequest;
+  try {
+    const logs = await getJSON("/api/logs", {
+      container: container.name,
+      q: $("log-search").value,
+      limit: 500,
+    });
+    if (request !== state.logRequest) {
+      return;
+    }
+    $("logs").replaceChildren();
+    state.lastLogID = 0;
+    appendLogs(logs);
+  } catch (err) {
+    $("logs").textContent = `Error loading logs: ${err.message}`;
+  }
+}
+
+// followLogs appends the logs stored since the last one shown, or the latest ones while
+// nothing is shown yet
+async function followLogs() {
+  const container = state.selected;
+  if (!container || !$("log-follow").checked) {
+    return;
+  }
+  const request = state.logRequest;
+  try {
+    const logs = await getJSON("/api/logs", {
+      container: container.name,
+      q: $("log-search").value,
+      after_id: state.lastLogID || undefined,
+      limit: 500,
+    });
+    if (request === state.logRequest) {
+      appendLogs(logs);
+    }
+  } catch (err) {
+    console.error("Error following logs", err);
+  }
+}
+
+function appendLogs(logs) {
+  const view = $("logs");
+  const atBottom = view.scrollTop + view.clientHeight >= view.scrollHeight - 4;
+  const search = $("log-search").value;
+
+  for (const entry of logs) {
+    const line = document.createElement("div");
+    const time = document.createElement("span");
+    time.className = "time";
+    time.textContent = new Date(entry.timestamp).toLocaleTimeString() + " ";
+    line.append(time, ...highlight(entry.message, search));
+    view.append(line);
+    state.lastLogID = Math.max(state.lastLogID, entry.id);
+  }
+  while (view.childElementCount > maxLogLines) {
+    view.firstElementChild.remove();
+  }
+  if (atBottom) {
+    view.scrollTop = view.scrollHeight;
+  }
+}
+
+// highlight returns the nodes of the text with the search matches marked
+function highlight(text, search) {
+  if (!search) {
+    return [document.createTextNode(text)];
+  }
+  const nodes = [];
+  const lower = text.toLowerCase();
+  const needle = search.toLowerCase();
+  let from = 0;
+  for (let i = lower.indexOf(needle); i >= 0; i = lower.indexOf(needle, from)) {
+    nodes.push(document.createTextNode(text.slice(from, i)));
+    const mark = document.createElement("mark");
+    mark.textContent = text.slice(i, i + search.length);
+    nodes.push(mark);
+    from = i + search.length;
+  }
+  nodes.push(document.createTextNode(text.slice(from)));
+  return nodes;
+}
+
+function debounce(fn, delay) {
+  let timer;
+  return () => {
+    clearTimeout(timer);
+    timer = setTimeout(fn, delay);
+  };
+}
+
+$("container-filter").addEventListener("input", renderContainers);
+$("usage-range").addEventListener("change", loadUsage);
+$("log-search").addEventListener("input", debounce(reloadLogs, 300));
+window.addEventListener("resize", debounce(loadUsage, 200));
+
+loadContainers();
+setInterval(loadContainers, refreshInterval);
+setInterval(loadUsage, refreshInterval);
+setInterval(followLogs, followInterval);
@@ -0,0 +1,129 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// checkTimeout is how long a check can take. A slow database fails readiness rather
+// than holding the probe past the timeout of the orchestrator.
+const checkTimeout = 2 * time.Second
+
+// Check reports why a dependency is failing, nil when it is healthy
+type Check func(ctx context.Context) error
+
+// Health holds the liveness and readiness checks of the server. /healthz fails when the
+// server should be restarted, /readyz when a dependency it needs is failing.
+type Health struct {
+	mu        sync.RWMutex
+	liveness  map[string]Check
+	readiness map[string]Check
+	timeout   time.Duration
+}
+
+// healthReport lists the result of every check, "ok" or the error
+type healthReport struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// New creates a set of health checks, without checks the server is live and ready
+func New() *Health {
+	return &Health{
+		liveness:  make(map[string]Check),
+		readiness: make(map[string]Check),
+		timeout:   checkTimeout,
+	}
+}
+
+// AddLiveness adds a check to /healthz
+func (h *Health) AddLiveness(name string, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.liveness[name] = check
+}
+
+// AddReadiness adds a check to /readyz
+func (h *Health) AddReadiness(name string, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.readiness[name] = check
+}
+
+// LivenessHandler serves /healthz
+func (h *Health) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.serve(w, r, h.liveness)
+	})
+}
+
+// ReadinessHandler serves /readyz
+func (h *Health) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.serve(w, r, h.readiness)
+	})
+}
+
+// Ready runs the readiness checks and returns the failures
+func (h *Health) Ready(ctx context.Context) error {
+	report := h.run(ctx, h.readiness)
+	names := make([]string, 0, len(report.Checks))
+	for name := range report.Checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		if result := report.Checks[name]; result != "ok" {
+			errs = append(errs, fmt.Errorf("%s: %s", name, result))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// run runs the checks in the order of their names
+func (h *Health) run(ctx context.Context, checks map[string]Check) healthReport {
+	h.mu.RLock()
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+	h.mu.RUnlock()
+	sort.Strings(names)
+
+	report := healthReport{Status: "ok", Checks: make(map[string]string, len(names))}
+	for _, name := range names {
+		h.mu.RLock()
+		check := checks[name]
+		h.mu.RUnlock()
+
+		checkCtx, cancel := context.WithTimeout(ctx, h.timeout)
+		err := check(checkCtx)
+		cancel()
+		if err != nil {
+			report.Status = "failing"
+			report.Checks[name] = err.Error()
+			continue
+		}
+		report.Checks[name] = "ok"
+	}
+	return report
+}
+
+// serve answers 503 with the report when a check failed, 200 otherwise
+func (h *Health) serve(w http.ResponseWriter, r *http.Request, checks map[string]Check) {
+	report := h.run(r.Context(), checks)
+
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
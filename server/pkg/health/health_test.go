@@ -0,0 +1,94 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHandlers(t *testing.T) {
+	passing := func(ctx context.Context) error { return nil }
+	failing := func(ctx context.Context) error { return errors.New("database unreachable") }
+	hanging := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	tests := []struct {
+		name       string
+		checks     map[string]Check
+		wantCode   int
+		wantReport healthReport
+	}{
+		{
+			name:       "without checks",
+			wantCode:   http.StatusOK,
+			wantReport: healthReport{Status: "ok", Checks: map[string]string{}},
+		},
+		{
+			name:       "passing",
+			checks:     map[string]Check{"database": passing, "queues": passing},
+			wantCode:   http.StatusOK,
+			wantReport: healthReport{Status: "ok", Checks: map[string]string{"database": "ok", "queues": "ok"}},
+		},
+		{
+			name:       "failing",
+			checks:     map[string]Check{"database": failing, "queues": passing},
+			wantCode:   http.StatusServiceUnavailable,
+			wantReport: healthReport{Status: "failing", Checks: map[string]string{"database": "database unreachable", "queues": "ok"}},
+		},
+		{
+			name:       "timed out",
+			checks:     map[string]Check{"database": hanging},
+			wantCode:   http.StatusServiceUnavailable,
+			wantReport: healthReport{Status: "failing", Checks: map[string]string{"database": context.DeadlineExceeded.Error()}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			health := New()
+			health.timeout = 10 * time.Millisecond
+			for name, check := range tt.checks {
+				health.AddLiveness(name, check)
+			}
+
+			recorder := httptest.NewRecorder()
+			health.LivenessHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			if recorder.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", recorder.Code, tt.wantCode)
+			}
+			var report healthReport
+			if err := json.NewDecoder(recorder.Body).Decode(&report); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(report, tt.wantReport) {
+				t.Errorf("report = %+v, want %+v", report, tt.wantReport)
+			}
+		})
+	}
+}
+
+func TestReady(t *testing.T) {
+	health := New()
+	health.AddReadiness("queues", func(ctx context.Context) error { return errors.New("stalled") })
+	health.AddReadiness("database", func(ctx context.Context) error { return errors.New("unreachable") })
+	health.AddReadiness("cache", func(ctx context.Context) error { return nil })
+	health.AddLiveness("process", func(ctx context.Context) error { return errors.New("ignored") })
+
+	err := health.Ready(context.Background())
+	if want := "database: unreachable\nqueues: stalled"; err == nil || err.Error() != want {
+		t.Errorf("Ready() error = %v, want %q", err, want)
+	}
+
+	recorder := httptest.NewRecorder()
+	health.ReadinessHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz status = %d, want %d", recorder.Code, http.StatusServiceUnavailable)
+	}
+}
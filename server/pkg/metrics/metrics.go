@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	// LogLinesReceived counts the log lines received per agent
+	LogLinesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "noxflow_server_log_lines_received_total",
+		Help: "Log lines received from agents",
+	}, []string{"agent"})
+
+	// LogBytesReceived counts the log bytes received per agent
+	LogBytesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "noxflow_server_log_bytes_received_total",
+		Help: "Log bytes received from agents",
+	}, []string{"agent"})
+
+	// UsageSamplesReceived counts the usage samples received per agent
+	UsageSamplesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "noxflow_server_usage_samples_received_total",
+		Help: "Container usage samples received from agents",
+	}, []string{"agent"})
+
+	// EventsReceived counts the container events received per agent and action
+	EventsReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "noxflow_server_container_events_received_total",
+		Help: "Container lifecycle events received from agents",
+	}, []string{"agent", "action"})
+
+	// ActiveStreams tracks the open gRPC streams per service
+	ActiveStreams = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "noxflow_server_active_streams",
+		Help: "Open gRPC streams",
+	}, []string{"service"})
+
+	// FlushDuration tracks how long writing a batch to the database takes
+	FlushDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "noxflow_server_batch_flush_duration_seconds",
+		Help:    "Time spent writing a batch to the database",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"table"})
+
+	// FlushSize tracks the number of rows written per batch
+	FlushSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "noxflow_server_batch_flush_size",
+		Help:    "Rows written to the database per batch",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 8),
+	}, []string{"table"})
+
+	// DatabaseErrors counts failed database operations
+	DatabaseErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "noxflow_server_db_errors_total",
+		Help: "Failed database operations",
+	}, []string{"operation"})
+
+	// QueueDepth tracks the rows waiting to be written
+	QueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "noxflow_server_queue_depth",
+		Help: "Rows waiting to be written to the database",
+	}, []string{"table"})
+
+	// DroppedRows counts rows discarded because the queue was full
+	DroppedRows = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "noxflow_server_dropped_rows_total",
+		Help: "Rows dropped because the database could not keep up",
+	}, []string{"table"})
+
+	// RejectedRows counts rows discarded because the database rejected them
+	RejectedRows = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "noxflow_server_rejected_rows_total",
+		Help: "Rows dropped because the database rejected them on their own",
+	}, []string{"table"})
+)
+
+// Handler returns the HTTP handler serving the metrics
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,304 @@
+package notify
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nox/noxflow/server-gRPC/pkg/alerting"
+)
+
+const (
+	// queueSize is how many alerts can wait for delivery before new ones are dropped
+	queueSize = 1000
+	// channelQueueSize is how many notifications can wait for a channel, a channel that is
+	// slow or down drops the new ones rather than piling them up
+	channelQueueSize = 100
+
+	defaultAttempts = 3
+	defaultBackoff  = 2 * time.Second
+	sendTimeout     = 30 * time.Second
+)
+
+// channel is a notifier with its delivery settings. Its notifications are sent one at a
+// time, in order, by a worker reading the queue.
+type channel struct {
+	notifier    Notifier
+	limiter     *rateLimiter
+	minSeverity string
+	queue       chan alerting.Alert
+
+	mu sync.Mutex
+	// firing holds the fingerprints of the alerts whose firing notification was queued or
+	// sent on the channel, their resolution is sent whatever the silences and rate limit
+	firing map[string]bool
+}
+
+func newChannel(notifier Notifier, minSeverity string) *channel {
+	return &channel{
+		notifier:    notifier,
+		minSeverity: minSeverity,
+		queue:       make(chan alerting.Alert, channelQueueSize),
+		firing:      make(map[string]bool),
+	}
+}
+
+// notified reports whether the firing notification of a resolved alert went out on the
+// channel, and forgets it
+func (c *channel) notified(alert alerting.Alert) bool {
+	if alert.State != alerting.StateResolved {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	notified := c.firing[alert.Fingerprint]
+	delete(c.firing, alert.Fingerprint)
+	return notified
+}
+
+// setFiring records whether the firing notification of an alert is queued or sent
+func (c *channel) setFiring(alert alerting.Alert, firing bool) {
+	if alert.State != alerting.StateFiring {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if firing {
+		c.firing[alert.Fingerprint] = true
+	} else {
+		delete(c.firing, alert.Fingerprint)
+	}
+}
+
+// Dispatcher delivers alerts to the notification channels in the background,
+// with retries, rate limiting and silences
+type Dispatcher struct {
+	channels []*channel
+	silences []Silence
+	attempts int
+	backoff  time.Duration
+	queue    chan alerting.Alert
+	now      func() time.Time
+	wg       sync.WaitGroup
+
+	// sendCtx is the context of the deliveries, only cancelled when Shutdown runs out of
+	// time so stopping Run doesn't cut the deliveries in progress
+	sendCtx    context.Context
+	cancelSend context.CancelFunc
+}
+
+// NewDispatcher creates a dispatcher for the configured channels. The HTTP client is
+// used by the webhook and Slack notifiers.
+func NewDispatcher(cfg *Config, client *http.Client) (*Dispatcher, error) {
+	dispatcher := &Dispatcher{
+		silences: cfg.Silences,
+		attempts: cfg.Retry.Attempts,
+		backoff:  time.Duration(cfg.Retry.Backoff),
+		queue:    make(chan alerting.Alert, queueSize),
+		now:      time.Now,
+	}
+	dispatcher.sendCtx, dispatcher.cancelSend = context.WithCancel(context.Background())
+	if dispatcher.attempts <= 0 {
+		dispatcher.attempts = defaultAttempts
+	}
+	if dispatcher.backoff <= 0 {
+		dispatcher.backoff = defaultBackoff
+	}
+
+	for _, channelCfg := range cfg.Channels {
+		notifier, err := newNotifier(channelCfg, client)
+		if err != nil {
+			return nil, err
+		}
+		ch := newChannel(notifier, channelCfg.MinSeverity)
+		if channelCfg.RateLimit != nil && channelCfg.RateLimit.Count > 0 {
+			ch.limiter = newRateLimiter(channelCfg.RateLimit.Count, time.Duration(channelCfg.RateLimit.Per))
+		}
+		dispatcher.addChannel(ch)
+	}
+
+	return dispatcher, nil
+}
+
+// AddNotifier adds a channel without rate limiting
+func (d *Dispatcher) AddNotifier(notifier Notifier) {
+	d.addChannel(newChannel(notifier, ""))
+}
+
+// addChannel adds a channel and starts its worker, which runs until Shutdown
+func (d *Dispatcher) addChannel(ch *channel) {
+	d.channels = append(d.channels, ch)
+	go func() {
+		for alert := range ch.queue {
+			if !d.deliver(d.sendCtx, ch.notifier, alert) {
+				ch.setFiring(alert, false)
+			}
+			d.wg.Done()
+		}
+	}()
+}
+
+// Enqueue schedules an alert for delivery, it never blocks the caller.
+// It can be passed to alerting.Engine.Subscribe.
+func (d *Dispatcher) Enqueue(alert alerting.Alert) {
+	select {
+	case d.queue <- alert:
+	default:
+		slog.Error("Notification queue full, dropping alert", "rule", alert.Rule, "state", alert.State)
+	}
+}
+
+// Run delivers the queued alerts until the context is cancelled, the deliveries in
+// progress carry on until Shutdown
+func (d *Dispatcher) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case alert := <-d.queue:
+			d.dispatch(alert)
+		}
+	}
+}
+
+// Shutdown delivers the alerts still queued once Run returned and waits for the
+// deliveries, retries included. The deliveries still running when ctx is done are
+// cancelled. The dispatcher can't be used afterwards.
+func (d *Dispatcher) Shutdown(ctx context.Context) error {
+	defer func() {
+		for _, ch := range d.channels {
+			close(ch.queue)
+		}
+	}()
+
+	for queued := true; queued; {
+		select {
+		case alert := <-d.queue:
+			d.dispatch(alert)
+		default:
+			queued = false
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		d.cancelSend()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// dispatch queues the alert on every channel that accepts it. The resolution of an alert
+// is always sent on the channels its firing notification went out on, so no alert is
+// left firing on a channel because of a silence or the rate limit.
+func (d *Dispatcher) dispatch(alert alerting.Alert) {
+	now := d.now()
+	var silence *Silence
+	for i := range d.silences {
+		if d.silences[i].Matches(alert, now) {
+			silence = &d.silences[i]
+			break
+		}
+	}
+
+	for _, ch := range d.channels {
+		if ch.minSeverity == alerting.SeverityCritical && alert.Severity != alerting.SeverityCritical {
+			continue
+		}
+		if !ch.notified(alert) {
+			if silence != nil {
+				slog.Info("Alert silenced", "channel", ch.notifier.Name(), "rule", alert.Rule, "container", alert.ContainerName, "silence", silence.Comment)
+				continue
+			}
+			if ch.limiter != nil && !ch.limiter.allow(now) {
+				slog.Warn("Notification rate limited", "channel", ch.notifier.Name(), "rule", alert.Rule)
+				continue
+			}
+		}
+
+		// Channels are independent, a slow one must not delay the others
+		d.wg.Add(1)
+		ch.setFiring(alert, true)
+		select {
+		case ch.queue <- alert:
+		default:
+			d.wg.Done()
+			ch.setFiring(alert, false)
+			slog.Error("Notification channel queue full, dropping alert", "channel", ch.notifier.Name(), "rule", alert.Rule, "state", alert.State)
+		}
+	}
+}
+
+// deliver sends the alert through a notifier, retrying with an exponential backoff.
+// It reports whether the alert was sent.
+func (d *Dispatcher) deliver(ctx context.Context, notifier Notifier, alert alerting.Alert) bool {
+	backoff := d.backoff
+	for attempt := 1; attempt <= d.attempts; attempt++ {
+		sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+		err := notifier.Notify(sendCtx, alert)
+		cancel()
+		if err == nil {
+			return true
+		}
+
+		if attempt == d.attempts {
+			slog.Error("Failed to send notification", "channel", notifier.Name(), "rule", alert.Rule, "attempts", attempt, "error", err)
+			return false
+		}
+		slog.Warn("Notification attempt failed, retrying", "channel", notifier.Name(), "attempt", attempt, "backoff", backoff, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	return false
+}
+
+// rateLimiter is a token bucket allowing count events per interval
+type rateLimiter struct {
+	mu       sync.Mutex
+	tokens   float64
+	capacity float64
+	rate     float64
+	last     time.Time
+}
+
+func newRateLimiter(count int, per time.Duration) *rateLimiter {
+	if per <= 0 {
+		per = time.Minute
+	}
+	return &rateLimiter{
+		tokens:   float64(count),
+		capacity: float64(count),
+		rate:     float64(count) / per.Seconds(),
+	}
+}
+
+// allow takes a token if one is available
+func (l *rateLimiter) allow(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.last.IsZero() {
+		l.tokens = min(l.capacity, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
@@ -0,0 +1,442 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nox/noxflow/server-gRPC/pkg/alerting"
+)
+
+// fakeNotifier fails its first failures calls and records when each call was made
+type fakeNotifier struct {
+	failures int
+
+	mu     sync.Mutex
+	calls  []time.Time
+	alerts []alerting.Alert
+}
+
+func (n *fakeNotifier) Name() string {
+	return "fake"
+}
+
+func (n *fakeNotifier) Notify(ctx context.Context, alert alerting.Alert) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.calls = append(n.calls, time.Now())
+	n.alerts = append(n.alerts, alert)
+	if len(n.calls) <= n.failures {
+		return errors.New("unavailable")
+	}
+	return nil
+}
+
+func (n *fakeNotifier) recorded() ([]time.Time, []alerting.Alert) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]time.Time(nil), n.calls...), append([]alerting.Alert(nil), n.alerts...)
+}
+
+// newTestDispatcher creates a dispatcher whose clock is fixed at now
+func newTestDispatcher(t *testing.T, cfg *Config, now time.Time) *Dispatcher {
+	t.Helper()
+	dispatcher, err := NewDispatcher(cfg, http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatcher.now = func() time.Time { return now }
+	return dispatcher
+}
+
+func TestDispatcherRetriesWithBackoff(t *testing.T) {
+	const backoff = 20 * time.Millisecond
+	tests := []struct {
+		name      string
+		failures  int
+		wantCalls int
+	}{
+		{name: "first attempt succeeds", failures: 0, wantCalls: 1},
+		{name: "succeeds on the last attempt", failures: 2, wantCalls: 3},
+		{name: "gives up after the attempts", failures: 10, wantCalls: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Retry: RetryConfig{Attempts: 3, Backoff: alerting.Duration(backoff)}}
+			dispatcher := newTestDispatcher(t, cfg, time.Now())
+			notifier := &fakeNotifier{failures: tt.failures}
+			dispatcher.AddNotifier(notifier)
+
+			dispatcher.dispatch(testAlert())
+			dispatcher.wg.Wait()
+
+			calls, _ := notifier.recorded()
+			if len(calls) != tt.wantCalls {
+				t.Fatalf("Notify() called %d times, want %d", len(calls), tt.wantCalls)
+			}
+			// The backoff doubles after every failed attempt
+			want := backoff
+			for i := 1; i < len(calls); i++ {
+				if gap := calls[i].Sub(calls[i-1]); gap < want {
+					t.Errorf("attempt %d came %v after the previous one, want at least %v", i+1, gap, want)
+				}
+				want *= 2
+			}
+		})
+	}
+}
+
+func TestDispatcherDefaults(t *testing.T) {
+	dispatcher := newTestDispatcher(t, &Config{}, time.Now())
+	if dispatcher.attempts != defaultAttempts || dispatcher.backoff != defaultBackoff {
+		t.Errorf("attempts = %d, backoff = %v, want %d and %v", dispatcher.attempts, dispatcher.backoff, defaultAttempts, defaultBackoff)
+	}
+}
+
+func TestDispatcherShutdownCancelsAtTheDeadline(t *testing.T) {
+	cfg := &Config{Retry: RetryConfig{Attempts: 5, Backoff: alerting.Duration(time.Hour)}}
+	dispatcher := newTestDispatcher(t, cfg, time.Now())
+	notifier := &fakeNotifier{failures: 10}
+	dispatcher.AddNotifier(notifier)
+
+	dispatcher.dispatch(testAlert())
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- dispatcher.Shutdown(ctx)
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Shutdown() = nil, want the deadline error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("delivery still waiting for the backoff after the shutdown deadline")
+	}
+	if calls, _ := notifier.recorded(); len(calls) != 1 {
+		t.Errorf("Notify() called %d times, want 1", len(calls))
+	}
+}
+
+// blockingNotifier holds every Notify call until release is closed, unless its context is done
+type blockingNotifier struct {
+	started  chan struct{}
+	release  chan struct{}
+	finished chan error
+}
+
+func (n *blockingNotifier) Name() string {
+	return "blocking"
+}
+
+func (n *blockingNotifier) Notify(ctx context.Context, alert alerting.Alert) error {
+	n.started <- struct{}{}
+	select {
+	case <-n.release:
+		n.finished <- nil
+		return nil
+	case <-ctx.Done():
+		n.finished <- ctx.Err()
+		return ctx.Err()
+	}
+}
+
+func TestDispatcherShutdownFinishesTheDeliveries(t *testing.T) {
+	dispatcher := newTestDispatcher(t, &Config{}, time.Now())
+	notifier := &blockingNotifier{
+		started:  make(chan struct{}, 10),
+		release:  make(chan struct{}),
+		finished: make(chan error, 10),
+	}
+	dispatcher.AddNotifier(notifier)
+
+	runCtx, stopRun := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		dispatcher.Run(runCtx)
+		close(stopped)
+	}()
+
+	// One alert in flight when Run stops, one still queued
+	dispatcher.Enqueue(testAlert())
+	<-notifier.started
+	stopRun()
+	<-stopped
+	dispatcher.Enqueue(testAlert())
+
+	done := make(chan error, 1)
+	go func() {
+		done <- dispatcher.Shutdown(context.Background())
+	}()
+	close(notifier.release)
+
+	if err := <-done; err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	for range 2 {
+		if err := <-notifier.finished; err != nil {
+			t.Errorf("a delivery was cancelled: %v", err)
+		}
+	}
+}
+
+func TestDispatcherSilences(t *testing.T) {
+	now := time.Date(2024, 5, 1, 2, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		silence  Silence
+		silenced bool
+	}{
+		{
+			name:     "absolute window",
+			silence:  Silence{Rule: "high-*", Start: now.Add(-time.Hour), End: now.Add(time.Hour)},
+			silenced: true,
+		},
+		{
+			name:    "absolute window over",
+			silence: Silence{Rule: "high-*", Start: now.Add(-2 * time.Hour), End: now.Add(-time.Hour)},
+		},
+		{
+			name:     "daily window past midnight",
+			silence:  Silence{Container: "api", From: "22:00", To: "06:00"},
+			silenced: true,
+		},
+		{
+			name:    "daily window not reached",
+			silence: Silence{Container: "api", From: "09:00", To: "17:00"},
+		},
+		{
+			name:    "other rule",
+			silence: Silence{Rule: "errors", Start: now.Add(-time.Hour)},
+		},
+		{
+			name:    "other severity",
+			silence: Silence{Severity: alerting.SeverityWarning, Start: now.Add(-time.Hour)},
+		},
+		{
+			name:     "container by ID",
+			silence:  Silence{Container: "0123456789ab", Start: now.Add(-time.Hour)},
+			silenced: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.silence.validate(); err != nil {
+				t.Fatal(err)
+			}
+			dispatcher := newTestDispatcher(t, &Config{Silences: []Silence{tt.silence}}, now)
+			notifier := &fakeNotifier{}
+			dispatcher.AddNotifier(notifier)
+
+			dispatcher.dispatch(testAlert())
+			dispatcher.wg.Wait()
+
+			calls, _ := notifier.recorded()
+			if silenced := len(calls) == 0; silenced != tt.silenced {
+				t.Errorf("silenced = %v, want %v", silenced, tt.silenced)
+			}
+		})
+	}
+}
+
+func TestDispatcherRateLimit(t *testing.T) {
+	server, requests := recordServer(t, http.StatusOK)
+	cfg := &Config{Channels: []ChannelConfig{{
+		Name:      "hook",
+		Type:      TypeWebhook,
+		URL:       server.URL,
+		RateLimit: &RateLimitConfig{Count: 2, Per: alerting.Duration(time.Minute)},
+	}}}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	dispatcher := newTestDispatcher(t, cfg, now)
+
+	send := func(count int) int {
+		for range count {
+			dispatcher.dispatch(testAlert())
+		}
+		dispatcher.wg.Wait()
+		sent := len(requests)
+		for range sent {
+			<-requests
+		}
+		return sent
+	}
+
+	if sent := send(5); sent != 2 {
+		t.Errorf("sent %d notifications in a burst, want the 2 allowed", sent)
+	}
+
+	// One token comes back every 30s
+	now = now.Add(30 * time.Second)
+	dispatcher.now = func() time.Time { return now }
+	if sent := send(3); sent != 1 {
+		t.Errorf("sent %d notifications after 30s, want 1", sent)
+	}
+
+	// The bucket never holds more than the limit
+	now = now.Add(time.Hour)
+	dispatcher.now = func() time.Time { return now }
+	if sent := send(5); sent != 2 {
+		t.Errorf("sent %d notifications after an hour, want 2", sent)
+	}
+}
+
+func TestDispatcherMinSeverity(t *testing.T) {
+	server, requests := recordServer(t, http.StatusOK)
+	cfg := &Config{Channels: []ChannelConfig{{
+		Name:        "pager",
+		Type:        TypeWebhook,
+		URL:         server.URL,
+		MinSeverity: alerting.SeverityCritical,
+	}}}
+	dispatcher := newTestDispatcher(t, cfg, time.Now())
+
+	warning := testAlert()
+	warning.Severity = alerting.SeverityWarning
+	dispatcher.dispatch(warning)
+	dispatcher.dispatch(testAlert())
+	dispatcher.wg.Wait()
+
+	if len(requests) != 1 {
+		t.Fatalf("sent %d notifications, want only the critical one", len(requests))
+	}
+}
+
+func TestDispatcherRunDeliversTheQueue(t *testing.T) {
+	dispatcher := newTestDispatcher(t, &Config{}, time.Now())
+	notifier := &fakeNotifier{}
+	dispatcher.AddNotifier(notifier)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		dispatcher.Run(ctx)
+		close(done)
+	}()
+
+	firing := testAlert()
+	resolved := testAlert()
+	resolved.State = alerting.StateResolved
+	dispatcher.Enqueue(firing)
+	dispatcher.Enqueue(resolved)
+
+	// A channel delivers its alerts in order
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, alerts := notifier.recorded(); len(alerts) == 2 {
+			if alerts[0].State != alerting.StateFiring || alerts[1].State != alerting.StateResolved {
+				t.Errorf("delivered %s then %s, want firing then resolved", alerts[0].State, alerts[1].State)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("queued alerts were not delivered")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	cancel()
+	<-done
+}
+
+func TestDispatcherBoundsTheDeliveries(t *testing.T) {
+	dispatcher := newTestDispatcher(t, &Config{}, time.Now())
+	notifier := &blockingNotifier{
+		started:  make(chan struct{}, 2*channelQueueSize),
+		release:  make(chan struct{}),
+		finished: make(chan error, 2*channelQueueSize),
+	}
+	dispatcher.AddNotifier(notifier)
+
+	// One alert in flight, the queue filled up and the rest dropped
+	dispatcher.dispatch(testAlert())
+	<-notifier.started
+	for range 2 * channelQueueSize {
+		dispatcher.dispatch(testAlert())
+	}
+	if started := len(notifier.started); started != 0 {
+		t.Errorf("%d deliveries started while one is in flight, want them queued", started)
+	}
+
+	close(notifier.release)
+	dispatcher.wg.Wait()
+	if delivered := len(notifier.finished); delivered != channelQueueSize+1 {
+		t.Errorf("delivered %d alerts, want %d", delivered, channelQueueSize+1)
+	}
+}
+
+func TestDispatcherSendsTheResolutionOfNotifiedAlerts(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	resolvedAlert := func(alert alerting.Alert) alerting.Alert {
+		alert.State = alerting.StateResolved
+		return alert
+	}
+
+	t.Run("silenced after firing", func(t *testing.T) {
+		silence := Silence{Rule: "high-*", Start: now.Add(time.Minute)}
+		if err := silence.validate(); err != nil {
+			t.Fatal(err)
+		}
+		dispatcher := newTestDispatcher(t, &Config{Silences: []Silence{silence}}, now)
+		notifier := &fakeNotifier{}
+		dispatcher.AddNotifier(notifier)
+
+		dispatcher.dispatch(testAlert())
+		dispatcher.now = func() time.Time { return now.Add(time.Hour) }
+		dispatcher.dispatch(resolvedAlert(testAlert()))
+		dispatcher.wg.Wait()
+
+		if _, alerts := notifier.recorded(); len(alerts) != 2 {
+			t.Errorf("delivered %d notifications, want the firing and the resolved one", len(alerts))
+		}
+	})
+
+	t.Run("rate limited", func(t *testing.T) {
+		server, requests := recordServer(t, http.StatusOK)
+		cfg := &Config{Channels: []ChannelConfig{{
+			Name:      "hook",
+			Type:      TypeWebhook,
+			URL:       server.URL,
+			RateLimit: &RateLimitConfig{Count: 1, Per: alerting.Duration(time.Hour)},
+		}}}
+		dispatcher := newTestDispatcher(t, cfg, now)
+
+		first := testAlert()
+		second := testAlert()
+		second.Fingerprint = "usage/high-cpu/other"
+		dispatcher.dispatch(first)
+		dispatcher.dispatch(second)
+		dispatcher.dispatch(resolvedAlert(first))
+		dispatcher.dispatch(resolvedAlert(second))
+		dispatcher.wg.Wait()
+
+		// The first alert fires and resolves, the second is limited both times
+		if len(requests) != 2 {
+			t.Errorf("sent %d notifications, want 2", len(requests))
+		}
+	})
+
+	t.Run("firing not delivered", func(t *testing.T) {
+		dispatcher := newTestDispatcher(t, &Config{}, now)
+		dispatcher.attempts = 1
+		notifier := &fakeNotifier{failures: 1}
+		dispatcher.AddNotifier(notifier)
+		dispatcher.dispatch(testAlert())
+		dispatcher.wg.Wait()
+
+		dispatcher.silences = []Silence{{Rule: "high-*", Start: now.Add(-time.Minute)}}
+		dispatcher.dispatch(resolvedAlert(testAlert()))
+		dispatcher.wg.Wait()
+
+		if calls, _ := notifier.recorded(); len(calls) != 1 {
+			t.Errorf("made %d calls, want the silence to hold the resolution", len(calls))
+		}
+	})
+}
@@ -0,0 +1,94 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nox/noxflow/server-gRPC/pkg/alerting"
+)
+
+// EmailNotifier sends alerts by email through an SMTP server
+type EmailNotifier struct {
+	name     string
+	addr     string
+	host     string
+	username string
+	password string
+	from     string
+	to       []string
+}
+
+// NewEmailNotifier creates an SMTP notifier, authentication is skipped when username is empty
+func NewEmailNotifier(name, host string, port int, username, password, from string, to []string) *EmailNotifier {
+	return &EmailNotifier{
+		name:     name,
+		addr:     net.JoinHostPort(host, strconv.Itoa(port)),
+		host:     host,
+		username: username,
+		password: password,
+		from:     from,
+		to:       to,
+	}
+}
+
+// Name implements Notifier
+func (n *EmailNotifier) Name() string {
+	return n.name
+}
+
+// Notify implements Notifier. net/smtp has no context support, so the context is only
+// checked before sending.
+func (n *EmailNotifier) Notify(ctx context.Context, alert alerting.Alert) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if n.username != "" {
+		auth = smtp.PlainAuth("", n.username, n.password, n.host)
+	}
+
+	if err := smtp.SendMail(n.addr, auth, n.from, n.to, n.message(alert)); err != nil {
+		return fmt.Errorf("error sending email: %v", err)
+	}
+	return nil
+}
+
+// message builds the email with its headers
+func (n *EmailNotifier) message(alert alerting.Alert) []byte {
+	var body strings.Builder
+	fmt.Fprintf(&body, "%s\r\n\r\n", alert.Summary)
+	fmt.Fprintf(&body, "Rule:      %s\r\n", alert.Rule)
+	fmt.Fprintf(&body, "State:     %s\r\n", alert.State)
+	fmt.Fprintf(&body, "Severity:  %s\r\n", alert.Severity)
+	fmt.Fprintf(&body, "Container: %s\r\n", containerName(alert))
+	if alert.Image != "" {
+		fmt.Fprintf(&body, "Image:     %s\r\n", alert.Image)
+	}
+	fmt.Fprintf(&body, "Started:   %s\r\n", alert.StartedAt.Format(time.RFC3339))
+	if !alert.ResolvedAt.IsZero() {
+		fmt.Fprintf(&body, "Resolved:  %s\r\n", alert.ResolvedAt.Format(time.RFC3339))
+	}
+	if len(alert.Samples) > 0 {
+		body.WriteString("\r\nSample lines:\r\n")
+		for _, sample := range alert.Samples {
+			fmt.Fprintf(&body, "  %s\r\n", sample)
+		}
+	}
+
+	var message strings.Builder
+	fmt.Fprintf(&message, "From: %s\r\n", n.from)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(n.to, ", "))
+	fmt.Fprintf(&message, "Subject: [NoxFlow %s] %s on %s\r\n", strings.ToUpper(alert.State), alert.Rule, containerName(alert))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	message.WriteString("MIME-Version: 1.0\r\n")
+	message.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	message.WriteString(body.String())
+
+	return []byte(message.String())
+}
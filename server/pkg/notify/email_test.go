@@ -0,0 +1,190 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/nox/noxflow/server-gRPC/pkg/alerting"
+)
+
+// smtpSession is what the stub SMTP server received
+type smtpSession struct {
+	auth string
+	from string
+	to   []string
+	data string
+}
+
+// smtpStub is a single connection SMTP server, rejectRcpt makes it refuse the recipients
+type smtpStub struct {
+	listener   net.Listener
+	rejectRcpt bool
+	sessions   chan smtpSession
+}
+
+func newSMTPStub(t *testing.T, rejectRcpt bool) *smtpStub {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub := &smtpStub{listener: listener, rejectRcpt: rejectRcpt, sessions: make(chan smtpSession, 1)}
+	t.Cleanup(func() { listener.Close() })
+	go stub.serve()
+	return stub
+}
+
+// hostPort returns the address of the stub
+func (s *smtpStub) hostPort(t *testing.T) (string, int) {
+	host, port, err := net.SplitHostPort(s.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	portNumber, _ := strconv.Atoi(port)
+	return host, portNumber
+}
+
+func (s *smtpStub) serve() {
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	text := textproto.NewConn(conn)
+	var session smtpSession
+	defer func() { s.sessions <- session }()
+
+	text.PrintfLine("220 stub ESMTP")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		command, argument, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(command) {
+		case "EHLO", "HELO":
+			text.PrintfLine("250-stub")
+			text.PrintfLine("250 AUTH PLAIN")
+		case "AUTH":
+			_, credentials, _ := strings.Cut(argument, " ")
+			decoded, _ := base64.StdEncoding.DecodeString(credentials)
+			session.auth = string(decoded)
+			text.PrintfLine("235 Authenticated")
+		case "MAIL":
+			session.from = strings.Trim(strings.TrimPrefix(argument, "FROM:"), "<>")
+			text.PrintfLine("250 OK")
+		case "RCPT":
+			if s.rejectRcpt {
+				text.PrintfLine("550 No such user")
+				continue
+			}
+			session.to = append(session.to, strings.Trim(strings.TrimPrefix(argument, "TO:"), "<>"))
+			text.PrintfLine("250 OK")
+		case "DATA":
+			text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			session.data = string(data)
+			text.PrintfLine("250 OK")
+		case "QUIT":
+			text.PrintfLine("221 Bye")
+			return
+		default:
+			text.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+func TestEmailNotifierSendsTheAlert(t *testing.T) {
+	stub := newSMTPStub(t, false)
+	host, port := stub.hostPort(t)
+	notifier := NewEmailNotifier("email", host, port, "", "", "noxflow@example.com",
+		[]string{"ops@example.com", "oncall@example.com"})
+
+	alert := testAlert()
+	alert.Samples = []string{"ERROR db timeout"}
+	if err := notifier.Notify(context.Background(), alert); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+	session := <-stub.sessions
+
+	if session.auth != "" {
+		t.Errorf("authenticated without a username: %q", session.auth)
+	}
+	if session.from != "noxflow@example.com" {
+		t.Errorf("MAIL FROM = %q, want noxflow@example.com", session.from)
+	}
+	if strings.Join(session.to, ",") != "ops@example.com,oncall@example.com" {
+		t.Errorf("RCPT TO = %v, want both recipients", session.to)
+	}
+
+	message, err := textproto.NewReader(bufio.NewReader(strings.NewReader(session.data))).ReadMIMEHeader()
+	if err != nil {
+		t.Fatalf("invalid message headers: %v", err)
+	}
+	if got, want := message.Get("Subject"), "[NoxFlow FIRING] high-cpu on api"; got != want {
+		t.Errorf("Subject = %q, want %q", got, want)
+	}
+	if got, want := message.Get("To"), "ops@example.com, oncall@example.com"; got != want {
+		t.Errorf("To = %q, want %q", got, want)
+	}
+	if got := message.Get("Content-Type"); got != "text/plain; charset=UTF-8" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	for _, want := range []string{alert.Summary, "Rule:      high-cpu", "Image:     nginx:1.25", "  ERROR db timeout"} {
+		if !strings.Contains(session.data, want) {
+			t.Errorf("message has no %q:\n%s", want, session.data)
+		}
+	}
+}
+
+func TestEmailNotifierAuthenticates(t *testing.T) {
+	stub := newSMTPStub(t, false)
+	host, port := stub.hostPort(t)
+	notifier := NewEmailNotifier("email", host, port, "user", "pass", "noxflow@example.com", []string{"ops@example.com"})
+
+	resolved := testAlert()
+	resolved.State = alerting.StateResolved
+	if err := notifier.Notify(context.Background(), resolved); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+	session := <-stub.sessions
+
+	if session.auth != "\x00user\x00pass" {
+		t.Errorf("AUTH PLAIN = %q, want user and pass", session.auth)
+	}
+	if !strings.Contains(session.data, "Subject: [NoxFlow RESOLVED] high-cpu on api") {
+		t.Errorf("message is not the resolved alert:\n%s", session.data)
+	}
+}
+
+func TestEmailNotifierRejectedRecipient(t *testing.T) {
+	stub := newSMTPStub(t, true)
+	host, port := stub.hostPort(t)
+	notifier := NewEmailNotifier("email", host, port, "", "", "noxflow@example.com", []string{"nobody@example.com"})
+
+	if err := notifier.Notify(context.Background(), testAlert()); err == nil {
+		t.Fatal("Notify() succeeded with a rejected recipient")
+	}
+}
+
+func TestEmailNotifierCancelledContext(t *testing.T) {
+	stub := newSMTPStub(t, false)
+	host, port := stub.hostPort(t)
+	notifier := NewEmailNotifier("email", host, port, "", "", "noxflow@example.com", []string{"ops@example.com"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := notifier.Notify(ctx, testAlert()); err == nil {
+		t.Fatal("Notify() sent with a cancelled context")
+	}
+}
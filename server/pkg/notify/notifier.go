@@ -0,0 +1,202 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/nox/noxflow/server-gRPC/pkg/alerting"
+)
+
+// Notifier delivers alerts to an outside system
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, alert alerting.Alert) error
+}
+
+// Channel types
+const (
+	TypeWebhook = "webhook"
+	TypeSlack   = "slack"
+	TypeEmail   = "email"
+)
+
+// ChannelConfig configures a single notification channel
+type ChannelConfig struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+
+	// Webhook and Slack
+	URL string `json:"url,omitempty"`
+
+	// Webhook
+	Secret   string            `json:"secret,omitempty"`
+	Template string            `json:"template,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+
+	// Slack
+	Channel  string `json:"channel,omitempty"`
+	Username string `json:"username,omitempty"`
+
+	// Email
+	Host         string   `json:"host,omitempty"`
+	Port         int      `json:"port,omitempty"`
+	SMTPUsername string   `json:"smtp_username,omitempty"`
+	SMTPPassword string   `json:"smtp_password,omitempty"`
+	From         string   `json:"from,omitempty"`
+	To           []string `json:"to,omitempty"`
+
+	// RateLimit caps the notifications sent through the channel, unlimited when empty
+	RateLimit *RateLimitConfig `json:"rate_limit,omitempty"`
+	// MinSeverity skips warnings when set to critical
+	MinSeverity string `json:"min_severity,omitempty"`
+}
+
+// RateLimitConfig allows Count notifications per Per duration
+type RateLimitConfig struct {
+	Count int               `json:"count"`
+	Per   alerting.Duration `json:"per"`
+}
+
+// RetryConfig configures the delivery retries
+type RetryConfig struct {
+	Attempts int               `json:"attempts"`
+	Backoff  alerting.Duration `json:"backoff"`
+}
+
+// Config is the content of the notifications file
+type Config struct {
+	Channels []ChannelConfig `json:"channels"`
+	Silences []Silence       `json:"silences"`
+	Retry    RetryConfig     `json:"retry"`
+}
+
+// LoadConfig reads the notification settings from a JSON file
+func LoadConfig(filename string) (*Config, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read notifications config: %v", err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse notifications config: %v", err)
+	}
+	for i := range cfg.Silences {
+		if err := cfg.Silences[i].validate(); err != nil {
+			return nil, err
+		}
+	}
+	return &cfg, nil
+}
+
+// newNotifier creates the notifier for a channel
+func newNotifier(cfg ChannelConfig, client *http.Client) (Notifier, error) {
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("notification channel of type %q has no name", cfg.Type)
+	}
+
+	switch cfg.Type {
+	case TypeWebhook:
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("channel %s: webhook needs a url", cfg.Name)
+		}
+		return NewWebhookNotifier(cfg.Name, cfg.URL, cfg.Secret, cfg.Template, cfg.Headers, client)
+	case TypeSlack:
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("channel %s: slack needs a url", cfg.Name)
+		}
+		return NewSlackNotifier(cfg.Name, cfg.URL, cfg.Channel, cfg.Username, client), nil
+	case TypeEmail:
+		if cfg.Host == "" || cfg.From == "" || len(cfg.To) == 0 {
+			return nil, fmt.Errorf("channel %s: email needs host, from and to", cfg.Name)
+		}
+		port := cfg.Port
+		if port == 0 {
+			port = 25
+		}
+		return NewEmailNotifier(cfg.Name, cfg.Host, port, cfg.SMTPUsername, cfg.SMTPPassword, cfg.From, cfg.To), nil
+	}
+	return nil, fmt.Errorf("channel %s: unknown type %q", cfg.Name, cfg.Type)
+}
+
+// Silence mutes the matching alerts during a time window. The window is either absolute
+// with Start and End, or daily with From and To in "15:04" format, which may wrap past midnight.
+// Empty matchers match everything, rule and container are glob patterns.
+type Silence struct {
+	Rule      string    `json:"rule,omitempty"`
+	Container string    `json:"container,omitempty"`
+	Severity  string    `json:"severity,omitempty"`
+	Start     time.Time `json:"start,omitempty"`
+	End       time.Time `json:"end,omitempty"`
+	From      string    `json:"from,omitempty"`
+	To        string    `json:"to,omitempty"`
+	Comment   string    `json:"comment,omitempty"`
+
+	from, to time.Duration
+}
+
+// validate parses the daily window
+func (s *Silence) validate() error {
+	if s.From == "" && s.To == "" {
+		if s.Start.IsZero() && s.End.IsZero() {
+			return fmt.Errorf("silence %q needs start/end or from/to", s.Comment)
+		}
+		return nil
+	}
+
+	from, err := time.Parse("15:04", s.From)
+	if err != nil {
+		return fmt.Errorf("silence %q: invalid from: %v", s.Comment, err)
+	}
+	to, err := time.Parse("15:04", s.To)
+	if err != nil {
+		return fmt.Errorf("silence %q: invalid to: %v", s.Comment, err)
+	}
+	s.from = time.Duration(from.Hour())*time.Hour + time.Duration(from.Minute())*time.Minute
+	s.to = time.Duration(to.Hour())*time.Hour + time.Duration(to.Minute())*time.Minute
+	return nil
+}
+
+// Matches reports whether the alert is silenced at the given time
+func (s *Silence) Matches(alert alerting.Alert, now time.Time) bool {
+	if s.Rule != "" && !globMatch(s.Rule, alert.Rule) {
+		return false
+	}
+	if s.Container != "" && !globMatch(s.Container, alert.ContainerName) && s.Container != alert.ContainerID {
+		return false
+	}
+	if s.Severity != "" && s.Severity != alert.Severity {
+		return false
+	}
+
+	if s.From != "" || s.To != "" {
+		midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		offset := now.Sub(midnight)
+		if s.from <= s.to {
+			return offset >= s.from && offset < s.to
+		}
+		return offset >= s.from || offset < s.to
+	}
+
+	if !s.Start.IsZero() && now.Before(s.Start) {
+		return false
+	}
+	if !s.End.IsZero() && !now.Before(s.End) {
+		return false
+	}
+	return true
+}
+
+// globMatch matches a glob pattern, an invalid pattern only matches itself
+func globMatch(pattern, value string) bool {
+	matched, err := path.Match(pattern, value)
+	if err != nil {
+		return pattern == value
+	}
+	return matched
+}
@@ -0,0 +1,118 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/nox/noxflow/server-gRPC/pkg/alerting"
+)
+
+// SlackNotifier posts alerts to a Slack or Mattermost incoming webhook
+type SlackNotifier struct {
+	name     string
+	url      string
+	channel  string
+	username string
+	client   *http.Client
+}
+
+type slackMessage struct {
+	Channel     string            `json:"channel,omitempty"`
+	Username    string            `json:"username,omitempty"`
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments,omitempty"`
+}
+
+type slackAttachment struct {
+	Color  string       `json:"color"`
+	Title  string       `json:"title"`
+	Text   string       `json:"text,omitempty"`
+	Fields []slackField `json:"fields,omitempty"`
+}
+
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+// NewSlackNotifier creates a notifier for an incoming webhook, channel and username are optional overrides
+func NewSlackNotifier(name, url, channel, username string, client *http.Client) *SlackNotifier {
+	return &SlackNotifier{
+		name:     name,
+		url:      url,
+		channel:  channel,
+		username: username,
+		client:   client,
+	}
+}
+
+// Name implements Notifier
+func (n *SlackNotifier) Name() string {
+	return n.name
+}
+
+// Notify implements Notifier
+func (n *SlackNotifier) Notify(ctx context.Context, alert alerting.Alert) error {
+	body, err := json.Marshal(n.message(alert))
+	if err != nil {
+		return fmt.Errorf("error marshaling message: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return send(n.client, req)
+}
+
+// message formats the alert using the attachment format both Slack and Mattermost accept
+func (n *SlackNotifier) message(alert alerting.Alert) *slackMessage {
+	color := "warning"
+	switch {
+	case alert.State == alerting.StateResolved:
+		color = "good"
+	case alert.Severity == alerting.SeverityCritical:
+		color = "danger"
+	}
+
+	attachment := slackAttachment{
+		Color: color,
+		Title: fmt.Sprintf("[%s] %s", strings.ToUpper(alert.State), alert.Rule),
+		Text:  alert.Summary,
+		Fields: []slackField{
+			{Title: "Container", Value: containerName(alert), Short: true},
+			{Title: "Severity", Value: alert.Severity, Short: true},
+		},
+	}
+	if alert.Image != "" {
+		attachment.Fields = append(attachment.Fields, slackField{Title: "Image", Value: alert.Image, Short: true})
+	}
+	if len(alert.Samples) > 0 {
+		attachment.Fields = append(attachment.Fields, slackField{
+			Title: "Sample lines",
+			Value: "```\n" + strings.Join(alert.Samples, "\n") + "\n```",
+		})
+	}
+
+	return &slackMessage{
+		Channel:     n.channel,
+		Username:    n.username,
+		Text:        fmt.Sprintf("%s alert %s %s", alert.Severity, alert.Rule, alert.State),
+		Attachments: []slackAttachment{attachment},
+	}
+}
+
+// containerName names the alert's container for humans
+func containerName(alert alerting.Alert) string {
+	if alert.ContainerName != "" {
+		return alert.ContainerName
+	}
+	return alert.ContainerID
+}
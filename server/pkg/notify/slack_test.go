@@ -0,0 +1,140 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/nox/noxflow/server-gRPC/pkg/alerting"
+)
+
+func TestSlackNotifierPayload(t *testing.T) {
+	firing := testAlert()
+
+	warning := testAlert()
+	warning.Severity = alerting.SeverityWarning
+	warning.Image = ""
+	warning.ContainerName = ""
+
+	resolved := testAlert()
+	resolved.Kind = alerting.KindLog
+	resolved.Rule = "errors"
+	resolved.State = alerting.StateResolved
+	resolved.Samples = []string{"ERROR db timeout", "ERROR db timeout"}
+
+	tests := []struct {
+		name  string
+		alert alerting.Alert
+		want  slackMessage
+	}{
+		{
+			name:  "critical firing",
+			alert: firing,
+			want: slackMessage{
+				Channel:  "#alerts",
+				Username: "noxflow",
+				Text:     "critical alert high-cpu firing",
+				Attachments: []slackAttachment{{
+					Color: "danger",
+					Title: "[FIRING] high-cpu",
+					Text:  firing.Summary,
+					Fields: []slackField{
+						{Title: "Container", Value: "api", Short: true},
+						{Title: "Severity", Value: "critical", Short: true},
+						{Title: "Image", Value: "nginx:1.25", Short: true},
+					},
+				}},
+			},
+		},
+		{
+			name:  "warning without name or image",
+			alert: warning,
+			want: slackMessage{
+				Channel:  "#alerts",
+				Username: "noxflow",
+				Text:     "warning alert high-cpu firing",
+				Attachments: []slackAttachment{{
+					Color: "warning",
+					Title: "[FIRING] high-cpu",
+					Text:  warning.Summary,
+					Fields: []slackField{
+						{Title: "Container", Value: "0123456789ab", Short: true},
+						{Title: "Severity", Value: "warning", Short: true},
+					},
+				}},
+			},
+		},
+		{
+			name:  "resolved with samples",
+			alert: resolved,
+			want: slackMessage{
+				Channel:  "#alerts",
+				Username: "noxflow",
+				Text:     "critical alert errors resolved",
+				Attachments: []slackAttachment{{
+					Color: "good",
+					Title: "[RESOLVED] errors",
+					Text:  resolved.Summary,
+					Fields: []slackField{
+						{Title: "Container", Value: "api", Short: true},
+						{Title: "Severity", Value: "critical", Short: true},
+						{Title: "Image", Value: "nginx:1.25", Short: true},
+						{Title: "Sample lines", Value: "```\nERROR db timeout\nERROR db timeout\n```"},
+					},
+				}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := recordServer(t, http.StatusOK)
+			notifier := NewSlackNotifier("slack", server.URL, "#alerts", "noxflow", server.Client())
+
+			if err := notifier.Notify(context.Background(), tt.alert); err != nil {
+				t.Fatalf("Notify() error = %v", err)
+			}
+			received := <-requests
+
+			if got := received.header.Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", got)
+			}
+			var got slackMessage
+			if err := json.Unmarshal(received.body, &got); err != nil {
+				t.Fatalf("payload is not a Slack message: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("payload = %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSlackNotifierOmitsEmptyOverrides(t *testing.T) {
+	server, requests := recordServer(t, http.StatusOK)
+	notifier := NewSlackNotifier("slack", server.URL, "", "", server.Client())
+
+	if err := notifier.Notify(context.Background(), testAlert()); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+	var payload map[string]any
+	if err := json.Unmarshal((<-requests).body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"channel", "username"} {
+		if _, ok := payload[key]; ok {
+			t.Errorf("payload has %q without an override, the webhook default must apply", key)
+		}
+	}
+}
+
+func TestSlackNotifierStatus(t *testing.T) {
+	server, _ := recordServer(t, http.StatusNotFound)
+	notifier := NewSlackNotifier("slack", server.URL, "", "", server.Client())
+
+	if err := notifier.Notify(context.Background(), testAlert()); err == nil {
+		t.Fatal("Notify() succeeded on a 404")
+	}
+}
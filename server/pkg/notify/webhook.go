@@ -0,0 +1,126 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"text/template"
+
+	"github.com/nox/noxflow/server-gRPC/pkg/alerting"
+)
+
+// SignatureHeader carries the HMAC-SHA256 of the webhook body when a secret is configured
+const SignatureHeader = "X-NoxFlow-Signature"
+
+// WebhookNotifier posts alerts as JSON to a URL. The body is the alert itself unless
+// a template is given, and it is signed with HMAC-SHA256 when a secret is set.
+type WebhookNotifier struct {
+	name     string
+	url      string
+	secret   []byte
+	headers  map[string]string
+	template *template.Template
+	client   *http.Client
+}
+
+// templateFuncs are available in webhook body templates
+var templateFuncs = template.FuncMap{
+	"json": func(value any) (string, error) {
+		data, err := json.Marshal(value)
+		return string(data), err
+	},
+}
+
+// NewWebhookNotifier creates a webhook notifier, bodyTemplate may be empty
+func NewWebhookNotifier(name, url, secret, bodyTemplate string, headers map[string]string, client *http.Client) (*WebhookNotifier, error) {
+	notifier := &WebhookNotifier{
+		name:    name,
+		url:     url,
+		secret:  []byte(secret),
+		headers: headers,
+		client:  client,
+	}
+
+	if bodyTemplate != "" {
+		parsed, err := template.New(name).Funcs(templateFuncs).Parse(bodyTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid body template for %s: %v", name, err)
+		}
+		notifier.template = parsed
+	}
+
+	return notifier, nil
+}
+
+// Name implements Notifier
+func (n *WebhookNotifier) Name() string {
+	return n.name
+}
+
+// Notify implements Notifier
+func (n *WebhookNotifier) Notify(ctx context.Context, alert alerting.Alert) error {
+	body, err := n.render(alert)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range n.headers {
+		req.Header.Set(key, value)
+	}
+	if len(n.secret) > 0 {
+		req.Header.Set(SignatureHeader, "sha256="+Sign(n.secret, body))
+	}
+
+	return send(n.client, req)
+}
+
+// render builds the request body for the alert
+func (n *WebhookNotifier) render(alert alerting.Alert) ([]byte, error) {
+	if n.template == nil {
+		return json.Marshal(alert)
+	}
+
+	var body bytes.Buffer
+	if err := n.template.Execute(&body, alert); err != nil {
+		return nil, fmt.Errorf("error rendering body template: %v", err)
+	}
+	if !json.Valid(body.Bytes()) {
+		return nil, fmt.Errorf("body template of %s did not produce valid JSON", n.name)
+	}
+	return body.Bytes(), nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of the body, receivers can use it to verify webhooks
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// send performs the request and treats any non 2xx response as an error
+func send(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Drain the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package notify
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nox/noxflow/server-gRPC/pkg/alerting"
+)
+
+// testAlert is a firing alert with every field set
+func testAlert() alerting.Alert {
+	started := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	return alerting.Alert{
+		ID:            7,
+		Fingerprint:   "usage/high-cpu/0123456789ab",
+		Rule:          "high-cpu",
+		Kind:          alerting.KindUsage,
+		Severity:      alerting.SeverityCritical,
+		State:         alerting.StateFiring,
+		ContainerID:   "0123456789ab",
+		ContainerName: "api",
+		Image:         "nginx:1.25",
+		Value:         97.5,
+		Summary:       "cpu_percent is 97.50 on api (cpu_percent > 90)",
+		StartedAt:     started,
+		FiredAt:       started.Add(5 * time.Minute),
+	}
+}
+
+// request is what a test server received
+type request struct {
+	header http.Header
+	body   []byte
+}
+
+// recordServer answers every request with status and sends what it received to the channel
+func recordServer(t *testing.T, status int) (*httptest.Server, chan request) {
+	t.Helper()
+	requests := make(chan request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- request{header: r.Header.Clone(), body: body}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func TestWebhookNotifierSignsTheBody(t *testing.T) {
+	server, requests := recordServer(t, http.StatusOK)
+	notifier, err := NewWebhookNotifier("hook", server.URL, "s3cret", "", map[string]string{"X-Team": "ops"}, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alert := testAlert()
+	if err := notifier.Notify(context.Background(), alert); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+	received := <-requests
+
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(received.body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if got := received.header.Get(SignatureHeader); got != want {
+		t.Errorf("%s = %q, want %q", SignatureHeader, got, want)
+	}
+	if got := received.header.Get("X-Team"); got != "ops" {
+		t.Errorf("X-Team = %q, want %q", got, "ops")
+	}
+	if got := received.header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+
+	var body alerting.Alert
+	if err := json.Unmarshal(received.body, &body); err != nil {
+		t.Fatalf("body is not an alert: %v", err)
+	}
+	if body.Fingerprint != alert.Fingerprint || body.State != alert.State || body.Value != alert.Value {
+		t.Errorf("body = %+v, want %+v", body, alert)
+	}
+}
+
+func TestWebhookNotifierWithoutSecret(t *testing.T) {
+	server, requests := recordServer(t, http.StatusOK)
+	notifier, err := NewWebhookNotifier("hook", server.URL, "", "", nil, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := notifier.Notify(context.Background(), testAlert()); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+	if got := (<-requests).header.Get(SignatureHeader); got != "" {
+		t.Errorf("%s = %q without a secret, want none", SignatureHeader, got)
+	}
+}
+
+func TestWebhookNotifierTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		// parseErr is set when NewWebhookNotifier must reject the template
+		parseErr bool
+		// notifyErr is set when the rendered body must be rejected before sending
+		notifyErr bool
+		want      string
+	}{
+		{
+			name:     "fields and json function",
+			template: `{"text":{{json .Summary}},"rule":"{{.Rule}}","value":{{.Value}}}`,
+			// encoding/json escapes the HTML characters
+			want: `{"text":"cpu_percent is 97.50 on api (cpu_percent \u003e 90)","rule":"high-cpu","value":97.5}`,
+		},
+		{
+			name:     "invalid template syntax",
+			template: `{"text":"{{.Summary"}`,
+			parseErr: true,
+		},
+		{
+			name:      "unknown field",
+			template:  `{"text":"{{.Missing}}"}`,
+			notifyErr: true,
+		},
+		{
+			name:      "body is not JSON",
+			template:  `alert {{.Rule}} is {{.State}}`,
+			notifyErr: true,
+		},
+		{
+			name:      "unquoted string breaks the JSON",
+			template:  `{"text":{{.Summary}}}`,
+			notifyErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := recordServer(t, http.StatusOK)
+			notifier, err := NewWebhookNotifier("hook", server.URL, "", tt.template, nil, server.Client())
+			if tt.parseErr {
+				if err == nil {
+					t.Fatal("NewWebhookNotifier() accepted an invalid template")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewWebhookNotifier() error = %v", err)
+			}
+
+			err = notifier.Notify(context.Background(), testAlert())
+			if tt.notifyErr {
+				if err == nil {
+					t.Fatal("Notify() sent an invalid body")
+				}
+				if len(requests) != 0 {
+					t.Error("an invalid body reached the server")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Notify() error = %v", err)
+			}
+			if got := string((<-requests).body); got != tt.want {
+				t.Errorf("body = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhookNotifierStatus(t *testing.T) {
+	tests := []struct {
+		status  int
+		wantErr bool
+	}{
+		{status: http.StatusOK},
+		{status: http.StatusNoContent},
+		{status: http.StatusMovedPermanently, wantErr: true},
+		{status: http.StatusBadRequest, wantErr: true},
+		{status: http.StatusServiceUnavailable, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			server, _ := recordServer(t, tt.status)
+			client := server.Client()
+			client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+			notifier, err := NewWebhookNotifier("hook", server.URL, "", "", nil, client)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = notifier.Notify(context.Background(), testAlert())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Notify() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), "status code") {
+				t.Errorf("Notify() error = %v, want the status code", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/nox/noxflow/server-gRPC/pkg/alerting"
+	"github.com/nox/noxflow/server-gRPC/pkg/metrics"
+	"github.com/nox/noxflow/server-gRPC/utils"
+)
+
+type AgentServer struct {
+	UnimplementedAgentServiceServer
+	dbClient *utils.DatabaseClient
+	alerts   *alerting.Engine
+}
+
+// Register records an agent and its container inventory
+func (s *AgentServer) Register(ctx context.Context, registration *AgentRegistration) (*RegisterResponse, error) {
+	agent := registration.AgentId
+	if agent == "" {
+		agent = agentID(ctx)
+	}
+	seen := time.Now()
+
+	err := s.dbClient.SaveAgent(&utils.AgentData{
+		AgentID:       agent,
+		Hostname:      registration.Hostname,
+		AgentVersion:  registration.AgentVersion,
+		DockerVersion: registration.DockerVersion,
+		LastSeen:      seen,
+	})
+	if err != nil {
+		metrics.DatabaseErrors.WithLabelValues("save_agent").Inc()
+		slog.Error("Error registering agent", "agent", agent, "error", err)
+		return nil, fmt.Errorf("failed to register agent: %v", err)
+	}
+
+	s.alerts.UpdateContainers(toAlertContainers(registration.Containers))
+	if err := s.dbClient.SaveContainers(agent, seen, toContainerData(agent, registration.Containers)); err != nil {
+		metrics.DatabaseErrors.WithLabelValues("save_containers").Inc()
+		slog.Error("Error saving container inventory", "agent", agent, "error", err)
+		return nil, fmt.Errorf("failed to save container inventory: %v", err)
+	}
+
+	slog.Info("Agent registered",
+		"agent", agent,
+		"hostname", registration.Hostname,
+		"agent_version", registration.AgentVersion,
+		"docker_version", registration.DockerVersion,
+		"containers", len(registration.Containers))
+
+	return &RegisterResponse{
+		Message: fmt.Sprintf("Registered agent %s", agent),
+	}, nil
+}
+
+// Heartbeat stores the collector statuses and container inventory reported by an agent
+func (s *AgentServer) Heartbeat(ctx context.Context, heartbeat *AgentHeartbeat) (*HeartbeatResponse, error) {
+	agent := heartbeat.AgentId
+	if agent == "" {
+		agent = agentID(ctx)
+	}
+
+	// The server clock decides whether an agent went dark, so agent clock skew doesn't matter
+	seen := time.Now()
+	if err := s.dbClient.TouchAgent(agent, seen); err != nil {
+		metrics.DatabaseErrors.WithLabelValues("touch_agent").Inc()
+		slog.Error("Error updating agent last seen", "agent", agent, "error", err)
+		return nil, fmt.Errorf("failed to save heartbeat: %v", err)
+	}
+
+	if len(heartbeat.Containers) > 0 {
+		s.alerts.UpdateContainers(toAlertContainers(heartbeat.Containers))
+		if err := s.dbClient.SaveContainers(agent, seen, toContainerData(agent, heartbeat.Containers)); err != nil {
+			metrics.DatabaseErrors.WithLabelValues("save_containers").Inc()
+			slog.Error("Error saving container inventory", "agent", agent, "error", err)
+			return nil, fmt.Errorf("failed to save container inventory: %v", err)
+		}
+	}
+
+	reportedAt := time.Now()
+	if heartbeat.Timestamp > 0 {
+		reportedAt = time.UnixMilli(heartbeat.Timestamp)
+	}
+
+	statuses := make([]utils.CollectorStatusData, 0, len(heartbeat.Collectors))
+	for _, collector := range heartbeat.Collectors {
+		status := utils.CollectorStatusData{
+			ContainerID:   collector.ContainerId,
+			ContainerName: collector.ContainerName,
+			Kind:          collector.Kind,
+			State:         collector.State,
+			LinesShipped:  collector.LinesShipped,
+			BytesShipped:  collector.BytesShipped,
+			SendErrors:    collector.SendErrors,
+			Restarts:      collector.Restarts,
+			LagMs:         collector.LagMs,
+			LastError:     collector.LastError,
+		}
+		if collector.LastSent > 0 {
+			status.LastSent = time.UnixMilli(collector.LastSent)
+		}
+		statuses = append(statuses, status)
+	}
+
+	if err := s.dbClient.SaveCollectorStatuses(agent, reportedAt, statuses); err != nil {
+		metrics.DatabaseErrors.WithLabelValues("save_collector_statuses").Inc()
+		slog.Error("Error saving collector statuses", "agent", agent, "error", err)
+		return nil, fmt.Errorf("failed to save heartbeat: %v", err)
+	}
+
+	return &HeartbeatResponse{
+		Message: fmt.Sprintf("Received heartbeat from agent %s", agent),
+	}, nil
+}
+
+// toContainerData converts the reported containers into inventory rows
+func toContainerData(agent string, containers []*ContainerInfo) []utils.ContainerData {
+	inventory := make([]utils.ContainerData, 0, len(containers))
+	for _, container := range containers {
+		data := utils.ContainerData{
+			AgentID:     agent,
+			ContainerID: container.ContainerId,
+			Name:        strings.TrimPrefix(container.Name, "/"),
+			Image:       container.Image,
+			State:       container.State,
+			Labels:      container.Labels,
+		}
+		if container.Created > 0 {
+			data.CreatedAt = time.Unix(container.Created, 0)
+		}
+		inventory = append(inventory, data)
+	}
+	return inventory
+}
+
+// toAlertContainers converts the reported containers into what the alert selectors match against
+func toAlertContainers(containers []*ContainerInfo) []alerting.ContainerInfo {
+	infos := make([]alerting.ContainerInfo, 0, len(containers))
+	for _, container := range containers {
+		infos = append(infos, alerting.ContainerInfo{
+			ID:     container.ContainerId,
+			Name:   strings.TrimPrefix(container.Name, "/"),
+			Image:  container.Image,
+			Labels: container.Labels,
+		})
+	}
+	return infos
+}
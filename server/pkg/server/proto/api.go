@@ -0,0 +1,397 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nox/noxflow/server-gRPC/pkg/alerting"
+	"github.com/nox/noxflow/server-gRPC/utils"
+)
+
+// defaultDarkAfter is how long an agent can stay silent before it is reported as dark
+const defaultDarkAfter = 2 * time.Minute
+
+// apiHandler serves the server's JSON API
+type apiHandler struct {
+	dbClient *utils.DatabaseClient
+	alerts   *alerting.Engine
+}
+
+// defaultAlertLimit is how many alerts are returned when no limit is given
+const defaultAlertLimit = 100
+
+// defaultEventLimit is how many container events are returned when no limit is given
+const defaultEventLimit = 500
+
+// defaultLogLimit is how many logs are returned when no limit is given
+const defaultLogLimit = 200
+
+// defaultUsageLimit is how many usage samples are returned when no limit is given
+const defaultUsageLimit = 1000
+
+// defaultSeriesRange is the time range of a usage series query without since
+const defaultSeriesRange = time.Hour
+
+// defaultSeriesPoints is how many points a usage series has when no step is given
+const defaultSeriesPoints = 300
+
+// maxSeriesPoints bounds the number of points of a usage series
+const maxSeriesPoints = 10000
+
+// registerAPI adds the API routes to the mux
+func registerAPI(mux *http.ServeMux, dbClient *utils.DatabaseClient, alerts *alerting.Engine) {
+	api := &apiHandler{dbClient: dbClient, alerts: alerts}
+	mux.HandleFunc("GET /api/agents", api.listAgents)
+	mux.HandleFunc("GET /api/containers", api.listContainers)
+	mux.HandleFunc("GET /api/alerts", api.listAlerts)
+	mux.HandleFunc("GET /api/alerts/active", api.listActiveAlerts)
+	mux.HandleFunc("GET /api/events", api.listEvents)
+	mux.HandleFunc("GET /api/logs", api.searchLogs)
+	mux.HandleFunc("GET /api/usage", api.listUsage)
+	mux.HandleFunc("GET /api/usage/latest", api.latestUsage)
+	mux.HandleFunc("GET /api/usage/series", api.usageSeries)
+}
+
+// listAgents returns every agent and whether it is still reporting
+func (a *apiHandler) listAgents(w http.ResponseWriter, r *http.Request) {
+	darkAfter := defaultDarkAfter
+	if value := r.URL.Query().Get("dark_after"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid dark_after duration")
+			return
+		}
+		darkAfter = parsed
+	}
+
+	agents, err := a.dbClient.ListAgents(darkAfter)
+	if err != nil {
+		slog.Error("Error listing agents", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to list agents")
+		return
+	}
+	writeJSON(w, http.StatusOK, agents)
+}
+
+// listContainers returns the current container inventory of every agent
+func (a *apiHandler) listContainers(w http.ResponseWriter, r *http.Request) {
+	containers, err := a.dbClient.ListContainers()
+	if err != nil {
+		slog.Error("Error listing containers", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to list containers")
+		return
+	}
+	writeJSON(w, http.StatusOK, containers)
+}
+
+// listAlerts returns the alert history, optionally filtered by state
+func (a *apiHandler) listAlerts(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryLimit(r.URL.Query(), defaultAlertLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	alerts, err := a.dbClient.ListAlerts(r.URL.Query().Get("state"), limit)
+	if err != nil {
+		slog.Error("Error listing alerts", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to list alerts")
+		return
+	}
+	writeJSON(w, http.StatusOK, alerts)
+}
+
+// listActiveAlerts returns the pending and firing alerts
+func (a *apiHandler) listActiveAlerts(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.alerts.Active())
+}
+
+// listEvents returns the container lifecycle events, filtered by container and time range.
+// since and until are RFC 3339 timestamps.
+func (a *apiHandler) listEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := utils.EventFilter{
+		Container: query.Get("container"),
+	}
+
+	var err error
+	if filter.Limit, err = queryLimit(query, defaultEventLimit); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if filter.Since, filter.Until, err = queryTimeRange(query); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	events, err := a.dbClient.ListEvents(filter)
+	if err != nil {
+		slog.Error("Error listing container events", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to list container events")
+		return
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+// searchLogs returns the stored logs of a container, optionally filtered by a case
+// insensitive search string and a time range. after_id returns the logs stored after
+// that one, which is how the dashboard follows new logs.
+func (a *apiHandler) searchLogs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	logQuery := utils.LogQuery{
+		Container: query.Get("container"),
+		Search:    query.Get("q"),
+	}
+
+	var err error
+	if logQuery.Limit, err = queryLimit(query, defaultLogLimit); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if logQuery.Since, logQuery.Until, err = queryTimeRange(query); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if value := query.Get("after_id"); value != "" {
+		logQuery.AfterID, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || logQuery.AfterID < 0 {
+			writeError(w, http.StatusBadRequest, "invalid after_id")
+			return
+		}
+	}
+
+	logs, err := a.dbClient.SearchLogs(logQuery)
+	if err != nil {
+		slog.Error("Error searching logs", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to search logs")
+		return
+	}
+	writeJSON(w, http.StatusOK, logs)
+}
+
+// listUsage returns the usage samples of a container over a time range
+func (a *apiHandler) listUsage(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := utils.UsageFilter{ContainerID: query.Get("container")}
+	if filter.ContainerID == "" {
+		writeError(w, http.StatusBadRequest, "missing container")
+		return
+	}
+
+	var err error
+	if filter.Limit, err = queryLimit(query, defaultUsageLimit); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if filter.Since, filter.Until, err = queryTimeRange(query); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	points, err := a.dbClient.ListUsage(filter)
+	if err != nil {
+		slog.Error("Error listing usage", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to list usage")
+		return
+	}
+	writeJSON(w, http.StatusOK, points)
+}
+
+// latestUsage returns the latest usage sample of every current container
+func (a *apiHandler) latestUsage(w http.ResponseWriter, r *http.Request) {
+	usage, err := a.dbClient.LatestUsage()
+	if err != nil {
+		slog.Error("Error listing latest usage", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to list latest usage")
+		return
+	}
+	writeJSON(w, http.StatusOK, usage)
+}
+
+// usageSeriesResponse is the response of the usage series API
+type usageSeriesResponse struct {
+	Since   time.Time           `json:"since"`
+	Until   time.Time           `json:"until"`
+	Step    float64             `json:"step_seconds"`
+	Agg     string              `json:"agg"`
+	GroupBy string              `json:"group_by"`
+	Series  []utils.UsageSeries `json:"series"`
+	// Resolution is the resolution the series were read from: raw, 1m or 1h
+	Resolution string `json:"resolution"`
+}
+
+// usageSeries returns the usage metrics of the selected containers over a time range,
+// aggregated per step and rolled up per group of containers. The containers are selected
+// with container (name glob or ID prefix), image (glob) and label (key=value, or key for
+// any value), metric is a comma separated list, step a duration, agg one of avg, min,
+// max, last, p50, p95 or p99 and group_by one of container, id, image, all or label:<key>.
+func (a *apiHandler) usageSeries(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	seriesQuery := utils.UsageSeriesQuery{
+		Selector: alerting.Selector{
+			ContainerName: query.Get("container"),
+			Image:         query.Get("image"),
+		},
+		Metrics:     queryValues(query, "metric"),
+		Aggregation: query.Get("agg"),
+		GroupBy:     query.Get("group_by"),
+	}
+
+	for _, label := range queryValues(query, "label") {
+		key, value, found := strings.Cut(label, "=")
+		if !found {
+			value = "*"
+		}
+		if seriesQuery.Selector.Labels == nil {
+			seriesQuery.Selector.Labels = make(map[string]string)
+		}
+		seriesQuery.Selector.Labels[key] = value
+	}
+
+	if len(seriesQuery.Metrics) == 0 {
+		seriesQuery.Metrics = []string{"cpu_percent", "memory_usage"}
+	}
+	for _, metric := range seriesQuery.Metrics {
+		if _, ok := utils.UsageMetrics[metric]; !ok {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown metric %q", metric))
+			return
+		}
+	}
+	if seriesQuery.Aggregation == "" {
+		seriesQuery.Aggregation = "avg"
+	}
+	if _, ok := utils.UsageAggregations[seriesQuery.Aggregation]; !ok {
+		writeError(w, http.StatusBadRequest, "invalid agg: expected avg, min, max, last, p50, p95 or p99")
+		return
+	}
+	if seriesQuery.GroupBy == "" {
+		seriesQuery.GroupBy = utils.GroupByContainer
+	}
+	if err := utils.ValidateGroupBy(seriesQuery.GroupBy); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var err error
+	if seriesQuery.Since, seriesQuery.Until, err = queryTimeRange(query); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if seriesQuery.Until.IsZero() {
+		seriesQuery.Until = time.Now()
+	}
+	if seriesQuery.Since.IsZero() {
+		seriesQuery.Since = seriesQuery.Until.Add(-defaultSeriesRange)
+	}
+	timeRange := seriesQuery.Until.Sub(seriesQuery.Since)
+	if timeRange <= 0 {
+		writeError(w, http.StatusBadRequest, "since must be before until")
+		return
+	}
+
+	if value := query.Get("step"); value != "" {
+		seriesQuery.Step, err = time.ParseDuration(value)
+		if err != nil || seriesQuery.Step < time.Second {
+			writeError(w, http.StatusBadRequest, "invalid step: expected a duration of at least 1s")
+			return
+		}
+	} else {
+		seriesQuery.Step = defaultStep(timeRange)
+	}
+	if timeRange/seriesQuery.Step > maxSeriesPoints {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("step too small: the series would have more than %d points", maxSeriesPoints))
+		return
+	}
+
+	series, resolution, err := a.dbClient.QueryUsageSeries(seriesQuery)
+	if err != nil {
+		slog.Error("Error querying usage series", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to query usage series")
+		return
+	}
+	writeJSON(w, http.StatusOK, usageSeriesResponse{
+		Since:      seriesQuery.Since,
+		Until:      seriesQuery.Until,
+		Step:       seriesQuery.Step.Seconds(),
+		Resolution: resolution,
+		Agg:        seriesQuery.Aggregation,
+		GroupBy:    seriesQuery.GroupBy,
+		Series:     series,
+	})
+}
+
+// defaultStep returns the step giving about defaultSeriesPoints points over the time
+// range, rounded up to whole minutes or hours so the query can read the rollups
+func defaultStep(timeRange time.Duration) time.Duration {
+	step := timeRange / defaultSeriesPoints
+	unit := time.Second
+	switch {
+	case step >= time.Hour:
+		unit = time.Hour
+	case step >= time.Minute:
+		unit = time.Minute
+	}
+	return max((step+unit-1)/unit*unit, time.Second)
+}
+
+// queryValues returns the values of a repeated or comma separated query parameter
+func queryValues(query url.Values, key string) []string {
+	var values []string
+	for _, value := range query[key] {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+	}
+	return values
+}
+
+// queryLimit reads the limit query parameter, returning fallback when it isn't set
+func queryLimit(query url.Values, fallback int) (int, error) {
+	value := query.Get("limit")
+	if value == "" {
+		return fallback, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 {
+		return 0, fmt.Errorf("invalid limit")
+	}
+	return limit, nil
+}
+
+// queryTimeRange reads the since and until query parameters as RFC 3339 timestamps,
+// a missing parameter is returned as the zero time
+func queryTimeRange(query url.Values) (since, until time.Time, err error) {
+	if value := query.Get("since"); value != "" {
+		if since, err = time.Parse(time.RFC3339, value); err != nil {
+			return since, until, fmt.Errorf("invalid since timestamp")
+		}
+	}
+	if value := query.Get("until"); value != "" {
+		if until, err = time.Parse(time.RFC3339, value); err != nil {
+			return since, until, fmt.Errorf("invalid until timestamp")
+		}
+	}
+	return since, until, nil
+}
+
+// writeJSON writes the value as a JSON response
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		slog.Error("Error writing response", "error", err)
+	}
+}
+
+// writeError writes an error message as a JSON response
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
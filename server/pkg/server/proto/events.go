@@ -0,0 +1,84 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/nox/noxflow/server-gRPC/pkg/alerting"
+	"github.com/nox/noxflow/server-gRPC/pkg/metrics"
+	"github.com/nox/noxflow/server-gRPC/utils"
+)
+
+type EventStreamingServer struct {
+	UnimplementedEventStreamingServiceServer
+	dbClient *utils.DatabaseClient
+	alerts   *alerting.Engine
+}
+
+// StreamEvents implements the bidirectional streaming RPC for container lifecycle events
+func (s *EventStreamingServer) StreamEvents(stream EventStreamingService_StreamEventsServer) error {
+	agent := agentID(stream.Context())
+	metrics.ActiveStreams.WithLabelValues("events").Inc()
+	defer metrics.ActiveStreams.WithLabelValues("events").Dec()
+
+	for {
+		// Receive the event from the client
+		event, err := stream.Recv()
+		if err != nil {
+			return fmt.Errorf("error receiving container event: %v", err)
+		}
+
+		metrics.EventsReceived.WithLabelValues(agent, event.Action).Inc()
+		slog.Debug("Received container event",
+			"agent", agent,
+			"container_id", event.ContainerId,
+			"action", event.Action,
+			"exit_code", event.ExitCode,
+			"health_status", event.HealthStatus)
+
+		if err := s.dbClient.SaveEvent(toEventData(agent, event)); err != nil {
+			slog.Error("Error saving container event to database", "error", err)
+		}
+
+		// A container that is no longer running is expected to stop logging
+		s.alerts.ObserveEvent(event.ContainerId, event.Action)
+
+		// Send response back to client
+		if err := stream.Send(&EventResponse{
+			Message: fmt.Sprintf("Received %s event from container %s", event.Action, event.ContainerId),
+		}); err != nil {
+			return fmt.Errorf("error sending response: %v", err)
+		}
+	}
+}
+
+// toEventData converts the gRPC event into a database row
+func toEventData(agent string, event *ContainerEvent) *utils.EventData {
+	timestamp := time.Now()
+	if event.Timestamp > 0 {
+		timestamp = time.Unix(0, event.Timestamp)
+	}
+
+	data := &utils.EventData{
+		Timestamp:     timestamp,
+		AgentID:       agent,
+		ContainerID:   event.ContainerId,
+		ContainerName: strings.TrimPrefix(event.ContainerName, "/"),
+		Image:         event.Image,
+		Action:        event.Action,
+		HealthStatus:  event.HealthStatus,
+		Attributes:    event.Attributes,
+	}
+
+	// Only die events carry an exit code, zero is a valid one
+	if _, ok := event.Attributes["exitCode"]; ok {
+		exitCode := event.ExitCode
+		data.ExitCode = &exitCode
+	}
+	if data.Attributes == nil {
+		data.Attributes = map[string]string{}
+	}
+	return data
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/nox/noxflow/server-gRPC/pkg/health"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// healthInterval is how often the readiness checks update the gRPC health status
+const healthInterval = 5 * time.Second
+
+// reportHealth sets the gRPC health status of the server and of every service from the
+// readiness checks every interval, until ctx is done
+func reportHealth(ctx context.Context, checks *health.Health, healthServer *grpchealth.Server, services []string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	serving := true
+	for {
+		status := healthpb.HealthCheckResponse_SERVING
+		if err := checks.Ready(ctx); err != nil {
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+			if serving {
+				slog.Warn("Server not ready", "error", err)
+			}
+			serving = false
+		} else {
+			if !serving {
+				slog.Info("Server ready again")
+			}
+			serving = true
+		}
+
+		// The empty service name is the health of the whole server
+		healthServer.SetServingStatus("", status)
+		for _, service := range services {
+			healthServer.SetServingStatus(service, status)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// CollectorStatusData is the health of a single agent collector as reported in a heartbeat
+type CollectorStatusData struct {
+	ContainerID   string
+	ContainerName string
+	Kind          string
+	State         string
+	LinesShipped  uint64
+	BytesShipped  uint64
+	SendErrors    uint64
+	Restarts      uint32
+	LastSent      time.Time
+	LagMs         int64
+	LastError     string
+}
+
+// SaveCollectorStatuses stores the latest collector statuses of an agent.
+// Heartbeats are infrequent so they are written directly instead of being batched.
+func (c *DatabaseClient) SaveCollectorStatuses(agentID string, reportedAt time.Time, statuses []CollectorStatusData) error {
+	tx, err := c.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO agent_collectors (
+			agent_id, container_id, kind, container_name, state,
+			lines_shipped, bytes_shipped, send_errors, restarts,
+			last_sent, lag_ms, last_error, reported_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		ON CONFLICT (agent_id, container_id, kind) DO UPDATE SET
+			container_name = EXCLUDED.container_name,
+			state = EXCLUDED.state,
+			lines_shipped = EXCLUDED.lines_shipped,
+			bytes_shipped = EXCLUDED.bytes_shipped,
+			send_errors = EXCLUDED.send_errors,
+			restarts = EXCLUDED.restarts,
+			last_sent = EXCLUDED.last_sent,
+			lag_ms = EXCLUDED.lag_ms,
+			last_error = EXCLUDED.last_error,
+			reported_at = EXCLUDED.reported_at
+	`)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to prepare statement: %v", err)
+	}
+
+	for _, status := range statuses {
+		_, err := stmt.Exec(
+			agentID, status.ContainerID, status.Kind, status.ContainerName, status.State,
+			status.LinesShipped, status.BytesShipped, status.SendErrors, status.Restarts,
+			nullTime(status.LastSent), status.LagMs, status.LastError, reportedAt,
+		)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to save collector status: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	return nil
+}
+
+// AgentData describes an agent and when it last reported
+type AgentData struct {
+	AgentID       string    `json:"agent_id"`
+	Hostname      string    `json:"hostname"`
+	AgentVersion  string    `json:"agent_version"`
+	DockerVersion string    `json:"docker_version"`
+	RegisteredAt  time.Time `json:"registered_at"`
+	LastSeen      time.Time `json:"last_seen"`
+	Reporting     bool      `json:"reporting"`
+	Containers    int       `json:"containers"`
+}
+
+// ContainerData describes a container known to an agent
+type ContainerData struct {
+	AgentID     string            `json:"agent_id"`
+	ContainerID string            `json:"container_id"`
+	Name        string            `json:"name"`
+	Image       string            `json:"image"`
+	State       string            `json:"state"`
+	Labels      map[string]string `json:"labels"`
+	CreatedAt   time.Time         `json:"created_at"`
+	FirstSeen   time.Time         `json:"first_seen"`
+	LastSeen    time.Time         `json:"last_seen"`
+}
+
+// SaveAgent registers an agent or updates its details, an agent registering again keeps
+// the time it first registered
+func (c *DatabaseClient) SaveAgent(agent *AgentData) error {
+	_, err := c.db.Exec(`
+		INSERT INTO agents (agent_id, hostname, agent_version, docker_version, registered_at, last_seen)
+		VALUES ($1, $2, $3, $4, $5, $5)
+		ON CONFLICT (agent_id) DO UPDATE SET
+			hostname = EXCLUDED.hostname,
+			agent_version = EXCLUDED.agent_version,
+			docker_version = EXCLUDED.docker_version,
+			last_seen = EXCLUDED.last_seen
+	`, agent.AgentID, agent.Hostname, agent.AgentVersion, agent.DockerVersion, agent.LastSeen)
+	if err != nil {
+		return fmt.Errorf("failed to save agent: %v", err)
+	}
+	return nil
+}
+
+// TouchAgent updates the last time an agent was seen, agents that never registered
+// are recorded with the agent ID as hostname
+func (c *DatabaseClient) TouchAgent(agentID string, seen time.Time) error {
+	_, err := c.db.Exec(`
+		INSERT INTO agents (agent_id, hostname, agent_version, docker_version, registered_at, last_seen)
+		VALUES ($1, $1, '', '', $2, $2)
+		ON CONFLICT (agent_id) DO UPDATE SET last_seen = GREATEST(agents.last_seen, EXCLUDED.last_seen)
+	`, agentID, seen)
+	if err != nil {
+		return fmt.Errorf("failed to update agent last seen: %v", err)
+	}
+	return nil
+}
+
+// SaveContainers stores the container inventory reported by an agent
+func (c *DatabaseClient) SaveContainers(agentID string, seen time.Time, containers []ContainerData) error {
+	tx, err := c.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO agent_containers (
+			agent_id, container_id, name, image, state, labels, created_at, first_seen, last_seen
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8)
+		ON CONFLICT (agent_id, container_id) DO UPDATE SET
+			name = EXCLUDED.name,
+			image = EXCLUDED.image,
+			state = EXCLUDED.state,
+			labels = EXCLUDED.labels,
+			created_at = EXCLUDED.created_at,
+			last_seen = EXCLUDED.last_seen
+	`)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to prepare statement: %v", err)
+	}
+
+	for _, container := range containers {
+		labels, err := json.Marshal(container.Labels)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to marshal container labels: %v", err)
+		}
+
+		_, err = stmt.Exec(
+			agentID, container.ContainerID, container.Name, container.Image, container.State,
+			labels, nullTime(container.CreatedAt), seen,
+		)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to save container: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	return nil
+}
+
+// ListAgents returns every known agent. Agents that have not been seen within
+// darkAfter are reported as not reporting.
+func (c *DatabaseClient) ListAgents(darkAfter time.Duration) ([]AgentData, error) {
+	rows, err := c.db.Query(`
+		SELECT a.agent_id, a.hostname, a.agent_version, a.docker_version, a.registered_at, a.last_seen,
+			(SELECT COUNT(*) FROM agent_containers c WHERE c.agent_id = a.agent_id AND c.last_seen >= a.last_seen)
+		FROM agents a
+		ORDER BY a.hostname
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query agents: %v", err)
+	}
+	defer rows.Close()
+
+	agents := []AgentData{}
+	for rows.Next() {
+		var agent AgentData
+		if err := rows.Scan(&agent.AgentID, &agent.Hostname, &agent.AgentVersion, &agent.DockerVersion,
+			&agent.RegisteredAt, &agent.LastSeen, &agent.Containers); err != nil {
+			return nil, fmt.Errorf("failed to scan agent: %v", err)
+		}
+		agent.Reporting = time.Since(agent.LastSeen) <= darkAfter
+		agents = append(agents, agent)
+	}
+	return agents, rows.Err()
+}
+
+// ListContainers returns the containers seen in the latest report of each agent
+func (c *DatabaseClient) ListContainers() ([]ContainerData, error) {
+	rows, err := c.db.Query(`
+		SELECT c.agent_id, c.container_id, c.name, c.image, c.state, c.labels,
+			COALESCE(c.created_at, 'epoch'::timestamptz), c.first_seen, c.last_seen
+		FROM agent_containers c
+		JOIN agents a ON a.agent_id = c.agent_id
+		WHERE c.last_seen >= a.last_seen
+		ORDER BY c.agent_id, c.name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query containers: %v", err)
+	}
+	defer rows.Close()
+
+	containers := []ContainerData{}
+	for rows.Next() {
+		var container ContainerData
+		var labels []byte
+		if err := rows.Scan(&container.AgentID, &container.ContainerID, &container.Name, &container.Image,
+			&container.State, &labels, &container.CreatedAt, &container.FirstSeen, &container.LastSeen); err != nil {
+			return nil, fmt.Errorf("failed to scan container: %v", err)
+		}
+		if err := json.Unmarshal(labels, &container.Labels); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal container labels: %v", err)
+		}
+		containers = append(containers, container)
+	}
+	return containers, rows.Err()
+}
@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nox/noxflow/server-gRPC/pkg/alerting"
+)
+
+// SaveAlert inserts or updates an alert, identified by its fingerprint and start time
+func (c *DatabaseClient) SaveAlert(alert *alerting.Alert) error {
+	samples, err := json.Marshal(alert.Samples)
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert samples: %v", err)
+	}
+
+	err = c.db.QueryRow(`
+		INSERT INTO alerts (
+			fingerprint, rule, kind, severity, state,
+			container_id, container_name, image, value, summary, samples,
+			started_at, fired_at, resolved_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		ON CONFLICT (fingerprint, started_at) DO UPDATE SET
+			state = EXCLUDED.state,
+			value = EXCLUDED.value,
+			summary = EXCLUDED.summary,
+			samples = EXCLUDED.samples,
+			fired_at = COALESCE(EXCLUDED.fired_at, alerts.fired_at),
+			resolved_at = EXCLUDED.resolved_at
+		RETURNING id
+	`,
+		alert.Fingerprint, alert.Rule, alert.Kind, alert.Severity, alert.State,
+		alert.ContainerID, alert.ContainerName, alert.Image, alert.Value, alert.Summary, samples,
+		alert.StartedAt, nullTime(alert.FiredAt), nullTime(alert.ResolvedAt),
+	).Scan(&alert.ID)
+	if err != nil {
+		return fmt.Errorf("failed to save alert: %v", err)
+	}
+	return nil
+}
+
+// ListAlerts returns the most recent alerts, optionally only those in the given state
+func (c *DatabaseClient) ListAlerts(state string, limit int) ([]alerting.Alert, error) {
+	rows, err := c.db.Query(`
+		SELECT id, fingerprint, rule, kind, severity, state,
+			container_id, container_name, image, value, summary, samples,
+			started_at, fired_at, resolved_at
+		FROM alerts
+		WHERE $1 = '' OR state = $1
+		ORDER BY started_at DESC
+		LIMIT $2
+	`, state, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query alerts: %v", err)
+	}
+	return scanAlerts(rows)
+}
+
+// UnresolvedAlerts returns the alerts that were not resolved yet, oldest first, so the
+// alerting engine continues them after a restart
+func (c *DatabaseClient) UnresolvedAlerts() ([]alerting.Alert, error) {
+	rows, err := c.db.Query(`
+		SELECT id, fingerprint, rule, kind, severity, state,
+			container_id, container_name, image, value, summary, samples,
+			started_at, fired_at, resolved_at
+		FROM alerts
+		WHERE state <> 'resolved'
+		ORDER BY started_at
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query unresolved alerts: %v", err)
+	}
+	return scanAlerts(rows)
+}
+
+// scanAlerts reads the alerts selected by ListAlerts and UnresolvedAlerts, and closes the rows
+func scanAlerts(rows *sql.Rows) ([]alerting.Alert, error) {
+	defer rows.Close()
+
+	alerts := []alerting.Alert{}
+	for rows.Next() {
+		var alert alerting.Alert
+		var samples []byte
+		var firedAt, resolvedAt sql.NullTime
+		if err := rows.Scan(&alert.ID, &alert.Fingerprint, &alert.Rule, &alert.Kind, &alert.Severity, &alert.State,
+			&alert.ContainerID, &alert.ContainerName, &alert.Image, &alert.Value, &alert.Summary, &samples,
+			&alert.StartedAt, &firedAt, &resolvedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan alert: %v", err)
+		}
+		if err := json.Unmarshal(samples, &alert.Samples); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal alert samples: %v", err)
+		}
+		alert.FiredAt = firedAt.Time
+		alert.ResolvedAt = resolvedAt.Time
+		alerts = append(alerts, alert)
+	}
+	return alerts, rows.Err()
+}
+
+// nullTime stores zero times as NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
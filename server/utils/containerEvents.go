@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// EventData represents a container lifecycle event reported by an agent
+type EventData struct {
+	ID            int64             `json:"id"`
+	Timestamp     time.Time         `json:"timestamp"`
+	AgentID       string            `json:"agent_id"`
+	ContainerID   string            `json:"container_id"`
+	ContainerName string            `json:"container_name"`
+	Image         string            `json:"image"`
+	Action        string            `json:"action"`
+	ExitCode      *int32            `json:"exit_code,omitempty"`
+	HealthStatus  string            `json:"health_status,omitempty"`
+	Attributes    map[string]string `json:"attributes"`
+}
+
+// EventFilter selects the events returned by ListEvents, zero values match everything
+type EventFilter struct {
+	// Container matches either the container ID or the container name
+	Container string
+	Since     time.Time
+	Until     time.Time
+	Limit     int
+}
+
+// SaveEvent stores a container event. Events are rare compared to logs so they are
+// written directly instead of being batched.
+func (c *DatabaseClient) SaveEvent(event *EventData) error {
+	attributes, err := json.Marshal(event.Attributes)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event attributes: %v", err)
+	}
+
+	var healthStatus sql.NullString
+	if event.HealthStatus != "" {
+		healthStatus = sql.NullString{String: event.HealthStatus, Valid: true}
+	}
+
+	err = c.db.QueryRow(`
+		INSERT INTO container_events (
+			timestamp, agent_id, container_id, container_name, image,
+			action, exit_code, health_status, attributes
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id
+	`,
+		event.Timestamp, event.AgentID, event.ContainerID, event.ContainerName, event.Image,
+		event.Action, event.ExitCode, healthStatus, attributes,
+	).Scan(&event.ID)
+	if err != nil {
+		return fmt.Errorf("failed to save container event: %v", err)
+	}
+	return nil
+}
+
+// ListEvents returns the most recent container events matching the filter
+func (c *DatabaseClient) ListEvents(filter EventFilter) ([]EventData, error) {
+	rows, err := c.db.Query(`
+		SELECT id, timestamp, agent_id, container_id, container_name, image,
+			action, exit_code, health_status, attributes
+		FROM container_events
+		WHERE ($1 = '' OR container_id = $1 OR container_name = $1 OR container_name = '/' || $1)
+			AND ($2::timestamptz IS NULL OR timestamp >= $2)
+			AND ($3::timestamptz IS NULL OR timestamp < $3)
+		ORDER BY timestamp DESC
+		LIMIT $4
+	`, filter.Container, nullTime(filter.Since), nullTime(filter.Until), filter.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query container events: %v", err)
+	}
+	defer rows.Close()
+
+	events := []EventData{}
+	for rows.Next() {
+		var event EventData
+		var exitCode sql.NullInt32
+		var healthStatus sql.NullString
+		var attributes []byte
+		if err := rows.Scan(&event.ID, &event.Timestamp, &event.AgentID, &event.ContainerID, &event.ContainerName, &event.Image,
+			&event.Action, &exitCode, &healthStatus, &attributes); err != nil {
+			return nil, fmt.Errorf("failed to scan container event: %v", err)
+		}
+		if exitCode.Valid {
+			event.ExitCode = &exitCode.Int32
+		}
+		event.HealthStatus = healthStatus.String
+		if err := json.Unmarshal(attributes, &event.Attributes); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal event attributes: %v", err)
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
@@ -5,20 +5,28 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"log/slog"
+	"math"
+	"sync"
 	"time"
 
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
 	_ "github.com/lib/pq"
+	migrations "github.com/nox/noxflow/server-gRPC/db"
+	"github.com/nox/noxflow/server-gRPC/pkg/metrics"
 )
 
 type DatabaseClient struct {
-	db          *sql.DB
-	logBatch    []*LogData
-	usageBatch  []*UsageData
-	batchSize   int
-	batchTicker *time.Ticker
+	db           *sql.DB
+	mu           sync.Mutex
+	flushMu      sync.Mutex
+	logBatch     []*LogData
+	usageBatch   []*UsageData
+	batchSize    int
+	maxQueueSize int
+	batchTicker  *time.Ticker
 }
 
 type LogData struct {
@@ -28,12 +36,44 @@ type LogData struct {
 }
 
 type UsageData struct {
-	Timestamp     time.Time
-	ContainerID   string
-	CPUPercent    float64
-	MemoryPercent float64
+	Timestamp            time.Time
+	ContainerID          string
+	CPUPercent           float64
+	CPUUsage             uint64
+	SystemCPUUsage       uint64
+	MemoryUsage          uint64
+	MemoryLimit          uint64
+	MemoryPercent        float64
+	MemoryCache          uint64
+	Networks             []NetworkUsageData
+	BlkioReadBytes       uint64
+	BlkioWriteBytes      uint64
+	BlkioReadOps         uint64
+	BlkioWriteOps        uint64
+	PidsCurrent          uint64
+	PidsLimit            uint64
+	CPUThrottlingPeriods uint64
+	CPUThrottledPeriods  uint64
+	CPUThrottledTime     uint64
+	OOMKills             uint64
+}
+
+type NetworkUsageData struct {
+	Interface string
+	RxBytes   uint64
+	RxPackets uint64
+	RxErrors  uint64
+	RxDropped uint64
+	TxBytes   uint64
+	TxPackets uint64
+	TxErrors  uint64
+	TxDropped uint64
 }
 
+// maxQueuedBatches is how many batches are kept in memory while the database is failing,
+// older rows are dropped beyond that
+const maxQueuedBatches = 10
+
 // NewDatabaseClient creates a new database client with batch processing
 
 func NewDatabaseClient(connStr string, batchSize int, flushInterval time.Duration) (*DatabaseClient, error) {
@@ -74,17 +114,18 @@ func NewDatabaseClient(connStr string, batchSize int, flushInterval time.Duratio
 	dbInstance.SetMaxIdleConns(5)
 	dbInstance.SetConnMaxLifetime(5 * time.Minute)
 
-	// // Run migrations
-	// if err := runMigrations(dbInstance); err != nil {
-	// 	return nil, fmt.Errorf("failed to run migrations: %v", err)
-	// }
+	// Run migrations
+	if err := runMigrations(dbInstance); err != nil {
+		return nil, fmt.Errorf("failed to run migrations: %v", err)
+	}
 
 	client := &DatabaseClient{
-		db:          dbInstance,
-		batchSize:   batchSize,
-		logBatch:    make([]*LogData, 0, batchSize),
-		usageBatch:  make([]*UsageData, 0, batchSize),
-		batchTicker: time.NewTicker(flushInterval),
+		db:           dbInstance,
+		batchSize:    batchSize,
+		maxQueueSize: batchSize * maxQueuedBatches,
+		logBatch:     make([]*LogData, 0, batchSize),
+		usageBatch:   make([]*UsageData, 0, batchSize),
+		batchTicker:  time.NewTicker(flushInterval),
 	}
 
 	go client.periodicFlush()
@@ -92,37 +133,22 @@ func NewDatabaseClient(connStr string, batchSize int, flushInterval time.Duratio
 	return client, nil
 }
 
+// runMigrations brings the schema up to date with the migrations embedded in the binary.
+// A migration that fails leaves the schema dirty, it has to be fixed and its version
+// forced with the migrate CLI before the server starts again.
 func runMigrations(db *sql.DB) error {
-	// First, ensure the schema_migrations table exists
-	_, err := db.Exec(`
-        CREATE TABLE IF NOT EXISTS schema_migrations (
-            version bigint NOT NULL,
-            dirty boolean NOT NULL,
-            CONSTRAINT schema_migrations_pkey PRIMARY KEY (version)
-        );
-    `)
-	if err != nil {
-		return fmt.Errorf("failed to create schema_migrations table: %v", err)
-	}
-
 	driver, err := postgres.WithInstance(db, &postgres.Config{})
 	if err != nil {
 		return fmt.Errorf("failed to create migration driver: %v", err)
 	}
 
-	migrationsPath := "file://db/migrations"
-	m, err := migrate.NewWithDatabaseInstance(
-		migrationsPath,
-		"postgres",
-		driver,
-	)
+	source, err := iofs.New(migrations.Migrations, "migrations")
 	if err != nil {
-		return fmt.Errorf("failed to create migrate instance: %v", err)
+		return fmt.Errorf("failed to read migrations: %v", err)
 	}
-
-	// Force the version to 0 if there's an error
-	if err := m.Force(0); err != nil {
-		return fmt.Errorf("failed to force migration version: %v", err)
+	m, err := migrate.NewWithInstance("iofs", source, "postgres", driver)
+	if err != nil {
+		return fmt.Errorf("failed to create migrate instance: %v", err)
 	}
 
 	// Run migrations
@@ -130,14 +156,21 @@ func runMigrations(db *sql.DB) error {
 		return fmt.Errorf("failed to run migrations: %v", err)
 	}
 
+	version, _, _ := m.Version()
+	slog.Info("Database schema up to date", "version", version)
 	return nil
 }
 
 // AddLog adds a log entry to the batch
 func (c *DatabaseClient) AddLog(log *LogData) error {
+	c.mu.Lock()
 	c.logBatch = append(c.logBatch, log)
+	c.logBatch = trimQueue(c.logBatch, c.maxQueueSize, "container_logs")
+	size := len(c.logBatch)
+	c.mu.Unlock()
 
-	if len(c.logBatch) >= c.batchSize {
+	metrics.QueueDepth.WithLabelValues("container_logs").Set(float64(size))
+	if size >= c.batchSize {
 		return c.FlushLogs()
 	}
 	return nil
@@ -145,20 +178,131 @@ func (c *DatabaseClient) AddLog(log *LogData) error {
 
 // AddUsage adds a usage statistics entry to the batch
 func (c *DatabaseClient) AddUsage(usage *UsageData) error {
+	c.mu.Lock()
 	c.usageBatch = append(c.usageBatch, usage)
+	c.usageBatch = trimQueue(c.usageBatch, c.maxQueueSize, "container_usage")
+	size := len(c.usageBatch)
+	c.mu.Unlock()
 
-	if len(c.usageBatch) >= c.batchSize {
+	metrics.QueueDepth.WithLabelValues("container_usage").Set(float64(size))
+	if size >= c.batchSize {
 		return c.FlushUsage()
 	}
 	return nil
 }
 
-// FlushLogs writes all batched logs to the database
+// trimQueue drops the oldest rows once the queue grows past its maximum size
+func trimQueue[T any](queue []T, maxSize int, table string) []T {
+	if len(queue) <= maxSize {
+		return queue
+	}
+	dropped := len(queue) - maxSize
+	metrics.DroppedRows.WithLabelValues(table).Add(float64(dropped))
+	slog.Warn("Database queue full, dropping rows", "table", table, "dropped", dropped)
+	return append(queue[:0:0], queue[dropped:]...)
+}
+
+// FlushLogs writes all batched logs to the database. When the batch fails the logs are
+// written one by one, see writeRowByRow, and the ones left when the database stops
+// answering are put back in the queue to be retried on the next flush.
 func (c *DatabaseClient) FlushLogs() error {
-	if len(c.logBatch) == 0 {
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+
+	c.mu.Lock()
+	batch := c.logBatch
+	c.logBatch = make([]*LogData, 0, c.batchSize)
+	c.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	size := len(batch)
+	start := time.Now()
+	err := c.writeLogs(batch)
+	if err != nil {
+		metrics.DatabaseErrors.WithLabelValues("flush_logs").Inc()
+		batch, err = writeRowByRow(c, "container_logs", batch, c.writeLogs)
+	}
+	metrics.FlushDuration.WithLabelValues("container_logs").Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		c.mu.Lock()
+		c.logBatch = trimQueue(append(batch, c.logBatch...), c.maxQueueSize, "container_logs")
+		metrics.QueueDepth.WithLabelValues("container_logs").Set(float64(len(c.logBatch)))
+		c.mu.Unlock()
+		return err
+	}
+
+	metrics.FlushSize.WithLabelValues("container_logs").Observe(float64(size))
+	c.mu.Lock()
+	metrics.QueueDepth.WithLabelValues("container_logs").Set(float64(len(c.logBatch)))
+	c.mu.Unlock()
+	return nil
+}
+
+// FlushUsage writes all batched usage statistics to the database. When the batch fails
+// the stats are written one by one, see writeRowByRow, and the ones left when the
+// database stops answering are put back in the queue to be retried on the next flush.
+func (c *DatabaseClient) FlushUsage() error {
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+
+	c.mu.Lock()
+	batch := c.usageBatch
+	c.usageBatch = make([]*UsageData, 0, c.batchSize)
+	c.mu.Unlock()
+
+	if len(batch) == 0 {
 		return nil
 	}
 
+	size := len(batch)
+	start := time.Now()
+	err := c.writeUsage(batch)
+	if err != nil {
+		metrics.DatabaseErrors.WithLabelValues("flush_usage").Inc()
+		batch, err = writeRowByRow(c, "container_usage", batch, c.writeUsage)
+	}
+	metrics.FlushDuration.WithLabelValues("container_usage").Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		c.mu.Lock()
+		c.usageBatch = trimQueue(append(batch, c.usageBatch...), c.maxQueueSize, "container_usage")
+		metrics.QueueDepth.WithLabelValues("container_usage").Set(float64(len(c.usageBatch)))
+		c.mu.Unlock()
+		return err
+	}
+
+	metrics.FlushSize.WithLabelValues("container_usage").Observe(float64(size))
+	c.mu.Lock()
+	metrics.QueueDepth.WithLabelValues("container_usage").Set(float64(len(c.usageBatch)))
+	c.mu.Unlock()
+	return nil
+}
+
+// writeRowByRow writes the rows of a failed batch one at a time, so a row the database
+// can never store doesn't keep the whole queue from being written. A row failing while
+// the database answers a ping is rejected and dropped. The rows left when the database
+// stops answering are returned with the error, to be queued again.
+func writeRowByRow[T any](c *DatabaseClient, table string, batch []T, write func([]T) error) ([]T, error) {
+	for i, row := range batch {
+		err := write([]T{row})
+		if err == nil {
+			continue
+		}
+		if pingErr := c.db.Ping(); pingErr != nil {
+			return batch[i:], err
+		}
+		metrics.RejectedRows.WithLabelValues(table).Inc()
+		slog.Warn("Database rejected a row, dropping it", "table", table, "error", err)
+	}
+	return nil, nil
+}
+
+// writeLogs inserts the logs in a single transaction
+func (c *DatabaseClient) writeLogs(batch []*LogData) error {
 	tx, err := c.db.BeginTx(context.Background(), nil)
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %v", err)
@@ -173,7 +317,7 @@ func (c *DatabaseClient) FlushLogs() error {
 		return fmt.Errorf("failed to prepare statement: %v", err)
 	}
 
-	for _, log := range c.logBatch {
+	for _, log := range batch {
 		_, err := stmt.Exec(log.Timestamp, log.ContainerName, log.LogMessage)
 		if err != nil {
 			tx.Rollback()
@@ -185,68 +329,127 @@ func (c *DatabaseClient) FlushLogs() error {
 		return fmt.Errorf("failed to commit transaction: %v", err)
 	}
 
-	c.logBatch = c.logBatch[:0]
 	return nil
 }
 
-// FlushUsage writes all batched usage statistics to the database
-func (c *DatabaseClient) FlushUsage() error {
-	if len(c.usageBatch) == 0 {
-		return nil
-	}
-
+// writeUsage inserts the usage statistics in a single transaction
+func (c *DatabaseClient) writeUsage(batch []*UsageData) error {
 	tx, err := c.db.BeginTx(context.Background(), nil)
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %v", err)
 	}
 
 	stmt, err := tx.Prepare(`
-		INSERT INTO container_usage (timestamp, container_id, cpu_percent, memory_percent)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO container_usage (
+			timestamp, container_id, cpu_percent, memory_percent,
+			cpu_usage, system_cpu_usage, memory_usage, memory_limit, memory_cache,
+			blkio_read_bytes, blkio_write_bytes, blkio_read_ops, blkio_write_ops,
+			pids_current, pids_limit,
+			cpu_throttling_periods, cpu_throttled_periods, cpu_throttled_time,
+			oom_kills
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)
 	`)
 	if err != nil {
 		tx.Rollback()
 		return fmt.Errorf("failed to prepare statement: %v", err)
 	}
 
-	for _, usage := range c.usageBatch {
-		_, err := stmt.Exec(usage.Timestamp, usage.ContainerID, usage.CPUPercent, usage.MemoryPercent)
+	networkStmt, err := tx.Prepare(`
+		INSERT INTO container_network_usage (
+			timestamp, container_id, interface,
+			rx_bytes, rx_packets, rx_errors, rx_dropped,
+			tx_bytes, tx_packets, tx_errors, tx_dropped
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to prepare network statement: %v", err)
+	}
+
+	for _, usage := range batch {
+		_, err := stmt.Exec(usageArgs(usage)...)
 		if err != nil {
 			tx.Rollback()
 			return fmt.Errorf("failed to insert usage stats: %v", err)
 		}
+
+		for _, network := range usage.Networks {
+			_, err := networkStmt.Exec(
+				usage.Timestamp, usage.ContainerID, network.Interface,
+				network.RxBytes, network.RxPackets, network.RxErrors, network.RxDropped,
+				network.TxBytes, network.TxPackets, network.TxErrors, network.TxDropped,
+			)
+			if err != nil {
+				tx.Rollback()
+				return fmt.Errorf("failed to insert network usage stats: %v", err)
+			}
+		}
 	}
 
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("failed to commit transaction: %v", err)
 	}
 
-	c.usageBatch = c.usageBatch[:0]
 	return nil
 }
 
+// usageArgs returns the container_usage columns of a sample. Older agents report the pids
+// limit of an unlimited cgroup v2 container as the largest uint64, which database/sql
+// rejects, so anything over a BIGINT is stored as 0, no limit.
+func usageArgs(usage *UsageData) []any {
+	pidsLimit := usage.PidsLimit
+	if pidsLimit > math.MaxInt64 {
+		pidsLimit = 0
+	}
+	return []any{
+		usage.Timestamp, usage.ContainerID, usage.CPUPercent, usage.MemoryPercent,
+		usage.CPUUsage, usage.SystemCPUUsage, usage.MemoryUsage, usage.MemoryLimit, usage.MemoryCache,
+		usage.BlkioReadBytes, usage.BlkioWriteBytes, usage.BlkioReadOps, usage.BlkioWriteOps,
+		usage.PidsCurrent, pidsLimit,
+		usage.CPUThrottlingPeriods, usage.CPUThrottledPeriods, usage.CPUThrottledTime,
+		usage.OOMKills,
+	}
+}
+
 // periodicFlush runs in the background and flushes batches periodically
 func (c *DatabaseClient) periodicFlush() {
 	for range c.batchTicker.C {
 		if err := c.FlushLogs(); err != nil {
-			fmt.Printf("Error flushing logs: %v\n", err)
+			slog.Error("Error flushing logs", "error", err)
 		}
 		if err := c.FlushUsage(); err != nil {
-			fmt.Printf("Error flushing usage stats: %v\n", err)
+			slog.Error("Error flushing usage stats", "error", err)
 		}
 	}
 }
 
-// Close closes the database connection and flushes any remaining batches
-func (c *DatabaseClient) Close() error {
-	c.batchTicker.Stop()
-
-	if err := c.FlushLogs(); err != nil {
-		return err
+// Ping checks the database connection
+func (c *DatabaseClient) Ping(ctx context.Context) error {
+	if err := c.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to ping database: %v", err)
 	}
-	if err := c.FlushUsage(); err != nil {
-		return err
+	return nil
+}
+
+// CheckQueues fails when a batch queue is nearly full, the database isn't keeping up and
+// the oldest rows are about to be dropped
+func (c *DatabaseClient) CheckQueues(ctx context.Context) error {
+	c.mu.Lock()
+	logs, usage := len(c.logBatch), len(c.usageBatch)
+	c.mu.Unlock()
+
+	if saturated := c.maxQueueSize * 9 / 10; logs >= saturated || usage >= saturated {
+		return fmt.Errorf("database queue saturated: %d logs and %d usage samples queued, %d max", logs, usage, c.maxQueueSize)
 	}
+	return nil
+}
+
+// Close flushes the remaining batches and closes the database connection, a failed log
+// flush doesn't keep the usage batch from being flushed
+func (c *DatabaseClient) Close() error {
+	c.batchTicker.Stop()
 
-	return c.db.Close()
+	return errors.Join(c.FlushLogs(), c.FlushUsage(), c.db.Close())
 }
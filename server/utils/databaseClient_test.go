@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"database/sql/driver"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestUsageArgsFitTheDatabase(t *testing.T) {
+	tests := []struct {
+		name      string
+		pidsLimit uint64
+		want      uint64
+	}{
+		{name: "limit", pidsLimit: 512, want: 512},
+		{name: "no limit on cgroup v1", pidsLimit: 0, want: 0},
+		{name: "no limit on cgroup v2", pidsLimit: math.MaxUint64, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usage := &UsageData{
+				Timestamp:   time.Now(),
+				ContainerID: "web",
+				PidsCurrent: 8,
+				PidsLimit:   tt.pidsLimit,
+				MemoryLimit: 2147483648,
+			}
+			args := usageArgs(usage)
+			// database/sql converts every argument before it reaches the driver
+			for i, arg := range args {
+				if _, err := driver.DefaultParameterConverter.ConvertValue(arg); err != nil {
+					t.Errorf("argument $%d: %v", i+1, err)
+				}
+			}
+			if got := args[14]; got != tt.want {
+				t.Errorf("pids_limit = %v, want %d", got, tt.want)
+			}
+		})
+	}
+}
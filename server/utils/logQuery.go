@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// LogEntry is a stored log line
+type LogEntry struct {
+	ID            int64     `json:"id"`
+	Timestamp     time.Time `json:"timestamp"`
+	ContainerName string    `json:"container_name"`
+	Message       string    `json:"message"`
+}
+
+// LogQuery selects the logs returned by SearchLogs, zero values match everything
+type LogQuery struct {
+	// Container matches the container name, with or without the leading slash
+	Container string
+	// Search is a case insensitive substring of the log message
+	Search string
+	Since  time.Time
+	Until  time.Time
+	// AfterID only returns the logs stored after this one, to follow new logs
+	AfterID int64
+	Limit   int
+}
+
+// SearchLogs returns the logs matching the query, oldest first. Without AfterID the most
+// recent logs are returned, with it the logs following AfterID.
+func (c *DatabaseClient) SearchLogs(query LogQuery) ([]LogEntry, error) {
+	order := "DESC"
+	if query.AfterID > 0 {
+		order = "ASC"
+	}
+
+	rows, err := c.db.Query(`
+		SELECT id, timestamp, container_name, log_message
+		FROM container_logs
+		WHERE ($1 = '' OR container_name = $1 OR container_name = '/' || $1)
+			AND ($2 = '' OR log_message ILIKE '%' || $2 || '%')
+			AND ($3::timestamptz IS NULL OR timestamp >= $3)
+			AND ($4::timestamptz IS NULL OR timestamp < $4)
+			AND id > $5
+		ORDER BY id `+order+`
+		LIMIT $6
+	`, query.Container, escapeLike(query.Search), nullTime(query.Since), nullTime(query.Until), query.AfterID, query.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query logs: %v", err)
+	}
+	defer rows.Close()
+
+	logs := []LogEntry{}
+	for rows.Next() {
+		var entry LogEntry
+		if err := rows.Scan(&entry.ID, &entry.Timestamp, &entry.ContainerName, &entry.Message); err != nil {
+			return nil, fmt.Errorf("failed to scan log: %v", err)
+		}
+		logs = append(logs, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read logs: %v", err)
+	}
+
+	if order == "DESC" {
+		slices.Reverse(logs)
+	}
+	return logs, nil
+}
+
+// escapeLike escapes the LIKE wildcards so the search matches them literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// UsagePoint is a stored usage sample of a container
+type UsagePoint struct {
+	Timestamp     time.Time `json:"timestamp"`
+	CPUPercent    float64   `json:"cpu_percent"`
+	MemoryPercent float64   `json:"memory_percent"`
+	MemoryUsage   uint64    `json:"memory_usage"`
+	MemoryLimit   uint64    `json:"memory_limit"`
+	PidsCurrent   uint64    `json:"pids_current"`
+}
+
+// ContainerUsage is the latest usage sample of a container
+type ContainerUsage struct {
+	AgentID     string `json:"agent_id"`
+	ContainerID string `json:"container_id"`
+	Name        string `json:"name"`
+	Image       string `json:"image"`
+	State       string `json:"state"`
+	UsagePoint
+}
+
+// UsageFilter selects the samples returned by ListUsage, zero times match everything
+type UsageFilter struct {
+	ContainerID string
+	Since       time.Time
+	Until       time.Time
+	Limit       int
+}
+
+// ListUsage returns the most recent usage samples of a container, oldest first
+func (c *DatabaseClient) ListUsage(filter UsageFilter) ([]UsagePoint, error) {
+	rows, err := c.db.Query(`
+		SELECT timestamp, cpu_percent, memory_percent, memory_usage, memory_limit, pids_current
+		FROM container_usage
+		WHERE container_id = $1
+			AND ($2::timestamptz IS NULL OR timestamp >= $2)
+			AND ($3::timestamptz IS NULL OR timestamp < $3)
+		ORDER BY timestamp DESC
+		LIMIT $4
+	`, filter.ContainerID, nullTime(filter.Since), nullTime(filter.Until), filter.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query usage: %v", err)
+	}
+	defer rows.Close()
+
+	points := []UsagePoint{}
+	for rows.Next() {
+		var point UsagePoint
+		if err := rows.Scan(&point.Timestamp, &point.CPUPercent, &point.MemoryPercent, &point.MemoryUsage, &point.MemoryLimit, &point.PidsCurrent); err != nil {
+			return nil, fmt.Errorf("failed to scan usage: %v", err)
+		}
+		points = append(points, point)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read usage: %v", err)
+	}
+
+	slices.Reverse(points)
+	return points, nil
+}
+
+// LatestUsage returns the latest usage sample of every container in the current inventory
+// of the agents, containers without samples are left out
+func (c *DatabaseClient) LatestUsage() ([]ContainerUsage, error) {
+	rows, err := c.db.Query(`
+		SELECT c.agent_id, c.container_id, c.name, c.image, c.state,
+			u.timestamp, u.cpu_percent, u.memory_percent, u.memory_usage, u.memory_limit, u.pids_current
+		FROM agent_containers c
+		JOIN agents a ON a.agent_id = c.agent_id
+		CROSS JOIN LATERAL (
+			SELECT timestamp, cpu_percent, memory_percent, memory_usage, memory_limit, pids_current
+			FROM container_usage
+			WHERE container_id = c.container_id
+			ORDER BY timestamp DESC
+			LIMIT 1
+		) u
+		WHERE c.last_seen >= a.last_seen
+		ORDER BY c.agent_id, c.name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query latest usage: %v", err)
+	}
+	defer rows.Close()
+
+	usage := []ContainerUsage{}
+	for rows.Next() {
+		var u ContainerUsage
+		if err := rows.Scan(&u.AgentID, &u.ContainerID, &u.Name, &u.Image, &u.State,
+			&u.Timestamp, &u.CPUPercent, &u.MemoryPercent, &u.MemoryUsage, &u.MemoryLimit, &u.PidsCurrent); err != nil {
+			return nil, fmt.Errorf("failed to scan latest usage: %v", err)
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
@@ -0,0 +1,318 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+// UsageRetention is how long the usage is kept at each resolution, zero keeps it forever
+type UsageRetention struct {
+	Raw    time.Duration
+	Minute time.Duration
+	Hour   time.Duration
+}
+
+// Usage resolutions, from the finest to the coarsest
+const (
+	ResolutionRaw    = "raw"
+	ResolutionMinute = "1m"
+	ResolutionHour   = "1h"
+)
+
+// rollupLevel is a resolution the usage is rolled up to
+type rollupLevel struct {
+	name       string
+	table      string
+	resolution time.Duration
+	// recompute is how far before the watermark the buckets are rolled up again, so the
+	// samples stored late are included
+	recompute time.Duration
+	// chunk bounds the time range rolled up in one statement
+	chunk time.Duration
+}
+
+// rollupLevels are rolled up in order, each level from the previous one
+var rollupLevels = []rollupLevel{
+	{name: ResolutionMinute, table: "container_usage_1m", resolution: time.Minute, recompute: 5 * time.Minute, chunk: time.Hour},
+	{name: ResolutionHour, table: "container_usage_1h", resolution: time.Hour, recompute: time.Hour, chunk: 24 * time.Hour},
+}
+
+// rollupDelay is how long after a minute ends it is rolled up, the samples are written
+// to the database in batches
+const rollupDelay = time.Minute
+
+// usageMetricNames returns the usage metrics in a stable order
+func usageMetricNames() []string {
+	names := make([]string, 0, len(UsageMetrics))
+	for name := range UsageMetrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RunUsageRollups rolls the usage up into the 1m and 1h tables and deletes the usage
+// past its retention every interval, until ctx is done
+func (c *DatabaseClient) RunUsageRollups(ctx context.Context, interval time.Duration, retention UsageRetention) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.RollupUsage(time.Now()); err != nil {
+			slog.Error("Error rolling up usage", "error", err)
+		} else if err := c.DeleteExpiredUsage(time.Now(), retention); err != nil {
+			slog.Error("Error deleting expired usage", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RollupUsage rolls up the complete buckets of every resolution, starting where the
+// previous run stopped
+func (c *DatabaseClient) RollupUsage(now time.Time) error {
+	watermarks, err := c.usageWatermarks()
+	if err != nil {
+		return err
+	}
+
+	for i, level := range rollupLevels {
+		upTo := rollupUpTo(i, now, watermarks)
+
+		from, ok := watermarks[level.name]
+		if !ok {
+			from, err = c.earliestUsage(i)
+			if err != nil {
+				return err
+			}
+			if from.IsZero() {
+				from = upTo
+			}
+			from = from.Truncate(level.resolution)
+		} else {
+			from = from.Add(-level.recompute)
+		}
+
+		for start := from; start.Before(upTo); {
+			end := earliest(start.Add(level.chunk), upTo)
+			if err := c.rollupChunk(i, start, end); err != nil {
+				return err
+			}
+			// Record the progress of every chunk, a long first rollup resumes where it stopped
+			if err := c.setUsageWatermark(level.name, end); err != nil {
+				return err
+			}
+			start = end
+		}
+		if err := c.setUsageWatermark(level.name, upTo); err != nil {
+			return err
+		}
+		if upTo.After(watermarks[level.name]) {
+			watermarks[level.name] = upTo
+		}
+	}
+	return nil
+}
+
+// rollupUpTo returns the end of the complete buckets of a level. The raw samples are
+// rolled up once the minute is over, the coarser levels up to where the finer level is
+// rolled up.
+func rollupUpTo(levelIndex int, now time.Time, watermarks map[string]time.Time) time.Time {
+	upTo := now.Add(-rollupDelay)
+	if levelIndex > 0 {
+		upTo = watermarks[rollupLevels[levelIndex-1].name]
+	}
+	return upTo.Truncate(rollupLevels[levelIndex].resolution)
+}
+
+// rollupChunk rolls up [start, end) of a level from its source, replacing the buckets
+// already rolled up
+func (c *DatabaseClient) rollupChunk(levelIndex int, start, end time.Time) error {
+	level := rollupLevels[levelIndex]
+	metricNames := usageMetricNames()
+
+	var timeColumn, source, samples string
+	selects := make([]string, 0, len(metricNames)*4)
+	if levelIndex == 0 {
+		timeColumn, source, samples = "timestamp", "container_usage", "count(*)"
+		for _, name := range metricNames {
+			column := UsageMetrics[name]
+			selects = append(selects,
+				fmt.Sprintf("min(%s)::float8", column),
+				fmt.Sprintf("avg(%s)::float8", column),
+				fmt.Sprintf("max(%s)::float8", column),
+				fmt.Sprintf("((array_agg(%s ORDER BY timestamp DESC))[1])::float8", column))
+		}
+	} else {
+		timeColumn, source, samples = "bucket", rollupLevels[levelIndex-1].table, "sum(samples)"
+		for _, name := range metricNames {
+			selects = append(selects,
+				fmt.Sprintf("min(%s_min)", name),
+				fmt.Sprintf("sum(%s_avg * samples) / sum(samples)", name),
+				fmt.Sprintf("max(%s_max)", name),
+				fmt.Sprintf("(array_agg(%s_last ORDER BY bucket DESC))[1]", name))
+		}
+	}
+
+	columns := []string{"samples"}
+	for _, name := range metricNames {
+		columns = append(columns, name+"_min", name+"_avg", name+"_max", name+"_last")
+	}
+	updates := make([]string, len(columns))
+	for i, column := range columns {
+		updates[i] = fmt.Sprintf("%s = EXCLUDED.%s", column, column)
+	}
+
+	// The buckets are aligned on the unix epoch, like the buckets of the series queries
+	_, err := c.db.Exec(fmt.Sprintf(`
+		INSERT INTO %s (container_id, bucket, %s)
+		SELECT container_id,
+			to_timestamp(floor(extract(epoch FROM %s) / %d) * %d) AS rollup_bucket,
+			%s, %s
+		FROM %s
+		WHERE %s >= $1 AND %s < $2
+		GROUP BY container_id, rollup_bucket
+		ON CONFLICT (container_id, bucket) DO UPDATE SET %s
+	`,
+		level.table, strings.Join(columns, ", "),
+		timeColumn, int(level.resolution.Seconds()), int(level.resolution.Seconds()),
+		samples, strings.Join(selects, ", "),
+		source,
+		timeColumn, timeColumn,
+		strings.Join(updates, ", "),
+	), start, end)
+	if err != nil {
+		return fmt.Errorf("failed to roll up usage to %s: %v", level.name, err)
+	}
+	return nil
+}
+
+// earliestUsage returns the time of the oldest row of the source of a level, the zero
+// time when it is empty
+func (c *DatabaseClient) earliestUsage(levelIndex int) (time.Time, error) {
+	query := `SELECT min(timestamp) FROM container_usage`
+	if levelIndex > 0 {
+		query = fmt.Sprintf(`SELECT min(bucket) FROM %s`, rollupLevels[levelIndex-1].table)
+	}
+
+	var earliest sql.NullTime
+	if err := c.db.QueryRow(query).Scan(&earliest); err != nil {
+		return time.Time{}, fmt.Errorf("failed to query the oldest usage: %v", err)
+	}
+	return earliest.Time, nil
+}
+
+// usageWatermarks returns how far each resolution is rolled up, a resolution that was
+// never rolled up is missing
+func (c *DatabaseClient) usageWatermarks() (map[string]time.Time, error) {
+	rows, err := c.db.Query(`SELECT resolution, rolled_up_to FROM usage_rollup_state`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the usage rollup state: %v", err)
+	}
+	defer rows.Close()
+
+	watermarks := make(map[string]time.Time)
+	for rows.Next() {
+		var resolution string
+		var rolledUpTo time.Time
+		if err := rows.Scan(&resolution, &rolledUpTo); err != nil {
+			return nil, fmt.Errorf("failed to scan the usage rollup state: %v", err)
+		}
+		watermarks[resolution] = rolledUpTo
+	}
+	return watermarks, rows.Err()
+}
+
+// setUsageWatermark records how far a resolution is rolled up, it never moves back
+func (c *DatabaseClient) setUsageWatermark(resolution string, rolledUpTo time.Time) error {
+	_, err := c.db.Exec(`
+		INSERT INTO usage_rollup_state (resolution, rolled_up_to)
+		VALUES ($1, $2)
+		ON CONFLICT (resolution) DO UPDATE SET
+			rolled_up_to = GREATEST(usage_rollup_state.rolled_up_to, EXCLUDED.rolled_up_to)
+	`, resolution, rolledUpTo)
+	if err != nil {
+		return fmt.Errorf("failed to save the usage rollup state: %v", err)
+	}
+	return nil
+}
+
+// usageExpiration is the time before which the rows of a usage table are deleted
+type usageExpiration struct {
+	table      string
+	timeColumn string
+	cutoff     time.Time
+}
+
+// DeleteExpiredUsage deletes the usage past its retention
+func (c *DatabaseClient) DeleteExpiredUsage(now time.Time, retention UsageRetention) error {
+	watermarks, err := c.usageWatermarks()
+	if err != nil {
+		return err
+	}
+
+	for _, expiration := range usageExpirations(now, retention, watermarks) {
+		result, err := c.db.Exec(fmt.Sprintf(`DELETE FROM %s WHERE %s < $1`, expiration.table, expiration.timeColumn), expiration.cutoff)
+		if err != nil {
+			return fmt.Errorf("failed to delete expired usage from %s: %v", expiration.table, err)
+		}
+		if deleted, _ := result.RowsAffected(); deleted > 0 {
+			slog.Info("Deleted expired usage", "table", expiration.table, "rows", deleted, "before", expiration.cutoff)
+		}
+	}
+	return nil
+}
+
+// usageExpirations returns the cutoffs of the usage tables with a retention. Raw samples
+// and 1m buckets are only deleted once they are rolled up to the next resolution.
+func usageExpirations(now time.Time, retention UsageRetention, watermarks map[string]time.Time) []usageExpiration {
+	// Network usage isn't rolled up, it only follows the raw retention
+	tables := []struct {
+		table      string
+		timeColumn string
+		retention  time.Duration
+		// rolledUpTo is the watermark of the next resolution, nothing after it is deleted
+		rolledUpTo string
+		recompute  time.Duration
+	}{
+		{"container_usage", "timestamp", retention.Raw, ResolutionMinute, rollupLevels[0].recompute},
+		{"container_network_usage", "timestamp", retention.Raw, "", 0},
+		{"container_usage_1m", "bucket", retention.Minute, ResolutionHour, rollupLevels[1].recompute},
+		{"container_usage_1h", "bucket", retention.Hour, "", 0},
+	}
+
+	var expirations []usageExpiration
+	for _, table := range tables {
+		if table.retention <= 0 {
+			continue
+		}
+		cutoff := now.Add(-table.retention)
+		if table.rolledUpTo != "" {
+			watermark, ok := watermarks[table.rolledUpTo]
+			if !ok {
+				continue
+			}
+			// Keep what is still rolled up again for late samples
+			cutoff = earliest(cutoff, watermark.Add(-table.recompute))
+		}
+		expirations = append(expirations, usageExpiration{table: table.table, timeColumn: table.timeColumn, cutoff: cutoff})
+	}
+	return expirations
+}
+
+// earliest returns the earlier of two times
+func earliest(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRollupUpTo(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 34, 30, 0, time.UTC)
+	tests := []struct {
+		name       string
+		level      int
+		watermarks map[string]time.Time
+		want       time.Time
+	}{
+		{
+			name:  "minutes wait for the late samples",
+			level: 0,
+			want:  time.Date(2024, 5, 1, 12, 33, 0, 0, time.UTC),
+		},
+		{
+			name:       "hours follow the minutes",
+			level:      1,
+			watermarks: map[string]time.Time{ResolutionMinute: time.Date(2024, 5, 1, 12, 33, 0, 0, time.UTC)},
+			want:       time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name:       "hour complete",
+			level:      1,
+			watermarks: map[string]time.Time{ResolutionMinute: time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC)},
+			want:       time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "minutes never rolled up",
+			level: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rollupUpTo(tt.level, now, tt.watermarks); !got.Equal(tt.want) {
+				t.Errorf("rollupUpTo() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUsageExpirations(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	retention := UsageRetention{Raw: 24 * time.Hour, Minute: 7 * 24 * time.Hour, Hour: 90 * 24 * time.Hour}
+	upToDate := map[string]time.Time{
+		ResolutionMinute: now.Add(-2 * time.Minute),
+		ResolutionHour:   now.Add(-time.Hour),
+	}
+
+	tests := []struct {
+		name       string
+		retention  UsageRetention
+		watermarks map[string]time.Time
+		want       []usageExpiration
+	}{
+		{
+			name:       "kept forever",
+			watermarks: upToDate,
+		},
+		{
+			name:       "rolled up",
+			retention:  retention,
+			watermarks: upToDate,
+			want: []usageExpiration{
+				{table: "container_usage", timeColumn: "timestamp", cutoff: now.Add(-24 * time.Hour)},
+				{table: "container_network_usage", timeColumn: "timestamp", cutoff: now.Add(-24 * time.Hour)},
+				{table: "container_usage_1m", timeColumn: "bucket", cutoff: now.Add(-7 * 24 * time.Hour)},
+				{table: "container_usage_1h", timeColumn: "bucket", cutoff: now.Add(-90 * 24 * time.Hour)},
+			},
+		},
+		{
+			name:      "rollups behind",
+			retention: retention,
+			watermarks: map[string]time.Time{
+				ResolutionMinute: now.Add(-48 * time.Hour),
+				ResolutionHour:   now.Add(-10 * 24 * time.Hour),
+			},
+			want: []usageExpiration{
+				{table: "container_usage", timeColumn: "timestamp", cutoff: now.Add(-48*time.Hour - 5*time.Minute)},
+				{table: "container_network_usage", timeColumn: "timestamp", cutoff: now.Add(-24 * time.Hour)},
+				{table: "container_usage_1m", timeColumn: "bucket", cutoff: now.Add(-10*24*time.Hour - time.Hour)},
+				{table: "container_usage_1h", timeColumn: "bucket", cutoff: now.Add(-90 * 24 * time.Hour)},
+			},
+		},
+		{
+			name:      "never rolled up",
+			retention: retention,
+			want: []usageExpiration{
+				{table: "container_network_usage", timeColumn: "timestamp", cutoff: now.Add(-24 * time.Hour)},
+				{table: "container_usage_1h", timeColumn: "bucket", cutoff: now.Add(-90 * 24 * time.Hour)},
+			},
+		},
+		{
+			name:       "only raw retention",
+			retention:  UsageRetention{Raw: 24 * time.Hour},
+			watermarks: upToDate,
+			want: []usageExpiration{
+				{table: "container_usage", timeColumn: "timestamp", cutoff: now.Add(-24 * time.Hour)},
+				{table: "container_network_usage", timeColumn: "timestamp", cutoff: now.Add(-24 * time.Hour)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := usageExpirations(now, tt.retention, tt.watermarks); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("usageExpirations() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
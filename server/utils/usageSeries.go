@@ -0,0 +1,337 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/nox/noxflow/server-gRPC/pkg/alerting"
+)
+
+// UsageMetrics maps the metrics of the usage series API to their container_usage column
+var UsageMetrics = map[string]string{
+	"cpu_percent":       "cpu_percent",
+	"memory_percent":    "memory_percent",
+	"memory_usage":      "memory_usage",
+	"memory_limit":      "memory_limit",
+	"memory_cache":      "memory_cache",
+	"blkio_read_bytes":  "blkio_read_bytes",
+	"blkio_write_bytes": "blkio_write_bytes",
+	"pids_current":      "pids_current",
+	"cpu_throttled":     "cpu_throttled_periods",
+	"oom_kills":         "oom_kills",
+}
+
+// UsageAggregations maps the aggregations of the usage series API to their SQL, %[1]s is
+// replaced by the metric. They aggregate the min, avg, max and last columns of the rows
+// of the resolution a query reads, so the percentiles are computed from the averages of
+// the 1m and 1h buckets.
+var UsageAggregations = map[string]string{
+	"avg":  "sum(%[1]s_avg * u.samples) / sum(u.samples)",
+	"min":  "min(%[1]s_min)",
+	"max":  "max(%[1]s_max)",
+	"last": "(array_agg(%[1]s_last ORDER BY u.ts DESC))[1]",
+	"p50":  "percentile_cont(0.5) WITHIN GROUP (ORDER BY %[1]s_avg)",
+	"p95":  "percentile_cont(0.95) WITHIN GROUP (ORDER BY %[1]s_avg)",
+	"p99":  "percentile_cont(0.99) WITHIN GROUP (ORDER BY %[1]s_avg)",
+}
+
+// Groupings of the usage series, a label grouping is written label:<key>
+const (
+	GroupByContainer = "container"
+	GroupByID        = "id"
+	GroupByImage     = "image"
+	GroupByAll       = "all"
+	groupByLabel     = "label:"
+)
+
+// UsageSeriesQuery selects the containers and the metrics of a usage series query
+type UsageSeriesQuery struct {
+	// Selector picks the containers, its container name also matches container ID prefixes
+	Selector alerting.Selector
+	Metrics  []string
+	Since    time.Time
+	Until    time.Time
+	Step     time.Duration
+	// Aggregation combines the samples of a step, across every container of a group
+	Aggregation string
+	// GroupBy is container, id, image, all or label:<key>
+	GroupBy string
+}
+
+// UsageSeries is the series of a group of containers
+type UsageSeries struct {
+	Group      string        `json:"group"`
+	Containers []string      `json:"containers"`
+	Points     []SeriesPoint `json:"points"`
+}
+
+// SeriesPoint is the aggregated value of the metrics over a step, Timestamp is the start
+// of the step
+type SeriesPoint struct {
+	Timestamp time.Time          `json:"timestamp"`
+	Values    map[string]float64 `json:"values"`
+}
+
+// ValidateGroupBy checks a grouping of the usage series
+func ValidateGroupBy(groupBy string) error {
+	switch groupBy {
+	case GroupByContainer, GroupByID, GroupByImage, GroupByAll:
+		return nil
+	}
+	if key, ok := strings.CutPrefix(groupBy, groupByLabel); ok && key != "" {
+		return nil
+	}
+	return fmt.Errorf("invalid group_by %q: expected container, id, image, all or label:<key>", groupBy)
+}
+
+// seriesContainer is a container selected by a usage series query
+type seriesContainer struct {
+	id    string
+	group string
+}
+
+// QueryUsageSeries returns the usage series of the containers matching the query, one per
+// group, and the resolution it was read from. The samples are bucketed by step and
+// aggregated in the database, so the number of points only depends on the time range
+// and the step.
+func (c *DatabaseClient) QueryUsageSeries(query UsageSeriesQuery) ([]UsageSeries, string, error) {
+	aggregation, ok := UsageAggregations[query.Aggregation]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown aggregation %q", query.Aggregation)
+	}
+	aggregates := make([]string, len(query.Metrics))
+	for i, metric := range query.Metrics {
+		if _, ok := UsageMetrics[metric]; !ok {
+			return nil, "", fmt.Errorf("unknown metric %q", metric)
+		}
+		aggregates[i] = fmt.Sprintf(aggregation, "u."+metric)
+	}
+
+	containers, err := c.selectSeriesContainers(query)
+	if err != nil {
+		return nil, "", err
+	}
+	watermarks, err := c.usageWatermarks()
+	if err != nil {
+		return nil, "", err
+	}
+	resolution, segments := seriesSegments(query, watermarks)
+	if len(containers) == 0 {
+		return []UsageSeries{}, resolution, nil
+	}
+
+	ids := make([]string, len(containers))
+	groups := make([]string, len(containers))
+	for i, container := range containers {
+		ids[i] = container.id
+		groups[i] = container.group
+	}
+
+	// Every segment reads the rows of a resolution as units with the min, avg, max and
+	// last of each metric, a raw sample is a unit of its own
+	args := []any{pq.Array(ids), pq.Array(groups), query.Step.Seconds()}
+	units := make([]string, len(segments))
+	for i, segment := range segments {
+		args = append(args, segment.since, segment.until)
+		units[i] = segment.sql(query.Metrics, len(args)-1, len(args))
+	}
+
+	// Buckets are aligned on the unix epoch so consecutive queries share their buckets
+	rows, err := c.db.Query(`
+		WITH units AS (
+			`+strings.Join(units, " UNION ALL ")+`
+		)
+		SELECT g.grp,
+			to_timestamp(floor(extract(epoch FROM u.ts) / $3::float8) * $3::float8) AS bucket,
+			`+strings.Join(aggregates, ", ")+`
+		FROM units u
+		JOIN unnest($1::text[], $2::text[]) AS g (container_id, grp) ON g.container_id = u.container_id
+		GROUP BY g.grp, bucket
+		ORDER BY g.grp, bucket
+	`, args...)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to query usage series: %v", err)
+	}
+	defer rows.Close()
+
+	series := []UsageSeries{}
+	values := make([]float64, len(query.Metrics))
+	dest := make([]any, 0, len(values)+2)
+	for rows.Next() {
+		var group string
+		var point SeriesPoint
+		dest = append(dest[:0], &group, &point.Timestamp)
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, "", fmt.Errorf("failed to scan usage series: %v", err)
+		}
+
+		point.Values = make(map[string]float64, len(values))
+		for i, metric := range query.Metrics {
+			point.Values[metric] = values[i]
+		}
+		if len(series) == 0 || series[len(series)-1].Group != group {
+			series = append(series, UsageSeries{Group: group})
+		}
+		series[len(series)-1].Points = append(series[len(series)-1].Points, point)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", fmt.Errorf("failed to read usage series: %v", err)
+	}
+
+	// List the containers of every group, including those without samples in the range
+	members := make(map[string][]string)
+	for _, container := range containers {
+		members[container.group] = append(members[container.group], container.id)
+	}
+	for i := range series {
+		series[i].Containers = members[series[i].Group]
+	}
+	return series, resolution, nil
+}
+
+// seriesSegment is a part of the time range of a series query read from one resolution
+type seriesSegment struct {
+	// level is the index of the rollup level, -1 for the raw samples
+	level int
+	since time.Time
+	until time.Time
+}
+
+// sql returns the query of the units of the segment, since and until are the indexes of
+// the query parameters holding the segment bounds
+func (s *seriesSegment) sql(metrics []string, since, until int) string {
+	columns := make([]string, 0, len(metrics)*4)
+	if s.level < 0 {
+		for _, metric := range metrics {
+			column := UsageMetrics[metric] + "::float8"
+			columns = append(columns,
+				column+" AS "+metric+"_min", column+" AS "+metric+"_avg",
+				column+" AS "+metric+"_max", column+" AS "+metric+"_last")
+		}
+		return fmt.Sprintf(`SELECT container_id, timestamp AS ts, 1 AS samples, %s
+			FROM container_usage
+			WHERE container_id = ANY($1) AND timestamp >= $%d AND timestamp < $%d`,
+			strings.Join(columns, ", "), since, until)
+	}
+
+	for _, metric := range metrics {
+		columns = append(columns, metric+"_min", metric+"_avg", metric+"_max", metric+"_last")
+	}
+	return fmt.Sprintf(`SELECT container_id, bucket AS ts, samples, %s
+		FROM %s
+		WHERE container_id = ANY($1) AND bucket >= $%d AND bucket < $%d`,
+		strings.Join(columns, ", "), rollupLevels[s.level].table, since, until)
+}
+
+// seriesSegments routes a query to the coarsest resolution dividing its step. The part
+// of the time range that isn't rolled up yet is read from the finer resolutions.
+// The range starts at the beginning of the step holding since, the rows of a resolution
+// are only read up to its last bucket ending before until.
+func seriesSegments(query UsageSeriesQuery, watermarks map[string]time.Time) (string, []seriesSegment) {
+	level := -1
+	for i, rollup := range rollupLevels {
+		if query.Step >= rollup.resolution && query.Step%rollup.resolution == 0 {
+			level = i
+		}
+	}
+
+	resolution := ResolutionRaw
+	if level >= 0 {
+		resolution = rollupLevels[level].name
+	}
+
+	var segments []seriesSegment
+	since := alignTime(query.Since, query.Step)
+	for ; level >= 0; level-- {
+		until := earliest(alignTime(query.Until, rollupLevels[level].resolution), watermarks[rollupLevels[level].name])
+		if since.Before(until) {
+			segments = append(segments, seriesSegment{level: level, since: since, until: until})
+			since = until
+		}
+	}
+	// The raw samples always cover the rest, there is at least one segment
+	segments = append(segments, seriesSegment{level: -1, since: since, until: query.Until})
+	return resolution, segments
+}
+
+// alignTime returns the start of the bucket holding t, buckets are aligned on the unix
+// epoch like in the queries
+func alignTime(t time.Time, step time.Duration) time.Time {
+	if step <= 0 {
+		return t
+	}
+	offset := t.UnixNano() % int64(step)
+	if offset < 0 {
+		offset += int64(step)
+	}
+	return t.Add(-time.Duration(offset))
+}
+
+// selectSeriesContainers returns the containers matching the selector that were known
+// during the time range, with the group they belong to
+func (c *DatabaseClient) selectSeriesContainers(query UsageSeriesQuery) ([]seriesContainer, error) {
+	rows, err := c.db.Query(`
+		SELECT DISTINCT ON (container_id) container_id, name, image, labels
+		FROM agent_containers
+		WHERE last_seen >= $1 AND first_seen < $2
+		ORDER BY container_id, last_seen DESC
+	`, query.Since, query.Until)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query containers: %v", err)
+	}
+	defer rows.Close()
+
+	var containers []seriesContainer
+	for rows.Next() {
+		var info alerting.ContainerInfo
+		var labels []byte
+		if err := rows.Scan(&info.ID, &info.Name, &info.Image, &labels); err != nil {
+			return nil, fmt.Errorf("failed to scan container: %v", err)
+		}
+		if err := json.Unmarshal(labels, &info.Labels); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal container labels: %v", err)
+		}
+
+		selector := query.Selector
+		if selector.ContainerName != "" && strings.HasPrefix(info.ID, selector.ContainerName) {
+			selector.ContainerName = ""
+		}
+		if !selector.Matches(&info) {
+			continue
+		}
+
+		group, ok := seriesGroup(query.GroupBy, &info)
+		if !ok {
+			continue
+		}
+		containers = append(containers, seriesContainer{id: info.ID, group: group})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read containers: %v", err)
+	}
+	return containers, nil
+}
+
+// seriesGroup returns the group of a container, containers without the label of a label
+// grouping are left out
+func seriesGroup(groupBy string, info *alerting.ContainerInfo) (string, bool) {
+	switch groupBy {
+	case GroupByContainer:
+		return info.Name, true
+	case GroupByID:
+		return info.ID, true
+	case GroupByImage:
+		return info.Image, true
+	case GroupByAll:
+		return GroupByAll, true
+	}
+	key := strings.TrimPrefix(groupBy, groupByLabel)
+	value, ok := info.Labels[key]
+	return value, ok
+}
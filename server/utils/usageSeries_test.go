@@ -0,0 +1,203 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSeriesSegments(t *testing.T) {
+	at := func(clock string) time.Time {
+		parsed, err := time.Parse("15:04:05", clock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return time.Date(2024, 5, 1, parsed.Hour(), parsed.Minute(), parsed.Second(), 0, time.UTC)
+	}
+	segment := func(level int, since, until string) seriesSegment {
+		return seriesSegment{level: level, since: at(since), until: at(until)}
+	}
+
+	tests := []struct {
+		name           string
+		since, until   string
+		step           time.Duration
+		watermarks     map[string]string
+		wantResolution string
+		want           []seriesSegment
+	}{
+		{
+			name:           "step finer than the rollups",
+			since:          "12:00:10",
+			until:          "13:00:00",
+			step:           30 * time.Second,
+			watermarks:     map[string]string{ResolutionMinute: "12:50:00"},
+			wantResolution: ResolutionRaw,
+			want:           []seriesSegment{segment(-1, "12:00:00", "13:00:00")},
+		},
+		{
+			name:           "step not divisible by a rollup",
+			since:          "12:01:40",
+			until:          "13:00:00",
+			step:           90 * time.Second,
+			watermarks:     map[string]string{ResolutionMinute: "12:50:00"},
+			wantResolution: ResolutionRaw,
+			want:           []seriesSegment{segment(-1, "12:01:30", "13:00:00")},
+		},
+		{
+			name:           "never rolled up",
+			since:          "12:00:30",
+			until:          "13:00:00",
+			step:           time.Minute,
+			wantResolution: ResolutionMinute,
+			want:           []seriesSegment{segment(-1, "12:00:00", "13:00:00")},
+		},
+		{
+			name:           "partially rolled up",
+			since:          "12:00:30",
+			until:          "13:00:00",
+			step:           time.Minute,
+			watermarks:     map[string]string{ResolutionMinute: "12:40:00"},
+			wantResolution: ResolutionMinute,
+			want: []seriesSegment{
+				segment(0, "12:00:00", "12:40:00"),
+				segment(-1, "12:40:00", "13:00:00"),
+			},
+		},
+		{
+			name:           "every resolution",
+			since:          "10:30:00",
+			until:          "14:20:00",
+			step:           time.Hour,
+			watermarks:     map[string]string{ResolutionMinute: "14:10:00", ResolutionHour: "14:00:00"},
+			wantResolution: ResolutionHour,
+			want: []seriesSegment{
+				segment(1, "10:00:00", "14:00:00"),
+				segment(0, "14:00:00", "14:10:00"),
+				segment(-1, "14:10:00", "14:20:00"),
+			},
+		},
+		{
+			name:           "until within a bucket",
+			since:          "10:00:00",
+			until:          "12:30:00",
+			step:           time.Hour,
+			watermarks:     map[string]string{ResolutionMinute: "13:05:00", ResolutionHour: "13:00:00"},
+			wantResolution: ResolutionHour,
+			want: []seriesSegment{
+				segment(1, "10:00:00", "12:00:00"),
+				segment(0, "12:00:00", "12:30:00"),
+				segment(-1, "12:30:00", "12:30:00"),
+			},
+		},
+		{
+			name:           "step multiple of a rollup",
+			since:          "11:00:00",
+			until:          "16:00:00",
+			step:           2 * time.Hour,
+			watermarks:     map[string]string{ResolutionMinute: "16:00:00", ResolutionHour: "16:00:00"},
+			wantResolution: ResolutionHour,
+			want: []seriesSegment{
+				segment(1, "10:00:00", "16:00:00"),
+				segment(-1, "16:00:00", "16:00:00"),
+			},
+		},
+		{
+			name:           "rollup behind since",
+			since:          "12:00:00",
+			until:          "13:00:00",
+			step:           time.Minute,
+			watermarks:     map[string]string{ResolutionMinute: "11:00:00"},
+			wantResolution: ResolutionMinute,
+			want:           []seriesSegment{segment(-1, "12:00:00", "13:00:00")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			watermarks := make(map[string]time.Time)
+			for resolution, clock := range tt.watermarks {
+				watermarks[resolution] = at(clock)
+			}
+			query := UsageSeriesQuery{Since: at(tt.since), Until: at(tt.until), Step: tt.step}
+
+			resolution, segments := seriesSegments(query, watermarks)
+			if resolution != tt.wantResolution {
+				t.Errorf("resolution = %s, want %s", resolution, tt.wantResolution)
+			}
+			if !reflect.DeepEqual(segments, tt.want) {
+				t.Errorf("segments = %+v, want %+v", segments, tt.want)
+			}
+		})
+	}
+}
+
+func TestAlignTime(t *testing.T) {
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		t    time.Time
+		step time.Duration
+		want time.Time
+	}{
+		{t: base.Add(59 * time.Second), step: time.Minute, want: base},
+		{t: base, step: time.Minute, want: base},
+		{t: base.Add(-time.Nanosecond), step: time.Hour, want: base.Add(-time.Hour)},
+		{t: base.Add(time.Hour), step: 2 * time.Hour, want: base},
+		{t: base.Add(100 * time.Second), step: 90 * time.Second, want: base.Add(90 * time.Second)},
+		{t: base.Add(time.Second), step: 0, want: base.Add(time.Second)},
+	}
+
+	for _, tt := range tests {
+		if got := alignTime(tt.t, tt.step); !got.Equal(tt.want) {
+			t.Errorf("alignTime(%s, %v) = %s, want %s", tt.t.Format(time.TimeOnly), tt.step, got.Format(time.TimeOnly), tt.want.Format(time.TimeOnly))
+		}
+	}
+}
+
+func TestSeriesSegmentSQL(t *testing.T) {
+	metrics := []string{"cpu_percent", "cpu_throttled"}
+
+	raw := (&seriesSegment{level: -1}).sql(metrics, 4, 5)
+	for _, want := range []string{"FROM container_usage", "cpu_throttled_periods::float8 AS cpu_throttled_avg", "timestamp >= $4 AND timestamp < $5"} {
+		if !strings.Contains(raw, want) {
+			t.Errorf("raw segment query doesn't contain %q:\n%s", want, raw)
+		}
+	}
+
+	hourly := (&seriesSegment{level: 1}).sql(metrics, 6, 7)
+	for _, want := range []string{"FROM container_usage_1h", "cpu_throttled_avg", "bucket >= $6 AND bucket < $7"} {
+		if !strings.Contains(hourly, want) {
+			t.Errorf("1h segment query doesn't contain %q:\n%s", want, hourly)
+		}
+	}
+}
+
+func TestQueryUsageSeriesValidation(t *testing.T) {
+	client := &DatabaseClient{}
+	tests := []struct {
+		name  string
+		query UsageSeriesQuery
+		want  string
+	}{
+		{
+			name:  "unknown aggregation",
+			query: UsageSeriesQuery{Metrics: []string{"cpu_percent"}, Aggregation: "median"},
+			want:  `unknown aggregation "median"`,
+		},
+		{
+			name:  "unknown metric",
+			query: UsageSeriesQuery{Metrics: []string{"cpu_percent", "network_rx"}, Aggregation: "avg"},
+			want:  `unknown metric "network_rx"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := client.QueryUsageSeries(tt.query)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("QueryUsageSeries() error = %v, want %s", err, tt.want)
+			}
+		})
+	}
+}